@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"prices/pkg/app"
+	"prices/pkg/config"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportDir       string
+	exportBatchSize int
+	exportKeep      int
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write all prices to a new index file that the PricesApp can serve without a database and exit",
+	RunE: func(c *cobra.Command, args []string) error {
+		ctx, closer := context.WithCancel(context.Background())
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+
+		go func() {
+			select {
+			case <-ch:
+				closer()
+			case <-ctx.Done():
+			}
+		}()
+
+		if exportDir == "" {
+			return fmt.Errorf("index directory is not set")
+		}
+		if exportKeep < 1 {
+			return fmt.Errorf("at least one index file must be kept")
+		}
+
+		cfg := &config.FileProcessor{}
+		cfg, err := cfg.LoadConfig("files_app.yaml")
+		if err != nil {
+			return err
+		}
+
+		return app.RunExport(ctx, cfg, exportDir, exportBatchSize, exportKeep)
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportDir, "dir", "./index", "directory of index files")
+	exportCmd.Flags().IntVar(&exportBatchSize, "batch-size", 10000, "how many prices are read at once")
+	exportCmd.Flags().IntVar(&exportKeep, "keep", 2, "how many of the newest index files are kept, older ones are removed")
+}
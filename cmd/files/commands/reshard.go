@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"prices/pkg/app"
+	"prices/pkg/config"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	reshardDrain     []string
+	reshardBatchSize int
+)
+
+var reshardCmd = &cobra.Command{
+	Use:   "reshard",
+	Short: "Move prices to the shards that own them after shards were added to or removed from the config",
+	RunE: func(c *cobra.Command, args []string) error {
+		ctx, closer := context.WithCancel(context.Background())
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+
+		go func() {
+			select {
+			case <-ch:
+				closer()
+			case <-ctx.Done():
+			}
+		}()
+
+		drained := make([]config.Storage, 0, len(reshardDrain))
+		for _, d := range reshardDrain {
+			name, dsn, ok := strings.Cut(d, "=")
+			if !ok || name == "" || dsn == "" {
+				return fmt.Errorf("bad drained shard=%s, NAME=DSN expected", d)
+			}
+			drained = append(drained, config.Storage{Name: name, DSN: dsn})
+		}
+
+		cfg := &config.FileProcessor{}
+		cfg, err := cfg.LoadConfig("files_app.yaml")
+		if err != nil {
+			return err
+		}
+
+		return app.RunReshard(ctx, cfg, drained, reshardBatchSize)
+	},
+}
+
+func init() {
+	reshardCmd.Flags().StringArrayVar(&reshardDrain, "drain", nil, "shard removed from the config as NAME=DSN, all of its prices are moved to the configured shards")
+	reshardCmd.Flags().IntVar(&reshardBatchSize, "batch-size", 10000, "how many prices are moved at once")
+}
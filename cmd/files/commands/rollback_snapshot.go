@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"prices/pkg/app"
+	"prices/pkg/config"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+var rollbackSnapshotCmd = &cobra.Command{
+	Use:   "rollback-snapshot",
+	Short: "Put back the prices replaced by the last snapshot and exit",
+	RunE: func(c *cobra.Command, args []string) error {
+		ctx, closer := context.WithCancel(context.Background())
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+
+		go func() {
+			select {
+			case <-ch:
+				closer()
+			case <-ctx.Done():
+			}
+		}()
+
+		cfg := &config.FileProcessor{}
+		cfg, err := cfg.LoadConfig("files_app.yaml")
+		if err != nil {
+			return err
+		}
+
+		return app.RunSnapshotRollback(ctx, cfg)
+	},
+}
@@ -14,6 +14,10 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(startCmd)
+	rootCmd.AddCommand(reshardCmd)
+	rootCmd.AddCommand(purgeCmd)
+	rootCmd.AddCommand(rollbackSnapshotCmd)
+	rootCmd.AddCommand(exportCmd)
 }
 
 func Execute() {
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"context"
+	"os"
+	"prices/pkg/config"
+	"prices/pkg/index"
+	"prices/pkg/repository"
+)
+
+// RunExport - writes all prices of the storage to a new index file in the directory,
+// and removes the older index files except for the newest keep ones.
+func RunExport(ctx context.Context, config *config.FileProcessor, dir string, batchSize int, keep int) error {
+	logger := getLogger("Export")
+
+	logger.Sugar().Infof("init prices repo for storage=%s", config.Storage.Type)
+	pricesRepo, err := repository.NewPrices(config.Storage)
+	if err != nil {
+		logger.Sugar().Errorf("unable to init prices repo for storage=%s: (%s)", config.Storage.Type, err.Error())
+		return err
+	}
+	defer pricesRepo.Close()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Sugar().Errorf("unable to create index directory=%s: (%s)", dir, err.Error())
+		return err
+	}
+
+	w, err := index.NewWriter(dir)
+	if err != nil {
+		logger.Sugar().Errorf("unable to create index: (%s)", err.Error())
+		return err
+	}
+
+	logger.Sugar().Infof("start export to directory=%s with batch size=%d", dir, batchSize)
+	exported := 0
+	after := ""
+	for {
+		prices, err := pricesRepo.List(ctx, after, batchSize)
+		if err == nil {
+			for _, price := range prices {
+				if err = w.Add(price); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			w.Abort()
+			logger.Sugar().Errorf("unable to export prices after id=%s: (%s)", after, err.Error())
+			return err
+		}
+		exported += len(prices)
+		if len(prices) < batchSize {
+			break
+		}
+		after = prices[len(prices)-1].ID
+	}
+
+	path, err := w.Commit()
+	if err != nil {
+		logger.Sugar().Errorf("unable to commit index: (%s)", err.Error())
+		return err
+	}
+	logger.Sugar().Infof("exported prices=%d to index=%s", exported, path)
+
+	paths, err := index.Files(dir)
+	if err != nil {
+		logger.Sugar().Errorf("unable to list index files: (%s)", err.Error())
+		return err
+	}
+	for i := 0; i < len(paths)-keep; i++ {
+		if err := os.Remove(paths[i]); err != nil {
+			logger.Sugar().Errorf("unable to remove old index=%s: (%s)", paths[i], err.Error())
+			continue
+		}
+		logger.Sugar().Infof("removed old index=%s", paths[i])
+	}
+
+	return nil
+}
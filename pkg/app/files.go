@@ -2,14 +2,25 @@ package app
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"os"
 	"prices/pkg/config"
 	"prices/pkg/files"
+	"prices/pkg/files/archiver"
+	"prices/pkg/files/claims"
 	"prices/pkg/files/processor"
 	"prices/pkg/files/scanner"
 	"prices/pkg/files/splitter"
 	"prices/pkg/migrations"
+	"prices/pkg/partitions"
 	"prices/pkg/repository"
+	"prices/pkg/retention"
+	"prices/pkg/snapshots"
 	"sync"
+	"time"
+
+	"go.uber.org/zap"
 )
 
 func RunFiles(ctx context.Context, config *config.FileProcessor) error {
@@ -18,39 +29,136 @@ func RunFiles(ctx context.Context, config *config.FileProcessor) error {
 	logger.Sugar().Infof("start FilesApp")
 
 	logger.Sugar().Info("run migrations")
-	err := migrations.MigrateDB(config.Storage.DSN)
+	err := migrations.MigrateDB(config.Storage)
 	if err != nil {
 		logger.Sugar().Errorf("unable to run migrations: (%s)", err.Error())
 		return err
 	}
 
 	logger.Sugar().Infof("init prices repo for storage=%s", config.Storage.Type)
-	pricesRepo, err := repository.NewMySQLPrices(config.Storage)
+	pricesRepo, err := repository.NewPrices(config.Storage)
 	if err != nil {
 		logger.Sugar().Errorf("unable to init prices repo for storage=%s: (%s)", config.Storage.Type, err.Error())
 		return err
 	}
+	defer pricesRepo.Close()
 
 	wg := &sync.WaitGroup{}
 
 	stopScanner := make(chan bool)
 	stopSplitter := make(chan bool)
 	stopProcessor := make(chan bool)
+	stopPurger := make(chan bool)
+	stopPartitions := make(map[string]chan bool)
+	stopSnapshots := make(chan bool)
+	stopArchiver := make(chan bool)
+	stopClaims := make(chan bool)
+
+	var snapshotsRepo snapshots.PricesRepo
+	if config.Snapshots.Enabled {
+		var ok bool
+		snapshotsRepo, ok = pricesRepo.(snapshots.PricesRepo)
+		if !ok {
+			err := fmt.Errorf("storage=%s doesn't support snapshots", config.Storage.Type)
+			logger.Sugar().Errorf("unable to import snapshots: (%s)", err.Error())
+			return err
+		}
+		if err := os.MkdirAll(config.Snapshots.Dir, 0755); err != nil {
+			logger.Sugar().Errorf("unable to create snapshots directory=%s: (%s)", config.Snapshots.Dir, err.Error())
+			return err
+		}
+	}
+
+	var imports repository.Imports
+	if config.AtomicImport {
+		var ok bool
+		imports, ok = pricesRepo.(repository.Imports)
+		if !ok {
+			err := fmt.Errorf("storage=%s doesn't support atomic imports", config.Storage.Type)
+			logger.Sugar().Errorf("unable to import files atomically: (%s)", err.Error())
+			return err
+		}
+	}
+
+	var metricsSrv *http.Server
+	if config.Metrics.Enabled {
+		metricsSrv, err = serveMetrics(logger, config.Metrics.Port)
+		if err != nil {
+			logger.Sugar().Errorf("unable to serve metrics: (%s)", err.Error())
+			return err
+		}
+	}
 
 	filesQueue := files.NewFileQueueInMem(config.FilesQueueSize)
 	filesSplitQueue := files.NewFileQueueInMem(config.FilesSplitQueueSize)
 
-	filesCache := files.NewFileCacheInMem()
+	filesCache, closeFilesCache, err := newFileCache(config)
+	if err != nil {
+		logger.Sugar().Errorf("unable to init file cache=%s: (%s)", config.FileCache.Type, err.Error())
+		return err
+	}
+	defer closeFilesCache()
+
+	if config.FileClaims.Enabled {
+		if config.FileClaims.Instance == "" {
+			if config.FileClaims.Instance, err = os.Hostname(); err != nil {
+				logger.Sugar().Errorf("unable to get instance name: (%s)", err.Error())
+				return err
+			}
+		}
+		clms := claims.NewClaims(wg, logger, config, filesCache, imports, stopClaims)
+		logger.Sugar().Infof("claim files as instance=%s", config.FileClaims.Instance)
+		if err := clms.Start(); err != nil {
+			logger.Sugar().Errorf("unable to start claims of instance=%s: (%s)", config.FileClaims.Instance, err.Error())
+			return err
+		}
+		go clms.Run()
+	}
 
-	scnnr := scanner.NewScanner(wg, logger, config, filesQueue, filesSplitQueue, filesCache, stopScanner)
+	archvr := archiver.NewArchiver(wg, logger, config, filesCache, imports, stopArchiver)
+	if config.FileArchive.Enabled || imports != nil {
+		go archvr.Run()
+	}
+
+	scnnr := scanner.NewScanner(wg, logger, config, filesQueue, filesSplitQueue, filesCache, archvr, stopScanner)
 	go scnnr.Scan()
 
-	splttr := splitter.NewSplitter(wg, logger, config, filesSplitQueue, stopSplitter)
+	splttr := splitter.NewSplitter(wg, logger, config, filesSplitQueue, filesQueue, archvr, stopSplitter)
 	go splttr.Split()
 
-	prcssr := processor.NewProcessor(ctx, wg, config, filesQueue, pricesRepo, logger, stopProcessor)
+	if config.Metrics.Enabled {
+		registerQueues(logger, map[string]func() int{
+			"files":       filesQueue.Len,
+			"split_files": filesSplitQueue.Len,
+			"file_lines":  splttr.QueueLength,
+		})
+	}
+
+	prcssr := processor.NewProcessor(ctx, wg, config, filesQueue, pricesRepo, imports, archvr, filesCache, logger, stopProcessor)
 	go prcssr.Process()
 
+	if config.Retention.Enabled {
+		purger := retention.NewPurger(ctx, wg, &config.Retention, pricesRepo, logger, stopPurger)
+		go purger.Run()
+	}
+
+	if config.Partitions.Enabled {
+		for name, repo := range partitionedRepos(pricesRepo) {
+			stopPartitions[name] = make(chan bool)
+			managerLogger := logger
+			if name != "" {
+				managerLogger = logger.With(zap.String("shard", name))
+			}
+			manager := partitions.NewManager(ctx, wg, &config.Partitions, config.Retention.Period, repo, managerLogger, stopPartitions[name])
+			go manager.Run()
+		}
+	}
+
+	if config.Snapshots.Enabled {
+		importer := snapshots.NewImporter(ctx, wg, &config.Snapshots, config.Retry, snapshotsRepo, logger, stopSnapshots)
+		go importer.Run()
+	}
+
 	<-ctx.Done()
 	logger.Sugar().Infof("stopping FilesApp")
 
@@ -59,9 +167,72 @@ func RunFiles(ctx context.Context, config *config.FileProcessor) error {
 	stopScanner <- true
 	stopSplitter <- true
 	stopProcessor <- true
+	if config.Retention.Enabled {
+		stopPurger <- true
+	}
+	for _, stop := range stopPartitions {
+		stop <- true
+	}
+	if config.Snapshots.Enabled {
+		stopSnapshots <- true
+	}
+	if config.FileArchive.Enabled || imports != nil {
+		stopArchiver <- true
+	}
+	if config.FileClaims.Enabled {
+		stopClaims <- true
+	}
 
 	wg.Wait()
+
+	if metricsSrv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			logger.Sugar().Errorf("unable to stop serving metrics: (%s)", err.Error())
+		}
+	}
+
 	logger.Sugar().Infof("FilesApp stopped. Bye!")
 
 	return nil
 }
+
+// fileCache - cache of the files shared by the scanner, the archiver, the claims and the checkpoints of the processor.
+type fileCache interface {
+	scanner.FileCache
+	archiver.FileCache
+	claims.FileCache
+	processor.FileCheckpoints
+}
+
+// newFileCache - creates cache of the files found by the scanner of the type set in config, in memory by default.
+func newFileCache(cfg *config.FileProcessor) (fileCache, func(), error) {
+	if cfg.FileCache.Type == config.FileCacheStorage {
+		ledger, err := repository.NewFileLedger(cfg.Storage)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ledger, func() {
+			_ = ledger.Close()
+		}, nil
+	}
+	return files.NewFileCacheInMem(), func() {}, nil
+}
+
+// partitionedRepos - returns repos that support partitioning by shard name, the name is empty if storage is not sharded.
+func partitionedRepos(repo repository.Prices) map[string]partitions.PricesRepo {
+	repos := make(map[string]partitions.PricesRepo)
+	if sharded, ok := repo.(*repository.ShardedPrices); ok {
+		for name, shard := range sharded.Shards() {
+			if partitioned, ok := shard.(partitions.PricesRepo); ok {
+				repos[name] = partitioned
+			}
+		}
+		return repos
+	}
+	if partitioned, ok := repo.(partitions.PricesRepo); ok {
+		repos[""] = partitioned
+	}
+	return repos
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"prices/pkg/metrics"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// serveMetrics - starts the HTTP listener serving Prometheus metrics at /metrics on the port,
+// the port is bound before it returns, so a port in use fails the start of the app.
+func serveMetrics(logger *zap.Logger, port int) (*http.Server, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("can't listen on port=%d: %w", port, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	httpSrv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		logger.Sugar().Infof("start serving metrics on port=%d", port)
+		if err := httpSrv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Sugar().Errorf("can't serve metrics on port=%d: (%s)", port, err.Error())
+		}
+	}()
+
+	return httpSrv, nil
+}
+
+// registerQueues - registers gauges of lengths of the queues by their names.
+func registerQueues(logger *zap.Logger, queues map[string]func() int) {
+	for name, length := range queues {
+		if err := metrics.RegisterQueue(name, length); err != nil {
+			logger.Sugar().Errorf("can't register metrics of queue=%s: (%s)", name, err.Error())
+		}
+	}
+}
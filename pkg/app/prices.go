@@ -24,18 +24,19 @@ func RunPrices(ctx context.Context, config *config.APIServer) error {
 	logger.Sugar().Infof("start PricesApp")
 
 	logger.Sugar().Info("run migrations")
-	err := migrations.MigrateDB(config.Storage.DSN)
+	err := migrations.MigrateDB(config.Storage)
 	if err != nil {
 		logger.Sugar().Errorf("unable to run migrations: (%s)", err.Error())
 		return err
 	}
 
 	logger.Sugar().Infof("init prices repo for storage=%s", config.Storage.Type)
-	pricesRepo, err := repository.NewMySQLPrices(config.Storage)
+	pricesRepo, err := repository.NewPrices(config.Storage)
 	if err != nil {
 		logger.Sugar().Errorf("unable to init prices repo for storage=%s: (%s)", config.Storage.Type, err.Error())
 		return err
 	}
+	defer pricesRepo.Close()
 
 	r := gin.New()
 	p := ginprom.New(
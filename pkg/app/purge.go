@@ -0,0 +1,41 @@
+package app
+
+import (
+	"context"
+	"prices/pkg/config"
+	"prices/pkg/migrations"
+	"prices/pkg/repository"
+	"prices/pkg/retention"
+	"sync"
+)
+
+// RunPurge - deletes prices expired for longer than the retention period once and exits.
+func RunPurge(ctx context.Context, config *config.FileProcessor) error {
+	logger := getLogger("Purge")
+
+	logger.Sugar().Info("run migrations")
+	err := migrations.MigrateDB(config.Storage)
+	if err != nil {
+		logger.Sugar().Errorf("unable to run migrations: (%s)", err.Error())
+		return err
+	}
+
+	logger.Sugar().Infof("init prices repo for storage=%s", config.Storage.Type)
+	pricesRepo, err := repository.NewPrices(config.Storage)
+	if err != nil {
+		logger.Sugar().Errorf("unable to init prices repo for storage=%s: (%s)", config.Storage.Type, err.Error())
+		return err
+	}
+	defer pricesRepo.Close()
+
+	purger := retention.NewPurger(ctx, &sync.WaitGroup{}, &config.Retention, pricesRepo, logger, nil)
+	purged, err := purger.Purge()
+	if err != nil {
+		logger.Sugar().Errorf("unable to purge expired prices, purged=%d: (%s)", purged, err.Error())
+		return err
+	}
+
+	logger.Sugar().Infof("purged expired prices=%d", purged)
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"prices/pkg/config"
+	"prices/pkg/migrations"
+	"prices/pkg/repository"
+)
+
+// RunReshard - moves prices between the shards of the storage, so every price is stored on the shard that owns it.
+// drained - shards that were removed from the storage config, all of their prices are moved to the configured shards.
+func RunReshard(ctx context.Context, config *config.FileProcessor, drained []config.Storage, batchSize int) error {
+	logger := getLogger("Reshard")
+
+	if len(config.Storage.Shards) == 0 {
+		return fmt.Errorf("storage=%s is not sharded", config.Storage.Type)
+	}
+
+	logger.Sugar().Info("run migrations")
+	err := migrations.MigrateDB(config.Storage)
+	if err != nil {
+		logger.Sugar().Errorf("unable to run migrations: (%s)", err.Error())
+		return err
+	}
+
+	logger.Sugar().Infof("init sharded prices repo with shards=%d", len(config.Storage.Shards))
+	pricesRepo, err := repository.NewShardedPrices(config.Storage)
+	if err != nil {
+		logger.Sugar().Errorf("unable to init sharded prices repo: (%s)", err.Error())
+		return err
+	}
+	defer pricesRepo.Close()
+
+	drainedRepos := make(map[string]repository.Prices)
+	for _, shard := range drained {
+		if shard.Type == "" {
+			shard.Type = config.Storage.Type
+		}
+		if shard.MaxConnections == 0 {
+			shard.MaxConnections = config.Storage.MaxConnections
+		}
+		logger.Sugar().Infof("init prices repo for drained shard=%s", shard.Name)
+		repo, err := repository.NewPrices(shard)
+		if err != nil {
+			logger.Sugar().Errorf("unable to init prices repo for drained shard=%s: (%s)", shard.Name, err.Error())
+			return err
+		}
+		defer repo.Close()
+		drainedRepos[shard.Name] = repo
+	}
+
+	logger.Sugar().Infof("start resharding with batch size=%d", batchSize)
+	moved, err := pricesRepo.Reshard(ctx, drainedRepos, batchSize)
+	for shard, n := range moved {
+		logger.Sugar().Infof("moved prices=%d from shard=%s", n, shard)
+	}
+	if err != nil {
+		logger.Sugar().Errorf("unable to reshard: (%s)", err.Error())
+		return err
+	}
+
+	logger.Sugar().Infof("resharding done")
+
+	return nil
+}
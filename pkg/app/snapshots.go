@@ -0,0 +1,38 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"prices/pkg/config"
+	"prices/pkg/repository"
+	"prices/pkg/snapshots"
+	"sync"
+)
+
+// RunSnapshotRollback - puts the prices replaced by the last snapshot back and exits.
+func RunSnapshotRollback(ctx context.Context, config *config.FileProcessor) error {
+	logger := getLogger("SnapshotRollback")
+
+	logger.Sugar().Infof("init prices repo for storage=%s", config.Storage.Type)
+	pricesRepo, err := repository.NewPrices(config.Storage)
+	if err != nil {
+		logger.Sugar().Errorf("unable to init prices repo for storage=%s: (%s)", config.Storage.Type, err.Error())
+		return err
+	}
+	defer pricesRepo.Close()
+
+	snapshotsRepo, ok := pricesRepo.(snapshots.PricesRepo)
+	if !ok {
+		err := fmt.Errorf("storage=%s doesn't support snapshots", config.Storage.Type)
+		logger.Sugar().Errorf("unable to roll back snapshot: (%s)", err.Error())
+		return err
+	}
+
+	importer := snapshots.NewImporter(ctx, &sync.WaitGroup{}, &config.Snapshots, config.Retry, snapshotsRepo, logger, nil)
+	if err := importer.Rollback(); err != nil {
+		logger.Sugar().Errorf("unable to roll back snapshot: (%s)", err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -2,39 +2,242 @@ package config
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/spf13/viper"
 )
 
+const (
+	StorageMySQL  = "mysql"
+	StorageSQLite = "sqlite"
+	// StorageIndex - read only storage of index files exported from another storage, DSN is the directory of the files.
+	StorageIndex = "index"
+
+	// ScannerModePoll - the scanner reads the whole directory every CheckEveryDuration.
+	ScannerModePoll = "poll"
+	// ScannerModeWatch - the scanner adds files on file system events, and still reads the whole directory every CheckEveryDuration.
+	ScannerModeWatch = "watch"
+
+	// FileCacheMemory - files are kept in memory, so all files are found again after a restart.
+	FileCacheMemory = "memory"
+	// FileCacheStorage - files are kept in the files table of the storage.
+	FileCacheStorage = "storage"
+
+	// RouteSplit - files are split before they are processed, whatever their size.
+	RouteSplit = "split"
+	// RouteProcess - files are processed as they are, whatever their size.
+	RouteProcess = "process"
+
+	// SplitModeLines - split files are read and their lines are written to chunk files.
+	SplitModeLines = "lines"
+	// SplitModeRanges - split files are divided into byte ranges aligned to lines, that are processed without writing chunks.
+	// Only uncompressed csv files that are imported as they are can be divided, other files are split by lines.
+	SplitModeRanges = "ranges"
+
+	// FormatCSV - comma separated values, the delimiter and the quote can be changed.
+	FormatCSV = "csv"
+	// FormatTSV - tab separated values without quoting.
+	FormatTSV = "tsv"
+	// FormatJSONLines - a JSON object with id, price and expiration_date per line.
+	FormatJSONLines = "jsonl"
+)
+
 type (
 	Config[C any] interface {
 		LoadConfig(name string) (C, error)
 	}
 
 	FileProcessor struct {
-		FilesDir            string       `mapstructure:"FILES_DIRECTORY"`
-		FilesQueueSize      int          `mapstructure:"FILES_QUEUE_SIZE"`
-		FilesSplitQueueSize int          `mapstructure:"FILES_SPLIT_QUEUE_SIZE"`
-		MaxFileSizeBytes    int64        `mapstructure:"MAX_FILE_SIZE_BYTES"`
-		DataBatchSize       int          `mapstructure:"DATA_BATCH_SIZE"`
-		DataBatchQueueSize  int          `mapstructure:"DATA_BATCH_QUEUE_SIZE"`
-		WorkersCount        int          `mapstructure:"WORKERS_COUNT"`
-		ImportByLines       bool         `mapstructure:"IMPORT_BY_LINES"`
-		FileScanner         FileScanner  `mapstructure:"FILE_SCANNER"`
-		FileSplitter        FileSplitter `mapstructure:"FILE_SPLITTER"`
-		Storage             Storage      `mapstructure:"STORAGE"`
+		FilesDir            string        `mapstructure:"FILES_DIRECTORY"`
+		FilesQueueSize      int           `mapstructure:"FILES_QUEUE_SIZE"`
+		FilesSplitQueueSize int           `mapstructure:"FILES_SPLIT_QUEUE_SIZE"`
+		MaxFileSizeBytes    int64         `mapstructure:"MAX_FILE_SIZE_BYTES"`
+		DataBatchSize       int           `mapstructure:"DATA_BATCH_SIZE"`
+		DataBatchQueueSize  int           `mapstructure:"DATA_BATCH_QUEUE_SIZE"`
+		WorkersCount        int           `mapstructure:"WORKERS_COUNT"`
+		ImportByLines       bool          `mapstructure:"IMPORT_BY_LINES"`
+		AtomicImport        bool          `mapstructure:"ATOMIC_IMPORT"`
+		AtomicImportExpiry  time.Duration `mapstructure:"ATOMIC_IMPORT_EXPIRY"`
+		FileScanner         FileScanner   `mapstructure:"FILE_SCANNER"`
+		FileCache           FileCache     `mapstructure:"FILE_CACHE"`
+		FileArchive         FileArchive   `mapstructure:"FILE_ARCHIVE"`
+		FileClaims          FileClaims    `mapstructure:"FILE_CLAIMS"`
+		FileSplitter        FileSplitter  `mapstructure:"FILE_SPLITTER"`
+		FileFormats         []FileFormat  `mapstructure:"FILE_FORMATS"`
+		Validation          Validation    `mapstructure:"VALIDATION"`
+		Retry               Retry         `mapstructure:"RETRY"`
+		Retention           Retention     `mapstructure:"RETENTION"`
+		Partitions          Partitions    `mapstructure:"PARTITIONS"`
+		Snapshots           Snapshots     `mapstructure:"SNAPSHOTS"`
+		Metrics             Metrics       `mapstructure:"METRICS"`
+		Storage             Storage       `mapstructure:"STORAGE"`
 	}
 
 	FileScanner struct {
+		// Mode - how new files are detected, ScannerModePoll by default.
+		Mode string `mapstructure:"MODE"`
+		// CheckEveryDuration - how often the directory is scanned, in the watch mode it is a safety net for missed events.
 		CheckEveryDuration time.Duration `mapstructure:"CHECK_EVERY_DURATION"`
+		// SettleDuration - how long a file must not change after a watch event before it is added,
+		// so files that are still being written are not picked up.
+		SettleDuration time.Duration `mapstructure:"SETTLE_DURATION"`
+		// MaxDepth - how many levels of subdirectories are scanned, 0 scans only the files directory.
+		MaxDepth int `mapstructure:"MAX_DEPTH"`
+		// Include - glob patterns of files that are added, all .csv files if empty.
+		// Patterns with a slash match the path relative to the files directory, others match the file name.
+		Include []string `mapstructure:"INCLUDE"`
+		// Exclude - glob patterns of files and subdirectories that are never added, even if they are included.
+		Exclude []string `mapstructure:"EXCLUDE"`
+		// Routes - queues of the files matching the patterns, the first matching route is used.
+		// Files that match no route are split if they are bigger than MaxFileSizeBytes.
+		Routes []Route `mapstructure:"ROUTES"`
+	}
+
+	// FileCache - where the scanner keeps the files it has found.
+	FileCache struct {
+		// Type - FileCacheMemory or FileCacheStorage.
+		Type string `mapstructure:"TYPE"`
+	}
+
+	// FileArchive - where files are moved once they are processed.
+	FileArchive struct {
+		// Enabled - if not set, files and chunks of split files stay in the files directory.
+		Enabled bool `mapstructure:"ENABLED"`
+		// ProcessedDir - imported files are moved to this directory, chunks of split files are removed instead.
+		ProcessedDir string `mapstructure:"PROCESSED_DIRECTORY"`
+		// FailedDir - files that failed to import are moved to this directory with an error report next to them.
+		FailedDir string `mapstructure:"FAILED_DIRECTORY"`
+		// Compress - processed files are gzip compressed.
+		Compress bool `mapstructure:"COMPRESS"`
+		// Retention - how long processed files are kept, 0 keeps them forever.
+		Retention time.Duration `mapstructure:"RETENTION"`
+		// CleanupEveryDuration - how often processed files older than Retention are removed.
+		CleanupEveryDuration time.Duration `mapstructure:"CLEANUP_EVERY_DURATION"`
+	}
+
+	// FileClaims - claims of files by instances, so several instances can process the same files directory.
+	// A file is claimed by renaming it into the claim directory of the instance, which is atomic, so only one instance claims it.
+	FileClaims struct {
+		Enabled bool `mapstructure:"ENABLED"`
+		// Dir - claim directories of the instances are its subdirectories, it must be on the same file system as the files directory.
+		Dir string `mapstructure:"DIRECTORY"`
+		// Instance - name of the claim directory of the instance, the host name by default, it must be unique.
+		Instance string `mapstructure:"INSTANCE"`
+		// HeartbeatEveryDuration - how often the instance reports that its claims are alive, and checks claims of other instances.
+		HeartbeatEveryDuration time.Duration `mapstructure:"HEARTBEAT_EVERY_DURATION"`
+		// Expiry - claims of an instance that hasn't reported for this long are released, and their files are processed again.
+		Expiry time.Duration `mapstructure:"EXPIRY"`
+	}
+
+	// Route - sends files matching the pattern to the queue.
+	Route struct {
+		Pattern string `mapstructure:"PATTERN"`
+		// Queue - RouteSplit or RouteProcess.
+		Queue string `mapstructure:"QUEUE"`
+	}
+
+	// FileFormat - format of the files matching the pattern, the first matching format is used.
+	// Other files are decoded by their extension, .tsv, .jsonl and .ndjson, or as .csv files.
+	FileFormat struct {
+		// Pattern - glob pattern, patterns with a slash match the path relative to the files directory, others match the file name.
+		Pattern string `mapstructure:"PATTERN"`
+		// Format - FormatCSV, FormatTSV, FormatJSONLines, or a format registered with the rows package.
+		Format string `mapstructure:"FORMAT"`
+		// Delimiter - delimiter of csv fields, a comma by default.
+		Delimiter string `mapstructure:"DELIMITER"`
+		// Quote - quote of csv fields, a double quote by default.
+		Quote string `mapstructure:"QUOTE"`
+		// Header - the first row of the files has names of the columns.
+		Header bool `mapstructure:"HEADER"`
+		// Columns - columns of the price fields, other columns are ignored.
+		Columns Columns `mapstructure:"COLUMNS"`
+		// DateLayouts - Go layouts of expiration dates, or unix and unix_ms for seconds and milliseconds since the epoch,
+		// the first layout a date matches is used. Dates are "2006-01-02 15:04:05 -0700 MST" if it is empty.
+		DateLayouts []string `mapstructure:"DATE_LAYOUTS"`
+		// TimeZone - IANA name of the zone of dates without a zone, UTC by default.
+		TimeZone string `mapstructure:"TIME_ZONE"`
+	}
+
+	// Columns - names of the columns in the header, or zero based numbers of the columns for files without a header,
+	// keys of the objects for JSON Lines. Columns that are not set are id, price and expiration_date,
+	// or the first, second and third column of files without a header.
+	Columns struct {
+		ID             string `mapstructure:"ID"`
+		Price          string `mapstructure:"PRICE"`
+		ExpirationDate string `mapstructure:"EXPIRATION_DATE"`
+	}
+
+	// Validation - checks of rows of files, rejected rows are not imported and are written to a report in the failed directory.
+	Validation struct {
+		// Enabled - rows are checked, files that are not processed by lines are split, so their rows are checked before they are imported.
+		// Rows that can't be parsed are skipped even if it is not set.
+		Enabled bool `mapstructure:"ENABLED"`
+		// RequireUUID - ids must be UUIDs.
+		RequireUUID bool `mapstructure:"REQUIRE_UUID"`
+		// MaxExpirationAge - prices that expired longer ago are rejected, 0 accepts any past date.
+		MaxExpirationAge time.Duration `mapstructure:"MAX_EXPIRATION_AGE"`
+		// MaxExpirationAhead - prices that expire later than this from now are rejected, 0 accepts any date the storage can keep.
+		MaxExpirationAhead time.Duration `mapstructure:"MAX_EXPIRATION_AHEAD"`
+		// MaxRejectedPercent - files with a bigger percent of rejected rows fail, 0 fails files with any rejected row.
+		MaxRejectedPercent float64 `mapstructure:"MAX_REJECTED_PERCENT"`
 	}
 
 	FileSplitter struct {
 		WorkersCount       int `mapstructure:"WORKERS_COUNT"`
 		FileLinesQueueSize int `mapstructure:"LINES_QUEUE_SIZE"`
 		SplitByLines       int `mapstructure:"SPLIT_BY_LINES"`
+		// Mode - SplitModeLines or SplitModeRanges, SplitModeLines by default.
+		Mode string `mapstructure:"MODE"`
+		// SplitByBytes - size of byte ranges in the SplitModeRanges mode, MaxFileSizeBytes if it is 0.
+		SplitByBytes int64 `mapstructure:"SPLIT_BY_BYTES"`
+	}
+
+	// Retry - budget for retrying transient storage errors, the wait between attempts grows exponentially with jitter.
+	Retry struct {
+		// MaxAttempts - how many times an operation is tried, 0 or 1 disables retries.
+		MaxAttempts     int           `mapstructure:"MAX_ATTEMPTS"`
+		InitialInterval time.Duration `mapstructure:"INITIAL_INTERVAL"`
+		MaxInterval     time.Duration `mapstructure:"MAX_INTERVAL"`
+		// MaxElapsedTime - retries stop when the next attempt would start later than this after the first one, 0 means no limit.
+		MaxElapsedTime time.Duration `mapstructure:"MAX_ELAPSED_TIME"`
+	}
+
+	Retention struct {
+		Enabled          bool          `mapstructure:"ENABLED"`
+		Period           time.Duration `mapstructure:"PERIOD"`
+		RunEveryDuration time.Duration `mapstructure:"RUN_EVERY_DURATION"`
+		BatchSize        int           `mapstructure:"BATCH_SIZE"`
+		BatchDelay       time.Duration `mapstructure:"BATCH_DELAY"`
+		ArchiveDir       string        `mapstructure:"ARCHIVE_DIRECTORY"`
+	}
+
+	Partitions struct {
+		Enabled            bool          `mapstructure:"ENABLED"`
+		FutureMonths       int           `mapstructure:"FUTURE_MONTHS"`
+		DropExpired        bool          `mapstructure:"DROP_EXPIRED"`
+		CheckEveryDuration time.Duration `mapstructure:"CHECK_EVERY_DURATION"`
+		// MaxPartitions - how many monthly partitions are kept at most, the oldest of them are merged into one, 0 means no limit.
+		MaxPartitions int `mapstructure:"MAX_PARTITIONS"`
+	}
+
+	// Snapshots - imports of full snapshots of prices, that replace all prices at once.
+	Snapshots struct {
+		Enabled bool `mapstructure:"ENABLED"`
+		// Dir - every snapshot is a subdirectory with .CSV files, it is imported once a READY file appears in it.
+		Dir                string        `mapstructure:"DIRECTORY"`
+		CheckEveryDuration time.Duration `mapstructure:"CHECK_EVERY_DURATION"`
+		// MinRows - snapshots with fewer prices are rejected.
+		MinRows int64 `mapstructure:"MIN_ROWS"`
+		// MaxShrinkRatio - snapshots with fewer prices than (1 - MaxShrinkRatio) of the current prices are rejected, 0 disables the check.
+		MaxShrinkRatio float64 `mapstructure:"MAX_SHRINK_RATIO"`
+	}
+
+	// Metrics - HTTP listener of the FilesApp serving Prometheus metrics at /metrics.
+	Metrics struct {
+		Enabled bool `mapstructure:"ENABLED"`
+		Port    int  `mapstructure:"PORT"`
 	}
 
 	APIServer struct {
@@ -46,9 +249,54 @@ type (
 		Type           string `mapstructure:"TYPE"`
 		DSN            string `mapstructure:"DSN"`
 		MaxConnections int    `mapstructure:"MAX_CONNECTIONS"`
+		// Replicas - DSNs of read replicas of the storage at DSN, reads are spread between healthy replicas.
+		Replicas []string `mapstructure:"REPLICAS"`
+		// ReplicaCheckEveryDuration - how often replicas are pinged to check their health.
+		ReplicaCheckEveryDuration time.Duration `mapstructure:"REPLICA_CHECK_EVERY_DURATION"`
+		// Name - name of the shard, it places the shard on the hash ring, so it must not change once data is written.
+		Name string `mapstructure:"NAME"`
+		// Shards - storages that prices are spread between by the hash of the price id.
+		Shards []Storage `mapstructure:"SHARDS"`
+		// ReloadCheckEveryDuration - how often the index storage checks for a newer index file.
+		ReloadCheckEveryDuration time.Duration `mapstructure:"RELOAD_CHECK_EVERY_DURATION"`
 	}
 )
 
+// ShardStorages - returns configs of the shards, fields that are not set for a shard are taken from the parent storage.
+func (cfg Storage) ShardStorages() []Storage {
+	shards := make([]Storage, 0, len(cfg.Shards))
+	for i, shard := range cfg.Shards {
+		if shard.Name == "" {
+			shard.Name = fmt.Sprintf("shard_%d", i)
+		}
+		if shard.Type == "" {
+			shard.Type = cfg.Type
+		}
+		if shard.MaxConnections == 0 {
+			shard.MaxConnections = cfg.MaxConnections
+		}
+		if shard.ReplicaCheckEveryDuration == 0 {
+			shard.ReplicaCheckEveryDuration = cfg.ReplicaCheckEveryDuration
+		}
+		shards = append(shards, shard)
+	}
+	return shards
+}
+
+// ChunksDir - directory chunks of split files are written to, the claim directory of the instance if claims are enabled,
+// so chunks are processed by the instance that split the file.
+func (cfg *FileProcessor) ChunksDir() string {
+	if cfg.FileClaims.Enabled {
+		return cfg.FileClaims.InstanceDir()
+	}
+	return cfg.FilesDir
+}
+
+// InstanceDir - claim directory of the instance.
+func (cfg FileClaims) InstanceDir() string {
+	return filepath.Join(cfg.Dir, cfg.Instance)
+}
+
 func (cfg *FileProcessor) LoadConfig(name string) (*FileProcessor, error) {
 	viper.AddConfigPath("./configs")
 	viper.SetConfigName(name)
@@ -7,7 +7,11 @@ import (
 
 var (
 	ErrorIs = errors.Is
+	ErrorAs = errors.As
 
 	ErrPriceNotFound = fmt.Errorf("price not found")
 	ErrInternal      = fmt.Errorf("internal error")
+	ErrReadOnly      = fmt.Errorf("storage is read only")
+	// ErrTransient - storage error after which the same operation can succeed if it is retried.
+	ErrTransient = fmt.Errorf("transient storage error")
 )
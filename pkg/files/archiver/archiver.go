@@ -0,0 +1,577 @@
+package archiver
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"prices/pkg/config"
+	"prices/pkg/files"
+	"prices/pkg/metrics"
+	"prices/pkg/retry"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// ErrorReportSuffix - suffix of the error report written next to a failed file.
+	ErrorReportSuffix = ".error.txt"
+	// gzipSuffix - suffix of compressed processed files.
+	gzipSuffix = ".gz"
+
+	defaultCleanupEvery = time.Hour
+)
+
+type (
+	FileCache interface {
+		Put(file files.File) error
+		Get(key string) (files.File, bool, error)
+		Unfinished() ([]files.File, error)
+	}
+
+	Imports interface {
+		CommitImport(ctx context.Context, importID string) (int64, error)
+		RollbackImport(ctx context.Context, importID string) error
+		StaleImports(ctx context.Context, age time.Duration) ([]string, error)
+	}
+
+	// V1 - moves processed files to the processed directory and failed files to the failed directory,
+	// and removes chunks of split files once all chunks of the split file are processed.
+	// With atomic imports, the import of the file is committed before it is archived, and rolled back before it is quarantined.
+	// Safe for concurrent usage.
+	V1 struct {
+		wg      *sync.WaitGroup
+		config  *config.FileProcessor
+		cache   FileCache
+		imports Imports
+		backoff *retry.Backoff
+		logger  *zap.Logger
+		stop    <-chan bool
+
+		mu sync.Mutex
+		// parents - files that were split, by path.
+		parents map[string]*parent
+		// chunks - paths of split files by paths of their chunks, as written by the splitter,
+		// or by their byte ranges, as returned by files.File String.
+		chunks map[string]string
+	}
+
+	// parent - progress of a split file.
+	parent struct {
+		file files.File
+		// pending - chunks that are not processed yet.
+		pending int
+		// split - all chunks are written.
+		split bool
+		errs  []error
+		// ranges - ends of the imported byte ranges after the checkpoint of the file, by their offsets.
+		ranges map[int64]int64
+	}
+)
+
+func NewArchiver(
+	wg *sync.WaitGroup,
+	logger *zap.Logger,
+	config *config.FileProcessor,
+	cache FileCache,
+	imports Imports,
+	stop <-chan bool,
+) *V1 {
+	log := logger.Named("FileArchiver")
+	a := &V1{
+		wg:      wg,
+		config:  config,
+		cache:   cache,
+		imports: imports,
+		backoff: retry.NewBackoff(config.Retry),
+		logger:  log,
+		stop:    stop,
+		parents: make(map[string]*parent),
+		chunks:  make(map[string]string),
+	}
+	return a
+}
+
+// Run - removes processed files older than the retention and drops stale atomic imports every CleanupEveryDuration until stopped.
+func (a *V1) Run() {
+	a.logger.Sugar().Infof("start cleaning processed files older than=%s and imports stale for=%s", a.config.FileArchive.Retention, a.config.AtomicImportExpiry)
+	a.wg.Add(1)
+	ticker := time.NewTicker(a.cleanupEvery())
+	defer ticker.Stop()
+	a.Cleanup()
+	a.DropStale()
+	for {
+		select {
+		case <-ticker.C:
+			a.Cleanup()
+			a.DropStale()
+		case <-a.stop:
+			a.logger.Sugar().Infof("stop cleaning processed files")
+			a.wg.Done()
+			return
+		}
+	}
+}
+
+// Cleanup - removes processed files older than the retention.
+func (a *V1) Cleanup() {
+	if !a.config.FileArchive.Enabled || a.config.FileArchive.Retention <= 0 {
+		return
+	}
+	before := time.Now().Add(-a.config.FileArchive.Retention)
+	removed := 0
+	err := filepath.WalkDir(a.config.FileArchive.ProcessedDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(before) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		a.logger.Sugar().Errorf("can't clean processed files in directory=%s: (%s)", a.config.FileArchive.ProcessedDir, err.Error())
+	}
+	if removed > 0 {
+		a.logger.Sugar().Infof("removed processed files=%d", removed)
+	}
+}
+
+// DropStale - rolls back atomic imports that haven't staged prices for AtomicImportExpiry, e.g. imports of released files,
+// imports of files that are still unfinished are kept, so files resumed after a long stop are still imported whole.
+func (a *V1) DropStale() {
+	if a.imports == nil || a.config.AtomicImportExpiry <= 0 {
+		return
+	}
+	ctx := context.Background()
+	stale, err := a.imports.StaleImports(ctx, a.config.AtomicImportExpiry)
+	if err != nil {
+		a.logger.Sugar().Errorf("can't get stale imports: (%s)", err.Error())
+		return
+	}
+	if len(stale) == 0 {
+		return
+	}
+	// Unfinished files are read after the stale imports, so files that finish in between are dropped, which does nothing.
+	unfinished, err := a.cache.Unfinished()
+	if err != nil {
+		a.logger.Sugar().Errorf("can't get unfinished files from cache: (%s)", err.Error())
+		return
+	}
+	active := make(map[string]bool, len(unfinished))
+	for _, file := range unfinished {
+		active[a.ImportID(file)] = true
+	}
+	dropped := 0
+	for _, importID := range stale {
+		if active[importID] {
+			continue
+		}
+		if err := a.imports.RollbackImport(ctx, importID); err != nil {
+			a.logger.Sugar().Errorf("can't drop stale import=%s: (%s)", importID, err.Error())
+			continue
+		}
+		dropped++
+	}
+	if dropped > 0 {
+		a.logger.Sugar().Infof("dropped stale imports=%d", dropped)
+	}
+}
+
+// AddChunk - tracks the chunk of the split file, it must be called before the chunk is written or the byte range is queued.
+// Chunks are saved to the cache with the path of the split file, so they are still known as chunks after a restart,
+// when the split file is split again. Returns false if the chunk was imported before the split file was split again,
+// then it is not written again. Byte ranges are not in the cache, the imported ones are before the checkpoint of the split file.
+func (a *V1) AddChunk(file files.File, chunk files.File) bool {
+	chunk.Parent = file.Path
+	chunk.ImportID = files.ImportOf(file)
+	if chunk.Range() && chunk.Offset+chunk.Length <= file.Checkpoint {
+		a.logger.Sugar().Infof("skip range=%s, it is imported", chunk)
+		return false
+	}
+	if !chunk.Range() {
+		cached, ok, err := a.cache.Get(chunk.Path)
+		if err != nil {
+			a.logger.Sugar().Errorf("can't get chunk=%s from cache: (%s)", chunk, err.Error())
+		} else if ok && cached.Status == files.StatusImported {
+			a.logger.Sugar().Infof("skip chunk=%s, it is imported", chunk)
+			return false
+		}
+		chunk.Status = files.StatusChunk
+		if err := a.cache.Put(chunk); err != nil {
+			a.logger.Sugar().Errorf("can't add chunk=%s to cache: (%s)", chunk, err.Error())
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p, ok := a.parents[file.Path]
+	if !ok {
+		p = &parent{file: file}
+		a.parents[file.Path] = p
+	}
+	p.pending++
+	a.chunks[chunkKey(chunk)] = file.Path
+	return true
+}
+
+// Split - reports that all chunks of the file are written, or that splitting failed.
+func (a *V1) Split(file files.File, err error) {
+	a.mu.Lock()
+	p, ok := a.parents[file.Path]
+	if !ok {
+		p = &parent{file: file}
+		a.parents[file.Path] = p
+	}
+	p.split = true
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("can't split file: %w", err))
+	}
+	done := a.done(p)
+	a.mu.Unlock()
+
+	if done {
+		a.finish(p)
+	}
+}
+
+// Processed - archives the imported file, or removes it if it is a chunk of a split file.
+func (a *V1) Processed(file files.File) {
+	a.processed(file, nil)
+}
+
+// Failed - quarantines the file that failed to import, or removes it if it is a chunk of a split file,
+// then the split file is quarantined once all of its chunks are processed.
+func (a *V1) Failed(file files.File, err error) {
+	a.processed(file, err)
+}
+
+// ImportID - returns id of the atomic import the file is a part of, chunks and byte ranges of split files
+// are parts of the import of the split file. The id is saved with the file, so it is the same after restarts.
+func (a *V1) ImportID(file files.File) string {
+	if file.ImportID == "" && file.Parent == "" {
+		if parentPath, ok := a.parentPath(file); ok {
+			return files.ImportID(parentPath)
+		}
+	}
+	return files.ImportOf(file)
+}
+
+// Skipped - archives the file that is not imported, e.g. a duplicate, without changing its status.
+func (a *V1) Skipped(file files.File) {
+	if a.chunk(file, nil) {
+		return
+	}
+	a.archive(file)
+}
+
+func (a *V1) processed(file files.File, err error) {
+	if _, ok := a.parentPath(file); !ok {
+		if commitErr := a.commit(file, err); commitErr != nil {
+			err = commitErr
+		}
+	}
+	file.Status = files.StatusImported
+	if err != nil {
+		file.Status = files.StatusFailed
+	}
+	// Byte ranges are not in the cache, their split file is updated once all of its ranges are processed.
+	if !file.Range() {
+		if cacheErr := a.cache.Put(file); cacheErr != nil {
+			a.logger.Sugar().Errorf("can't update file=%s in cache: (%s)", file, cacheErr.Error())
+		}
+	}
+
+	if a.chunk(file, err) {
+		return
+	}
+	if err != nil {
+		a.quarantine(file, []error{err})
+		return
+	}
+	a.archive(file)
+}
+
+// parentPath - returns path of the split file if the file is its chunk or byte range,
+// chunks that are not tracked since a restart are known by the split file saved with them.
+func (a *V1) parentPath(file files.File) (string, bool) {
+	a.mu.Lock()
+	parentPath, ok := a.chunks[chunkKey(file)]
+	a.mu.Unlock()
+	if !ok && file.Parent != "" {
+		return file.Parent, true
+	}
+	return parentPath, ok
+}
+
+// chunkKey - returns key of the chunk in chunks, byte ranges are keyed by their range.
+func chunkKey(file files.File) string {
+	if file.Range() {
+		return file.String()
+	}
+	return file.Source
+}
+
+// commit - commits the atomic import of the file, or rolls it back if the file failed or the import can't be committed,
+// then returns the error of the commit. Does nothing if imports are not atomic.
+func (a *V1) commit(file files.File, failed error) error {
+	if a.imports == nil {
+		return nil
+	}
+	ctx := context.Background()
+	importID := files.ImportOf(file)
+	var err error
+	if failed == nil {
+		var affected int64
+		err = a.backoff.Do(ctx, func() (err error) {
+			affected, err = a.imports.CommitImport(ctx, importID)
+			return err
+		}, func(attempt int, wait time.Duration, err error) {
+			metrics.StorageRetries.Inc()
+			a.logger.Sugar().Warnf("retry committing import of file=%s in %s after attempt=%d: (%s)", file, wait, attempt, err.Error())
+		})
+		if err == nil {
+			a.logger.Sugar().Infof("committed import of file=%s, affected=%d", file, affected)
+			return nil
+		}
+		err = fmt.Errorf("can't commit import: %w", err)
+		a.logger.Sugar().Errorf("can't commit import of file=%s: (%s)", file, err.Error())
+	}
+	if rollbackErr := a.imports.RollbackImport(ctx, importID); rollbackErr != nil {
+		a.logger.Sugar().Errorf("can't roll back import of file=%s: (%s)", file, rollbackErr.Error())
+	}
+	return err
+}
+
+// chunk - if the file is a chunk of a split file, removes it and finishes the split file if it was the last chunk.
+// Byte ranges of split files are tracked the same way, but there is nothing to remove.
+// Chunks that are not tracked since a restart are removed without finishing the split file, it is split again once the instance starts.
+func (a *V1) chunk(file files.File, err error) bool {
+	key := chunkKey(file)
+	a.mu.Lock()
+	parentPath, ok := a.chunks[key]
+	if !ok {
+		a.mu.Unlock()
+		if file.Parent == "" {
+			return false
+		}
+		a.logger.Sugar().Warnf("chunk=%s of file=%s is not tracked, the file is split again once the instance starts", file, file.Parent)
+		a.remove(file)
+		return true
+	}
+	delete(a.chunks, key)
+	p := a.parents[parentPath]
+	p.pending--
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("can't import chunk=%s: %w", filepath.Base(file.String()), err))
+	}
+	if err == nil && file.Range() {
+		a.checkpoint(p, file)
+	}
+	done := a.done(p)
+	a.mu.Unlock()
+
+	a.remove(file)
+	if done {
+		a.finish(p)
+	}
+	return true
+}
+
+// checkpoint - moves the checkpoint of the split file over the imported byte range and the imported ranges right after it,
+// and saves the file to the cache, so once it is split again after a restart, the ranges before the checkpoint are skipped.
+// Must be called with the lock held.
+func (a *V1) checkpoint(p *parent, r files.File) {
+	if p.ranges == nil {
+		p.ranges = make(map[int64]int64)
+	}
+	p.ranges[r.Offset] = r.Offset + r.Length
+	checkpoint := p.file.Checkpoint
+	for end, ok := p.ranges[checkpoint]; ok; end, ok = p.ranges[checkpoint] {
+		delete(p.ranges, checkpoint)
+		checkpoint = end
+	}
+	if checkpoint == p.file.Checkpoint {
+		return
+	}
+	p.file.Checkpoint = checkpoint
+	file := p.file
+	file.Status = files.StatusSplit
+	if err := a.cache.Put(file); err != nil {
+		a.logger.Sugar().Errorf("can't save file=%s checkpoint: (%s)", file, err.Error())
+	}
+}
+
+// remove - removes the processed chunk if processed files are archived, byte ranges have nothing to remove.
+func (a *V1) remove(file files.File) {
+	if !a.config.FileArchive.Enabled || file.Range() {
+		return
+	}
+	if err := os.Remove(file.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		a.logger.Sugar().Errorf("can't remove chunk=%s: (%s)", file, err.Error())
+	}
+}
+
+// done - reports if all chunks of the split file are processed, and stops tracking it if they are.
+// Must be called with the lock held.
+func (a *V1) done(p *parent) bool {
+	if !p.split || p.pending > 0 {
+		return false
+	}
+	delete(a.parents, p.file.Path)
+	return true
+}
+
+// finish - archives the split file, or quarantines it if any of its chunks failed.
+func (a *V1) finish(p *parent) {
+	if err := a.commit(p.file, errors.Join(p.errs...)); err != nil {
+		p.errs = append(p.errs, err)
+	}
+	file := p.file
+	file.Status = files.StatusImported
+	if len(p.errs) > 0 {
+		file.Status = files.StatusFailed
+	}
+	if err := a.cache.Put(file); err != nil {
+		a.logger.Sugar().Errorf("can't update file=%s in cache: (%s)", file, err.Error())
+	}
+	if len(p.errs) > 0 {
+		a.quarantine(file, p.errs)
+		return
+	}
+	a.archive(file)
+}
+
+// archive - moves the file to the processed directory, keeping its path relative to the files directory.
+func (a *V1) archive(file files.File) {
+	if !a.config.FileArchive.Enabled {
+		return
+	}
+	dst, err := Destination(a.config, a.config.FileArchive.ProcessedDir, file)
+	if err != nil {
+		a.logger.Sugar().Errorf("can't archive file=%s: (%s)", file, err.Error())
+		return
+	}
+	if a.compress(file) {
+		err = compress(file.Path, dst+gzipSuffix)
+	} else {
+		err = os.Rename(file.Path, dst)
+	}
+	if err != nil {
+		a.logger.Sugar().Errorf("can't archive file=%s: (%s)", file, err.Error())
+		return
+	}
+	metrics.ArchivedFiles.Inc()
+	a.logger.Sugar().Infof("archived file=%s to directory=%s", file, filepath.Dir(dst))
+}
+
+// quarantine - moves the file to the failed directory and writes the errors next to it.
+func (a *V1) quarantine(file files.File, errs []error) {
+	if !a.config.FileArchive.Enabled {
+		return
+	}
+	dst, err := Destination(a.config, a.config.FileArchive.FailedDir, file)
+	if err != nil {
+		a.logger.Sugar().Errorf("can't quarantine file=%s: (%s)", file, err.Error())
+		return
+	}
+	if err := os.Rename(file.Path, dst); err != nil {
+		a.logger.Sugar().Errorf("can't quarantine file=%s: (%s)", file, err.Error())
+		return
+	}
+	if err := writeReport(dst+ErrorReportSuffix, file, errs); err != nil {
+		a.logger.Sugar().Errorf("can't write error report of file=%s: (%s)", file, err.Error())
+	}
+	metrics.QuarantinedFiles.Inc()
+	a.logger.Sugar().Warnf("quarantined file=%s to directory=%s", file, filepath.Dir(dst))
+}
+
+// compress - reports if the file is compressed when it is archived, files that are already compressed are archived as they are.
+func (a *V1) compress(file files.File) bool {
+	if !a.config.FileArchive.Compress {
+		return false
+	}
+	compression, err := files.Compression(file.Path)
+	return err == nil && compression == files.CompressionNone
+}
+
+// Destination - returns path of the file in the directory and creates its parent directories.
+// Claimed files keep their path relative to the claim directory, which is their path relative to the files directory.
+func Destination(cfg *config.FileProcessor, dir string, file files.File) (string, error) {
+	rel, err := filepath.Rel(cfg.FilesDir, file.Path)
+	if cfg.FileClaims.Enabled {
+		if claimed, claimedErr := filepath.Rel(cfg.FileClaims.InstanceDir(), file.Path); claimedErr == nil && !strings.HasPrefix(claimed, "..") {
+			rel, err = claimed, nil
+		}
+	}
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(file.Path)
+	}
+	dst := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+func (a *V1) cleanupEvery() time.Duration {
+	if a.config.FileArchive.CleanupEveryDuration <= 0 {
+		return defaultCleanupEvery
+	}
+	return a.config.FileArchive.CleanupEveryDuration
+}
+
+func compress(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+func writeReport(path string, file files.File, errs []error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(f, "file: %s\nchecksum: %s\nsize: %d\nfailed at: %s\n", file.Name, file.Checksum, file.Size, time.Now().UTC().Format(time.RFC3339))
+	for _, err := range errs {
+		_, _ = fmt.Fprintf(f, "error: %s\n", err.Error())
+	}
+	return f.Close()
+}
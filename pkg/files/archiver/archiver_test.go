@@ -0,0 +1,345 @@
+package archiver
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"prices/pkg/config"
+	"prices/pkg/files"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newTestArchiver(t *testing.T) (*V1, *files.FileCacheInMem) {
+	dir := t.TempDir()
+
+	cfg := &config.FileProcessor{
+		FilesDir: filepath.Join(dir, "files"),
+		FileArchive: config.FileArchive{
+			Enabled:      true,
+			ProcessedDir: filepath.Join(dir, "processed"),
+			FailedDir:    filepath.Join(dir, "failed"),
+			Compress:     true,
+			Retention:    time.Hour,
+		},
+	}
+	assert.NoError(t, os.MkdirAll(cfg.FilesDir, 0755))
+
+	cache := files.NewFileCacheInMem()
+	archvr := NewArchiver(&sync.WaitGroup{}, zap.NewNop(), cfg, cache, nil, make(chan bool))
+
+	return archvr, cache
+}
+
+func writeTestFile(t *testing.T, dir string, name string, content string) files.File {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return files.File{Path: path, Name: filepath.Base(path), Source: path}
+}
+
+func TestArchiver_Processed(t *testing.T) {
+	archvr, cache := newTestArchiver(t)
+
+	file := writeTestFile(t, archvr.config.FilesDir, "sub/1_test.csv", "id,1,2023-08-23 16:32:48 +0200 CEST\n")
+
+	archvr.Processed(file)
+
+	assert.NoFileExists(t, file.Path)
+
+	f, err := os.Open(filepath.Join(archvr.config.FileArchive.ProcessedDir, "sub", "1_test.csv.gz"))
+	assert.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	content, err := io.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Equal(t, "id,1,2023-08-23 16:32:48 +0200 CEST\n", string(content))
+
+	cached, ok, err := cache.Get(file.Path)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, files.StatusImported, cached.Status)
+}
+
+func TestArchiver_Failed(t *testing.T) {
+	archvr, cache := newTestArchiver(t)
+
+	file := writeTestFile(t, archvr.config.FilesDir, "1_test.csv", "bad")
+
+	archvr.Failed(file, fmt.Errorf("syntax error"))
+
+	dst := filepath.Join(archvr.config.FileArchive.FailedDir, "1_test.csv")
+	assert.NoFileExists(t, file.Path)
+	assert.FileExists(t, dst)
+
+	report, err := os.ReadFile(dst + ErrorReportSuffix)
+	assert.NoError(t, err)
+	assert.Contains(t, string(report), "error: syntax error")
+
+	cached, ok, err := cache.Get(file.Path)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, files.StatusFailed, cached.Status)
+}
+
+func TestArchiver_Chunks(t *testing.T) {
+	archvr, cache := newTestArchiver(t)
+	dir := archvr.config.FilesDir
+
+	parent := writeTestFile(t, dir, "1_test.csv", "line1\nline2\n")
+	chunk1 := writeTestFile(t, dir, files.ChunkName(0, 0, parent.Name), "line1\n")
+	chunk2 := writeTestFile(t, dir, files.ChunkName(1, 1, parent.Name), "line2\n")
+
+	archvr.AddChunk(parent, chunk1)
+	archvr.AddChunk(parent, chunk2)
+	archvr.Split(parent, nil)
+
+	archvr.Processed(chunk1)
+	assert.NoFileExists(t, chunk1.Path)
+	assert.FileExists(t, parent.Path)
+
+	archvr.Failed(chunk2, fmt.Errorf("syntax error"))
+	assert.NoFileExists(t, chunk2.Path)
+	assert.NoFileExists(t, parent.Path)
+
+	dst := filepath.Join(archvr.config.FileArchive.FailedDir, parent.Name)
+	assert.FileExists(t, dst)
+	report, err := os.ReadFile(dst + ErrorReportSuffix)
+	assert.NoError(t, err)
+	assert.Contains(t, string(report), chunk2.Name)
+
+	cached, ok, err := cache.Get(parent.Path)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, files.StatusFailed, cached.Status)
+	assert.Empty(t, archvr.parents)
+	assert.Empty(t, archvr.chunks)
+}
+
+func TestArchiver_Chunks_Restart(t *testing.T) {
+	archvr, cache := newTestArchiver(t)
+	dir := archvr.config.FilesDir
+
+	parent := writeTestFile(t, dir, "1_test.csv", "line1\nline2\n")
+	chunk1 := writeTestFile(t, dir, files.ChunkName(0, 0, parent.Name), "line1\n")
+	chunk2 := writeTestFile(t, dir, files.ChunkName(1, 1, parent.Name), "line2\n")
+
+	assert.True(t, archvr.AddChunk(parent, chunk1))
+	cached, ok, err := cache.Get(chunk1.Path)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, files.StatusChunk, cached.Status)
+	assert.Equal(t, parent.Path, cached.Parent)
+	archvr.Processed(cached)
+
+	// The archiver is restarted before the split file is done.
+	archvr, _ = newTestArchiver(t)
+	archvr.cache = cache
+	archvr.config.FilesDir = dir
+
+	// Chunks that are not tracked are still parts of the split file.
+	chunk2.Parent = parent.Path
+	assert.Equal(t, files.ImportID(parent.Path), archvr.ImportID(chunk2))
+	archvr.Processed(chunk2)
+	assert.NoFileExists(t, chunk2.Path)
+	assert.NoDirExists(t, archvr.config.FileArchive.ProcessedDir)
+
+	// The split file is split again, the imported chunks are skipped.
+	assert.False(t, archvr.AddChunk(parent, chunk1))
+	assert.False(t, archvr.AddChunk(parent, chunk2))
+	archvr.Split(parent, nil)
+
+	cached, ok, err = cache.Get(parent.Path)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, files.StatusImported, cached.Status)
+	assert.Empty(t, archvr.parents)
+}
+
+func TestArchiver_Ranges_Restart(t *testing.T) {
+	archvr, cache := newTestArchiver(t)
+	dir := archvr.config.FilesDir
+
+	parent := writeTestFile(t, dir, "1_test.csv", "line1\nline2\nline3\n")
+	parent.Status = files.StatusSplit
+	assert.NoError(t, cache.Put(parent))
+	ranges := make([]files.File, 3)
+	for i := range ranges {
+		ranges[i] = parent
+		ranges[i].Status = files.StatusQueued
+		ranges[i].Offset, ranges[i].Length = int64(6*i), 6
+		ranges[i].Parent = parent.Path
+		assert.True(t, archvr.AddChunk(parent, ranges[i]))
+	}
+	checkpoint := func() int64 {
+		cached, ok, err := cache.Get(parent.Path)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, files.StatusSplit, cached.Status)
+		return cached.Checkpoint
+	}
+
+	// The checkpoint moves once all ranges before the imported one are imported.
+	archvr.Processed(ranges[1])
+	assert.Equal(t, int64(0), checkpoint())
+	archvr.Processed(ranges[0])
+	assert.Equal(t, int64(12), checkpoint())
+
+	// The archiver is restarted before the split file is done, it is divided again from the checkpoint.
+	archvr, _ = newTestArchiver(t)
+	archvr.cache = cache
+	archvr.config.FilesDir = dir
+	parent, _, err := cache.Get(parent.Path)
+	assert.NoError(t, err)
+	assert.False(t, archvr.AddChunk(parent, ranges[0]))
+	assert.False(t, archvr.AddChunk(parent, ranges[1]))
+	assert.True(t, archvr.AddChunk(parent, ranges[2]))
+	archvr.Split(parent, nil)
+	archvr.Processed(ranges[2])
+
+	cached, ok, err := cache.Get(parent.Path)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, files.StatusImported, cached.Status)
+	assert.Equal(t, int64(18), cached.Checkpoint)
+	assert.Empty(t, archvr.parents)
+}
+
+func TestArchiver_Cleanup(t *testing.T) {
+	archvr, _ := newTestArchiver(t)
+	dir := archvr.config.FileArchive.ProcessedDir
+
+	old := writeTestFile(t, dir, "old.csv.gz", "old")
+	recent := writeTestFile(t, dir, "recent.csv.gz", "recent")
+	modified := time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, os.Chtimes(old.Path, modified, modified))
+
+	archvr.Cleanup()
+
+	assert.NoFileExists(t, old.Path)
+	assert.FileExists(t, recent.Path)
+}
+
+// testImports - records committed and rolled back imports, fails commits with err.
+type testImports struct {
+	mu         sync.Mutex
+	err        error
+	stale      []string
+	committed  []string
+	rolledBack []string
+}
+
+func (i *testImports) CommitImport(_ context.Context, importID string) (int64, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.err != nil {
+		return 0, i.err
+	}
+	i.committed = append(i.committed, importID)
+	return 1, nil
+}
+
+func (i *testImports) RollbackImport(_ context.Context, importID string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.rolledBack = append(i.rolledBack, importID)
+	return nil
+}
+
+func (i *testImports) StaleImports(_ context.Context, _ time.Duration) ([]string, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.stale, nil
+}
+
+func TestArchiver_Imports(t *testing.T) {
+	archvr, cache := newTestArchiver(t)
+	imports := &testImports{}
+	archvr.imports = imports
+	dir := archvr.config.FilesDir
+
+	processed := writeTestFile(t, dir, "1_test.csv", "line1\n")
+	failed := writeTestFile(t, dir, "2_test.csv", "bad")
+
+	archvr.Processed(processed)
+	archvr.Failed(failed, fmt.Errorf("syntax error"))
+
+	assert.Equal(t, []string{files.ImportID(processed.Path)}, imports.committed)
+	assert.Equal(t, []string{files.ImportID(failed.Path)}, imports.rolledBack)
+	assert.FileExists(t, filepath.Join(archvr.config.FileArchive.ProcessedDir, "1_test.csv.gz"))
+	assert.FileExists(t, filepath.Join(archvr.config.FileArchive.FailedDir, "2_test.csv"))
+
+	cached, ok, err := cache.Get(processed.Path)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, files.StatusImported, cached.Status)
+}
+
+func TestArchiver_Imports_Chunks(t *testing.T) {
+	archvr, _ := newTestArchiver(t)
+	imports := &testImports{}
+	archvr.imports = imports
+	dir := archvr.config.FilesDir
+
+	parent := writeTestFile(t, dir, "1_test.csv", "line1\nline2\n")
+	chunk1 := writeTestFile(t, dir, files.ChunkName(0, 0, parent.Name), "line1\n")
+	chunk2 := writeTestFile(t, dir, files.ChunkName(1, 1, parent.Name), "line2\n")
+
+	archvr.AddChunk(parent, chunk1)
+	archvr.AddChunk(parent, chunk2)
+	archvr.Split(parent, nil)
+
+	assert.Equal(t, files.ImportID(parent.Path), archvr.ImportID(chunk1))
+
+	archvr.Processed(chunk1)
+	assert.Empty(t, imports.committed)
+
+	archvr.Processed(chunk2)
+	assert.Equal(t, []string{files.ImportID(parent.Path)}, imports.committed)
+	assert.Empty(t, imports.rolledBack)
+	assert.FileExists(t, filepath.Join(archvr.config.FileArchive.ProcessedDir, "1_test.csv.gz"))
+}
+
+func TestArchiver_DropStale(t *testing.T) {
+	archvr, cache := newTestArchiver(t)
+	archvr.config.AtomicImportExpiry = time.Hour
+	queued := files.File{Path: "/data/1_test.csv", Status: files.StatusQueued, ImportID: "1111"}
+	split := files.File{Path: "/data/2_test.csv", Status: files.StatusSplit, ImportID: "2222"}
+	imported := files.File{Path: "/data/3_test.csv", Status: files.StatusImported, ImportID: "3333"}
+	for _, file := range []files.File{queued, split, imported} {
+		assert.NoError(t, cache.Put(file))
+	}
+	imports := &testImports{stale: []string{"1111", "2222", "3333", "4444"}}
+	archvr.imports = imports
+
+	archvr.DropStale()
+
+	// Imports of unfinished files are kept, e.g. of files resumed after a long stop.
+	assert.Equal(t, []string{"3333", "4444"}, imports.rolledBack)
+}
+
+func TestArchiver_Imports_CommitFailed(t *testing.T) {
+	archvr, cache := newTestArchiver(t)
+	imports := &testImports{err: fmt.Errorf("storage is down")}
+	archvr.imports = imports
+
+	file := writeTestFile(t, archvr.config.FilesDir, "1_test.csv", "line1\n")
+
+	archvr.Processed(file)
+
+	assert.Equal(t, []string{files.ImportID(file.Path)}, imports.rolledBack)
+	assert.FileExists(t, filepath.Join(archvr.config.FileArchive.FailedDir, "1_test.csv"))
+
+	cached, ok, err := cache.Get(file.Path)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, files.StatusFailed, cached.Status)
+}
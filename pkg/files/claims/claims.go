@@ -0,0 +1,303 @@
+package claims
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"prices/pkg/config"
+	"prices/pkg/files"
+	"prices/pkg/metrics"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// HeartbeatFile - file in the claim directory of the instance, its modification time is the last heartbeat of the instance.
+	HeartbeatFile = ".heartbeat"
+	// releasingPrefix - prefix of claim directories that are being released, they are hidden from the instances.
+	releasingPrefix = "."
+
+	defaultHeartbeatEvery = 10 * time.Second
+	defaultExpiry         = time.Minute
+)
+
+type (
+	FileCache interface {
+		Put(file files.File) error
+		Get(key string) (files.File, bool, error)
+	}
+
+	Imports interface {
+		RollbackImport(ctx context.Context, importID string) error
+	}
+
+	// V1 - keeps claims of the instance alive and releases expired claims of other instances.
+	// Files of released claims are moved back to the files directory, so any instance claims them again,
+	// chunks of split files are removed, because the split file is released too and split again.
+	// With atomic imports, prices staged for released files are dropped, because they are imported under new paths.
+	V1 struct {
+		wg      *sync.WaitGroup
+		config  *config.FileProcessor
+		cache   FileCache
+		imports Imports
+		logger  *zap.Logger
+		stop    <-chan bool
+	}
+)
+
+func NewClaims(
+	wg *sync.WaitGroup,
+	logger *zap.Logger,
+	config *config.FileProcessor,
+	cache FileCache,
+	imports Imports,
+	stop <-chan bool,
+) *V1 {
+	log := logger.Named("FileClaims")
+	c := &V1{
+		wg:      wg,
+		config:  config,
+		cache:   cache,
+		imports: imports,
+		logger:  log,
+		stop:    stop,
+	}
+	return c
+}
+
+// Start - creates the claim directory of the instance and reports its heartbeat, it must be called before the instance claims files.
+// Claims left by the previous run of the instance are kept, so the scanner resumes them in place, e.g. after the rows saved before.
+func (c *V1) Start() error {
+	if err := os.MkdirAll(c.config.FileClaims.InstanceDir(), 0755); err != nil {
+		return fmt.Errorf("can't create claim directory=%s: %w", c.config.FileClaims.InstanceDir(), err)
+	}
+	if err := c.Heartbeat(); err != nil {
+		return err
+	}
+	return c.resume()
+}
+
+// Run - reports heartbeats of the instance and releases expired claims every HeartbeatEveryDuration until stopped.
+func (c *V1) Run() {
+	c.logger.Sugar().Infof("start heartbeats of instance=%s", c.config.FileClaims.Instance)
+	c.wg.Add(1)
+	ticker := time.NewTicker(c.heartbeatEvery())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Heartbeat(); err != nil {
+				c.logger.Sugar().Errorf("can't report heartbeat: (%s)", err.Error())
+			}
+			c.ReleaseExpired()
+		case <-c.stop:
+			c.logger.Sugar().Infof("stop heartbeats of instance=%s", c.config.FileClaims.Instance)
+			c.wg.Done()
+			return
+		}
+	}
+}
+
+// Heartbeat - reports that claims of the instance are alive.
+func (c *V1) Heartbeat() error {
+	return touch(filepath.Join(c.config.FileClaims.InstanceDir(), HeartbeatFile))
+}
+
+// ReleaseExpired - releases claims of the instances that haven't reported a heartbeat for Expiry,
+// including claims that were being released by an instance that stopped.
+func (c *V1) ReleaseExpired() {
+	entries, err := os.ReadDir(c.config.FileClaims.Dir)
+	if err != nil {
+		c.logger.Sugar().Errorf("can't open claims directory=%s: (%s)", c.config.FileClaims.Dir, err.Error())
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == c.config.FileClaims.Instance {
+			continue
+		}
+		last, err := c.lastHeartbeat(entry)
+		if err != nil {
+			c.logger.Sugar().Errorf("can't get heartbeat of claims=%s: (%s)", entry.Name(), err.Error())
+			continue
+		}
+		if time.Since(last) < c.expiry() {
+			continue
+		}
+		c.logger.Sugar().Warnf("release claims=%s, last heartbeat at=%s", entry.Name(), last)
+		if err := c.Release(entry.Name()); err != nil {
+			c.logger.Sugar().Errorf("can't release claims=%s: (%s)", entry.Name(), err.Error())
+		}
+	}
+}
+
+// Release - moves files claimed in the claim directory back to the files directory.
+// The claim directory is renamed first, which is atomic, so only one instance releases it.
+func (c *V1) Release(name string) error {
+	dir := filepath.Join(c.config.FileClaims.Dir, name)
+	// A directory being released keeps the path of the original claim directory in its name, so claimed paths are known.
+	claimed := dir
+	if strings.HasPrefix(name, releasingPrefix) && strings.LastIndex(name, ".") > 0 {
+		claimed = filepath.Join(c.config.FileClaims.Dir, name[len(releasingPrefix):strings.LastIndex(name, ".")])
+	}
+	releasing := filepath.Join(c.config.FileClaims.Dir, fmt.Sprintf("%s%s.%d", releasingPrefix, filepath.Base(claimed), time.Now().UnixNano()))
+	if err := os.Rename(dir, releasing); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// Never claimed anything, or released by another instance.
+			return nil
+		}
+		return fmt.Errorf("can't rename claim directory=%s: %w", dir, err)
+	}
+	// The heartbeat is renewed, so the directory isn't released by another instance while it is released.
+	if err := touch(filepath.Join(releasing, HeartbeatFile)); err != nil {
+		return fmt.Errorf("can't renew heartbeat of claim directory=%s: %w", releasing, err)
+	}
+
+	released := 0
+	err := filepath.WalkDir(releasing, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || entry.Name() == HeartbeatFile {
+			return err
+		}
+		rel, err := filepath.Rel(releasing, path)
+		if err != nil {
+			return err
+		}
+		if (files.IsChunk(entry.Name()) || files.IsTemp(entry.Name())) && filepath.Dir(rel) == "." {
+			if err := c.release(filepath.Join(claimed, rel)); err != nil {
+				return err
+			}
+			return os.Remove(path)
+		}
+		if err := c.release(filepath.Join(claimed, rel)); err != nil {
+			return err
+		}
+		dst := filepath.Join(c.config.FilesDir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(path, dst); err != nil {
+			return err
+		}
+		released++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("can't release files of claim directory=%s: %w", releasing, err)
+	}
+	if err := os.RemoveAll(releasing); err != nil {
+		return fmt.Errorf("can't remove claim directory=%s: %w", releasing, err)
+	}
+	metrics.ReleasedFiles.Add(float64(released))
+	if released > 0 {
+		c.logger.Sugar().Infof("released files=%d of claims=%s", released, filepath.Base(claimed))
+	}
+	return nil
+}
+
+// resume - keeps files claimed by the previous run of the instance that are in the cache, the scanner resumes those that aren't processed.
+// Files that were claimed but never added to the cache are moved back to the files directory, and chunks that aren't in the cache are removed,
+// because their split file is split again. Chunks that are still written are removed by the splitter.
+func (c *V1) resume() error {
+	dir := c.config.FileClaims.InstanceDir()
+	released := 0
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || entry.Name() == HeartbeatFile || files.IsTemp(entry.Name()) {
+			return err
+		}
+		if _, ok, err := c.cache.Get(path); ok || err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if files.IsChunk(entry.Name()) && filepath.Dir(rel) == "." {
+			return os.Remove(path)
+		}
+		dst := filepath.Join(c.config.FilesDir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(path, dst); err != nil {
+			return err
+		}
+		released++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("can't resume files of claim directory=%s: %w", dir, err)
+	}
+	metrics.ReleasedFiles.Add(float64(released))
+	if released > 0 {
+		c.logger.Sugar().Infof("released files=%d of claims=%s that are not in the cache", released, c.config.FileClaims.Instance)
+	}
+	return nil
+}
+
+// release - marks the claimed file or chunk that wasn't processed as released in the cache,
+// so its content isn't taken for a duplicate when it is claimed again, and drops prices staged for the file.
+// Chunks are parts of the import of their split file, so it is dropped once the split file is released.
+func (c *V1) release(path string) error {
+	file, ok, err := c.cache.Get(path)
+	if err != nil {
+		return err
+	}
+	if !ok || (file.Status != files.StatusQueued && file.Status != files.StatusSplit && file.Status != files.StatusChunk) {
+		return nil
+	}
+	file.Status = files.StatusReleased
+	if err := c.cache.Put(file); err != nil {
+		return err
+	}
+	if c.imports != nil && file.Parent == "" {
+		// Prices left staged are dropped later as stale imports, so the release goes on.
+		if err := c.imports.RollbackImport(context.Background(), files.ImportOf(file)); err != nil {
+			c.logger.Sugar().Errorf("can't drop prices staged for file=%s: (%s)", file, err.Error())
+		}
+	}
+	return nil
+}
+
+// lastHeartbeat - returns time of the last heartbeat in the claim directory, or its modification time if it has none.
+func (c *V1) lastHeartbeat(entry os.DirEntry) (time.Time, error) {
+	info, err := os.Stat(filepath.Join(c.config.FileClaims.Dir, entry.Name(), HeartbeatFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		info, err = entry.Info()
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func (c *V1) heartbeatEvery() time.Duration {
+	if c.config.FileClaims.HeartbeatEveryDuration <= 0 {
+		return defaultHeartbeatEvery
+	}
+	return c.config.FileClaims.HeartbeatEveryDuration
+}
+
+func (c *V1) expiry() time.Duration {
+	if c.config.FileClaims.Expiry <= 0 {
+		return defaultExpiry
+	}
+	return c.config.FileClaims.Expiry
+}
+
+func touch(path string) error {
+	now := time.Now()
+	err := os.Chtimes(path, now, now)
+	if errors.Is(err, fs.ErrNotExist) {
+		f, createErr := os.Create(path)
+		if createErr != nil {
+			return createErr
+		}
+		return f.Close()
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+package claims
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"prices/pkg/config"
+	"prices/pkg/files"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newTestClaims(t *testing.T, instance string) (*V1, *files.FileCacheInMem) {
+	dir := t.TempDir()
+
+	cfg := &config.FileProcessor{
+		FilesDir: dir,
+		FileClaims: config.FileClaims{
+			Enabled:  true,
+			Dir:      filepath.Join(dir, "claims"),
+			Instance: instance,
+			Expiry:   time.Minute,
+		},
+	}
+
+	cache := files.NewFileCacheInMem()
+	clms := NewClaims(&sync.WaitGroup{}, zap.NewNop(), cfg, cache, nil, make(chan bool))
+
+	return clms, cache
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func TestClaims_Start(t *testing.T) {
+	clms, _ := newTestClaims(t, "instance_a")
+
+	assert.NoError(t, clms.Start())
+
+	info, err := os.Stat(filepath.Join(clms.config.FileClaims.InstanceDir(), HeartbeatFile))
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), info.ModTime(), time.Minute)
+}
+
+func TestClaims_Start_Resume(t *testing.T) {
+	clms, cache := newTestClaims(t, "instance_a")
+	dir := clms.config.FileClaims.InstanceDir()
+
+	queued := files.File{Path: filepath.Join(dir, "vendor", "1_prices.csv"), Status: files.StatusQueued, Checkpoint: 10}
+	writeTestFile(t, queued.Path, "1")
+	assert.NoError(t, cache.Put(queued))
+	chunk := files.File{Path: filepath.Join(dir, files.ChunkName(0, 1, "2_big.csv")), Status: files.StatusChunk, Parent: filepath.Join(dir, "2_big.csv")}
+	writeTestFile(t, chunk.Path, "2")
+	assert.NoError(t, cache.Put(chunk))
+	// Claimed before the previous run added them to the cache.
+	unknown := filepath.Join(dir, "vendor", "3_prices.csv")
+	writeTestFile(t, unknown, "3")
+	unknownChunk := filepath.Join(dir, files.ChunkName(0, 1, "4_big.csv"))
+	writeTestFile(t, unknownChunk, "4")
+
+	assert.NoError(t, clms.Start())
+
+	assert.FileExists(t, queued.Path)
+	assert.FileExists(t, chunk.Path)
+	assert.NoFileExists(t, unknown)
+	assert.FileExists(t, filepath.Join(clms.config.FilesDir, "vendor", "3_prices.csv"))
+	assert.NoFileExists(t, unknownChunk)
+	assert.NoFileExists(t, filepath.Join(clms.config.FilesDir, filepath.Base(unknownChunk)))
+
+	cached, ok, err := cache.Get(queued.Path)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, queued, cached)
+}
+
+func TestClaims_ReleaseExpired(t *testing.T) {
+	clms, cache := newTestClaims(t, "instance_a")
+	assert.NoError(t, clms.Start())
+
+	stalled := filepath.Join(clms.config.FileClaims.Dir, "instance_b")
+	alive := filepath.Join(clms.config.FileClaims.Dir, "instance_c")
+
+	queued := files.File{Path: filepath.Join(stalled, "vendor", "1_prices.csv"), Checksum: "1", Size: 1, Status: files.StatusQueued}
+	writeTestFile(t, queued.Path, "1")
+	assert.NoError(t, cache.Put(queued))
+	chunk := filepath.Join(stalled, files.ChunkName(0, 1, "2_big.csv"))
+	writeTestFile(t, chunk, "2")
+	writeTestFile(t, filepath.Join(stalled, HeartbeatFile), "")
+	expired := time.Now().Add(-2 * time.Minute)
+	assert.NoError(t, os.Chtimes(filepath.Join(stalled, HeartbeatFile), expired, expired))
+
+	writeTestFile(t, filepath.Join(alive, "3_prices.csv"), "3")
+	writeTestFile(t, filepath.Join(alive, HeartbeatFile), "")
+
+	clms.ReleaseExpired()
+
+	assert.NoDirExists(t, stalled)
+	assert.FileExists(t, filepath.Join(clms.config.FilesDir, "vendor", "1_prices.csv"))
+	assert.NoFileExists(t, filepath.Join(clms.config.FilesDir, filepath.Base(chunk)))
+	assert.FileExists(t, filepath.Join(alive, "3_prices.csv"))
+
+	released, ok, err := cache.Get(queued.Path)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, files.StatusReleased, released.Status)
+	_, ok, err = cache.GetByContent(queued.Checksum, queued.Size)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	entries, err := os.ReadDir(clms.config.FileClaims.Dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+// testImports - records rolled back imports.
+type testImports struct {
+	rolledBack []string
+}
+
+func (i *testImports) RollbackImport(_ context.Context, importID string) error {
+	i.rolledBack = append(i.rolledBack, importID)
+	return nil
+}
+
+func TestClaims_ReleaseExpired_Imports(t *testing.T) {
+	clms, cache := newTestClaims(t, "instance_a")
+	imports := &testImports{}
+	clms.imports = imports
+	assert.NoError(t, clms.Start())
+
+	stalled := filepath.Join(clms.config.FileClaims.Dir, "instance_b")
+	parent := files.File{Path: filepath.Join(stalled, "1_big.csv"), Status: files.StatusSplit, ImportID: "1111"}
+	writeTestFile(t, parent.Path, "1")
+	assert.NoError(t, cache.Put(parent))
+	chunk := files.File{Path: filepath.Join(stalled, files.ChunkName(0, 1, "1_big.csv")), Status: files.StatusQueued, Parent: parent.Path, ImportID: "1111"}
+	writeTestFile(t, chunk.Path, "1")
+	assert.NoError(t, cache.Put(chunk))
+	writeTestFile(t, filepath.Join(stalled, HeartbeatFile), "")
+	expired := time.Now().Add(-2 * time.Minute)
+	assert.NoError(t, os.Chtimes(filepath.Join(stalled, HeartbeatFile), expired, expired))
+
+	clms.ReleaseExpired()
+
+	// Prices staged for the split file are dropped once, chunks are parts of its import.
+	assert.Equal(t, []string{"1111"}, imports.rolledBack)
+	for _, path := range []string{parent.Path, chunk.Path} {
+		released, ok, err := cache.Get(path)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, files.StatusReleased, released.Status)
+	}
+}
+
+func TestClaims_ReleaseExpired_Releasing(t *testing.T) {
+	clms, _ := newTestClaims(t, "instance_a")
+	assert.NoError(t, clms.Start())
+
+	// Left by an instance that stopped while it was releasing claims of instance_b.
+	releasing := filepath.Join(clms.config.FileClaims.Dir, ".instance_b.1")
+	writeTestFile(t, filepath.Join(releasing, "1_prices.csv"), "1")
+	writeTestFile(t, filepath.Join(releasing, HeartbeatFile), "")
+	expired := time.Now().Add(-2 * time.Minute)
+	assert.NoError(t, os.Chtimes(filepath.Join(releasing, HeartbeatFile), expired, expired))
+
+	clms.ReleaseExpired()
+
+	assert.NoDirExists(t, releasing)
+	assert.FileExists(t, filepath.Join(clms.config.FilesDir, "1_prices.csv"))
+}
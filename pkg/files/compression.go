@@ -0,0 +1,295 @@
+package files
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressions of files.
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	// CompressionZip - zip archive, its files are read one after another as a single file.
+	CompressionZip = "zip"
+
+	// gzipOverhead - size of the gzip header and trailer.
+	gzipOverhead = 64
+	// zstdRatio - expected compression ratio of zstd frames that don't have the content size in the header.
+	zstdRatio = 5
+)
+
+var (
+	extensions = map[string]string{
+		".gz":   CompressionGzip,
+		".zst":  CompressionZstd,
+		".zstd": CompressionZstd,
+		".zip":  CompressionZip,
+	}
+	magics = map[string][]byte{
+		CompressionGzip: {0x1f, 0x8b},
+		CompressionZstd: {0x28, 0xb5, 0x2f, 0xfd},
+		CompressionZip:  {0x50, 0x4b, 0x03, 0x04},
+	}
+)
+
+// Compression - returns compression of the file by its extension, or by its first bytes if the extension is unknown.
+func Compression(path string) (string, error) {
+	if compression, ok := extensions[strings.ToLower(filepath.Ext(path))]; ok {
+		return compression, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("can't open file=%s: %w", path, err)
+	}
+	defer f.Close()
+
+	header := make([]byte, 4)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("can't read file=%s: %w", path, err)
+	}
+	for compression, magic := range magics {
+		if bytes.HasPrefix(header[:n], magic) {
+			return compression, nil
+		}
+	}
+	return CompressionNone, nil
+}
+
+// DecompressedName - returns name of the file once it is decompressed, zip archives are read as .csv files.
+func DecompressedName(name string) string {
+	ext := filepath.Ext(name)
+	switch extensions[strings.ToLower(ext)] {
+	case CompressionGzip, CompressionZstd:
+		return strings.TrimSuffix(name, ext)
+	case CompressionZip:
+		return strings.TrimSuffix(name, ext) + ".csv"
+	}
+	return name
+}
+
+// Open - opens the file for reading, compressed files are decompressed while they are read.
+func Open(path string) (io.ReadCloser, error) {
+	compression, err := Compression(path)
+	if err != nil {
+		return nil, err
+	}
+	if compression == CompressionZip {
+		return openZip(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open file=%s: %w", path, err)
+	}
+	switch compression {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("can't read gzip file=%s: %w", path, err)
+		}
+		return &readCloser{Reader: gz, close: []func() error{gz.Close, f.Close}}, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("can't read zstd file=%s: %w", path, err)
+		}
+		return &readCloser{Reader: zr, close: []func() error{func() error { zr.Close(); return nil }, f.Close}}, nil
+	}
+	return f, nil
+}
+
+// OpenRange - opens length bytes of the file from offset for reading, compressed files can't be read by ranges.
+func OpenRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	compression, err := Compression(path)
+	if err != nil {
+		return nil, err
+	}
+	if compression != CompressionNone {
+		return nil, fmt.Errorf("can't read range of %s compressed file=%s", compression, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open file=%s: %w", path, err)
+	}
+	return &readCloser{Reader: io.NewSectionReader(f, offset, length), close: []func() error{f.Close}}, nil
+}
+
+// UncompressedSize - returns estimated size of the file content once it is decompressed, the size of uncompressed files.
+// Sizes of gzip files are stored modulo 4 GiB, and deflate adds little to data it can't compress,
+// so the estimate is the smallest matching size that isn't much below the compressed size.
+// Sizes of zstd files that don't store it are estimated by a typical compression ratio.
+func UncompressedSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("can't get file=%s size: %w", path, err)
+	}
+	compression, err := Compression(path)
+	if err != nil {
+		return 0, err
+	}
+
+	switch compression {
+	case CompressionGzip:
+		size, err := gzipSize(path, info.Size())
+		if err != nil {
+			return 0, fmt.Errorf("can't get gzip file=%s size: %w", path, err)
+		}
+		return size, nil
+	case CompressionZstd:
+		size, err := zstdSize(path, info.Size())
+		if err != nil {
+			return 0, fmt.Errorf("can't get zstd file=%s size: %w", path, err)
+		}
+		return size, nil
+	case CompressionZip:
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			return 0, fmt.Errorf("can't open zip file=%s: %w", path, err)
+		}
+		defer r.Close()
+		var size int64
+		for _, f := range r.File {
+			size += int64(f.UncompressedSize64)
+		}
+		return size, nil
+	}
+	return info.Size(), nil
+}
+
+func gzipSize(path string, compressed int64) (int64, error) {
+	if compressed < 4 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	trailer := make([]byte, 4)
+	if _, err := f.ReadAt(trailer, compressed-4); err != nil {
+		return 0, err
+	}
+	size := int64(binary.LittleEndian.Uint32(trailer))
+	for size+compressed/100+gzipOverhead < compressed {
+		size += 1 << 32
+	}
+	return size, nil
+}
+
+func zstdSize(path string, compressed int64) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	header := make([]byte, zstd.HeaderMaxSize)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	var h zstd.Header
+	if err := h.Decode(header[:n]); err != nil {
+		return 0, err
+	}
+	if h.HasFCS {
+		return int64(h.FrameContentSize), nil
+	}
+	return compressed * zstdRatio, nil
+}
+
+// readCloser - decompressed stream of the file, closes the decompressor and the file.
+type readCloser struct {
+	io.Reader
+	close []func() error
+}
+
+func (r *readCloser) Close() error {
+	var err error
+	for _, c := range r.close {
+		if closeErr := c(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// zipReader - reads files of the zip archive one after another, files that don't end with a new line are ended with one,
+// so lines of different files are never joined.
+type zipReader struct {
+	archive *zip.ReadCloser
+	files   []*zip.File
+	current io.ReadCloser
+	last    byte
+}
+
+func openZip(path string) (io.ReadCloser, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open zip file=%s: %w", path, err)
+	}
+	r := &zipReader{archive: archive, last: '\n'}
+	for _, f := range archive.File {
+		if !f.FileInfo().IsDir() {
+			r.files = append(r.files, f)
+		}
+	}
+	return r, nil
+}
+
+func (r *zipReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.files) == 0 {
+				return 0, io.EOF
+			}
+			current, err := r.files[0].Open()
+			if err != nil {
+				return 0, fmt.Errorf("can't open zip file entry=%s: %w", r.files[0].Name, err)
+			}
+			r.current = current
+			r.files = r.files[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if n > 0 {
+			r.last = p[n-1]
+			return n, nil
+		}
+		if err == io.EOF {
+			_ = r.current.Close()
+			r.current = nil
+			if r.last != '\n' && len(p) > 0 {
+				r.last = '\n'
+				p[0] = '\n'
+				return 1, nil
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (r *zipReader) Close() error {
+	if r.current != nil {
+		_ = r.current.Close()
+	}
+	return r.archive.Close()
+}
@@ -0,0 +1,143 @@
+package files
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+const testCompressedData = "test_id_1,1,2023-08-24 10:01:40 +0000 UTC\ntest_id_2,2,2023-08-24 10:01:40 +0000 UTC\n"
+
+func writeGzip(t *testing.T, path string, data string) {
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	gz := gzip.NewWriter(f)
+	_, err = gz.Write([]byte(data))
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+	assert.NoError(t, f.Close())
+}
+
+func writeZstd(t *testing.T, path string, data string) {
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	// Single segment frames have the content size in the header, as written by the zstd tool.
+	zw, err := zstd.NewWriter(nil, zstd.WithSingleSegment(true))
+	assert.NoError(t, err)
+	_, err = f.Write(zw.EncodeAll([]byte(data), nil))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, f.Close())
+}
+
+func writeZip(t *testing.T, path string, entries ...string) {
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for i, data := range entries {
+		w, err := zw.Create(filepath.Join("prices", string(rune('a'+i))+".csv"))
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(data))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, f.Close())
+}
+
+func readAll(t *testing.T, path string) string {
+	r, err := Open(path)
+	assert.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestCompression(t *testing.T) {
+	dir := t.TempDir()
+
+	writeGzip(t, filepath.Join(dir, "prices.csv.gz"), testCompressedData)
+	writeZstd(t, filepath.Join(dir, "prices.csv.zst"), testCompressedData)
+	writeZip(t, filepath.Join(dir, "prices.zip"), testCompressedData)
+	// Detected by the first bytes.
+	writeGzip(t, filepath.Join(dir, "gzip.csv"), testCompressedData)
+	writeZstd(t, filepath.Join(dir, "zstd.csv"), testCompressedData)
+	writeZip(t, filepath.Join(dir, "zip.csv"), testCompressedData)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "prices.csv"), []byte(testCompressedData), 0644))
+
+	for name, expected := range map[string]string{
+		"prices.csv.gz":  CompressionGzip,
+		"prices.csv.zst": CompressionZstd,
+		"prices.zip":     CompressionZip,
+		"gzip.csv":       CompressionGzip,
+		"zstd.csv":       CompressionZstd,
+		"zip.csv":        CompressionZip,
+		"prices.csv":     CompressionNone,
+	} {
+		compression, err := Compression(filepath.Join(dir, name))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, compression, name)
+
+		assert.Equal(t, testCompressedData, readAll(t, filepath.Join(dir, name)), name)
+
+		size, err := UncompressedSize(filepath.Join(dir, name))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(testCompressedData)), size, name)
+	}
+}
+
+func TestUncompressedSize_ZstdStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.csv.zst")
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	zw, err := zstd.NewWriter(f)
+	assert.NoError(t, err)
+	_, err = zw.Write([]byte(testCompressedData))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, f.Close())
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	size, err := UncompressedSize(path)
+	assert.NoError(t, err)
+	assert.Equal(t, info.Size()*zstdRatio, size)
+	assert.Equal(t, testCompressedData, readAll(t, path))
+}
+
+func TestOpen_Zip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.zip")
+	writeZip(t, path, "test_id_1,1,2023-08-24 10:01:40 +0000 UTC", "test_id_2,2,2023-08-24 10:01:40 +0000 UTC\n")
+
+	assert.Equal(t, testCompressedData, readAll(t, path))
+}
+
+func TestOpenRange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "prices.csv")
+	assert.NoError(t, os.WriteFile(path, []byte(testCompressedData), 0644))
+
+	r, err := OpenRange(path, 42, 42)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "test_id_2,2,2023-08-24 10:01:40 +0000 UTC\n", string(data))
+
+	writeGzip(t, filepath.Join(dir, "prices.csv.gz"), testCompressedData)
+	_, err = OpenRange(filepath.Join(dir, "prices.csv.gz"), 0, 42)
+	assert.Error(t, err)
+}
+
+func TestDecompressedName(t *testing.T) {
+	assert.Equal(t, "prices.csv", DecompressedName("prices.csv.gz"))
+	assert.Equal(t, "prices.csv", DecompressedName("prices.csv.zst"))
+	assert.Equal(t, "prices.csv", DecompressedName("prices.zip"))
+	assert.Equal(t, "prices.csv", DecompressedName("prices.csv"))
+}
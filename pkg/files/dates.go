@@ -0,0 +1,55 @@
+package files
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts of expiration dates.
+const (
+	// DateLayout - layout of expiration dates in files that are imported as they are, and in chunks of split files.
+	DateLayout = "2006-01-02 15:04:05 -0700 MST"
+	// DateLayoutUnix - seconds since the Unix epoch.
+	DateLayoutUnix = "unix"
+	// DateLayoutUnixMillis - milliseconds since the Unix epoch.
+	DateLayoutUnixMillis = "unix_ms"
+)
+
+// ParseDate - parses the date with the first layout it matches, DateLayout if there are no layouts, and returns it in UTC.
+// Dates of layouts without a zone are in loc, in UTC if loc is nil.
+func ParseDate(value string, layouts []string, loc *time.Location) (time.Time, error) {
+	if len(layouts) == 0 {
+		layouts = []string{DateLayout}
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	value = strings.TrimSpace(value)
+	for _, layout := range layouts {
+		switch layout {
+		case DateLayoutUnix, DateLayoutUnixMillis:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			if layout == DateLayoutUnix {
+				return time.Unix(n, 0).UTC(), nil
+			}
+			return time.UnixMilli(n).UTC(), nil
+		default:
+			date, err := time.ParseInLocation(layout, value, loc)
+			if err != nil {
+				continue
+			}
+			return date.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("date=%s matches no layout of %s", value, strings.Join(layouts, ", "))
+}
+
+// FormatDate - formats the date with DateLayout in UTC.
+func FormatDate(date time.Time) string {
+	return date.UTC().Format(DateLayout)
+}
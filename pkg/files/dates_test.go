@@ -0,0 +1,43 @@
+package files
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDate(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NoError(t, err)
+	layouts := []string{time.RFC3339, "2006-01-02T15:04:05", DateLayoutUnixMillis, DateLayoutUnix, DateLayout}
+	expected := time.Date(2023, 8, 24, 10, 1, 40, 0, time.UTC)
+
+	for _, value := range []string{
+		"2023-08-24T10:01:40Z",
+		"2023-08-24T12:01:40+02:00",
+		"2023-08-24T12:01:40",
+		"1692871300000",
+		" 2023-08-24 06:01:40 -0400 EDT ",
+	} {
+		date, err := ParseDate(value, layouts, berlin)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, date, value)
+		assert.Equal(t, time.UTC, date.Location(), value)
+	}
+
+	date, err := ParseDate("1692871300", []string{DateLayoutUnix}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, date)
+
+	date, err = ParseDate("2023-08-24 12:01:40 +0200 CEST", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, date)
+
+	_, err = ParseDate("2023-08-24", layouts, nil)
+	assert.Error(t, err)
+}
+
+func TestFormatDate(t *testing.T) {
+	assert.Equal(t, "2023-08-24 10:01:40 +0000 UTC", FormatDate(time.Date(2023, 8, 24, 12, 1, 40, 0, time.FixedZone("CEST", 2*60*60))))
+}
@@ -1,9 +1,42 @@
 package files
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 )
 
+// Statuses of files in the FileCache.
+const (
+	// StatusQueued - file is sent to the processing queue.
+	StatusQueued = "queued"
+	// StatusSplit - file is sent to the split queue, its chunks are processed instead of it.
+	StatusSplit = "split"
+	// StatusChunk - chunk of a split file is written by the splitter, the scanner queues it once it finds it.
+	StatusChunk = "chunk"
+	// StatusDuplicate - file has the same content as a file imported before, so it is not processed.
+	StatusDuplicate = "duplicate"
+	// StatusImported - file is imported, for split files all of their chunks are imported.
+	StatusImported = "imported"
+	// StatusFailed - file failed to import, files with the same content can be imported again.
+	StatusFailed = "failed"
+	// StatusReleased - claim of the file expired before it was processed, it is moved back to be imported again.
+	StatusReleased = "released"
+)
+
+// TempSuffix - suffix of files that are still written, e.g. chunks of split files, they are never scanned.
+const TempSuffix = ".tmp"
+
+// ChunkSuffix - suffix reserved for chunks of split files, chunks are .csv files whatever the format of the split file.
+// The suffix is reserved, files of vendors must not end with it, so chunks are told apart from them by their names.
+const ChunkSuffix = ".chunk.csv"
+
 type (
 	// File - object that represents file in the file system.
 	File struct {
@@ -11,29 +44,141 @@ type (
 		Path string
 		// Name - name of the file
 		Name string
+		// Checksum - hex encoded SHA-256 of the file content
+		Checksum string
+		// Size - size of the file in bytes
+		Size int64
+		// Status - what was done with the file
+		Status string
+		// Source - path of the file before the scanner renamed it
+		Source string
+		// Offset - offset of the byte range of the split file that is processed instead of the whole file.
+		Offset int64
+		// Length - length of the byte range, the whole file is processed if it is 0.
+		Length int64
+		// Checkpoint - rows of the file read by lines that are saved to the storage, reading is resumed after them.
+		// For files split into byte ranges, bytes of the file from its start whose ranges are imported, splitting is resumed after them.
+		Checkpoint int64
+		// Parent - path of the split file of the chunk or the byte range, chunks of the split file are imported instead of it.
+		Parent string
+		// ImportID - id of the atomic import the file is a part of, chunks and byte ranges are parts of the import of the split file.
+		ImportID string
 	}
 
 	// FileQueueInMem - in memory implementation of the FileQueue.
 	// FileQueue - interface a queue of File that are going to be processed
-	// Only applicable for a single instance scanner per scanned directory, because multiple scanners can read same files multiple times.
+	// Only applicable for a single instance scanner per scanned directory, because multiple scanners can read same files multiple times,
+	// unless files are claimed by the instances (see config.FileClaims).
 	FileQueueInMem struct {
 		data chan File
 	}
 
 	// FileCacheInMem - in memory implementation of the FileCache.
 	// FileCache - interface for File cache. It saves precessed File, so they would not be processed multiple times.
-	// Only applicable for a single instance scanner per scanned directory.
-	// Not safe for concurrent usage.
+	// Only applicable for a single instance scanner per scanned directory, instances that claim files share the storage cache instead.
 	FileCacheInMem struct {
+		mu sync.RWMutex
 		// path -> File
 		data map[string]File
+		// checksum and size -> imported File
+		content map[string]File
 	}
 )
 
 func (f File) String() string {
+	if f.Range() {
+		return fmt.Sprintf("%s[%d:%d]", f.Path, f.Offset, f.Offset+f.Length)
+	}
 	return f.Path
 }
 
+// Range - reports if the file is a byte range of a split file.
+func (f File) Range() bool {
+	return f.Length > 0
+}
+
+// Open - opens the file, or its byte range, for reading.
+func (f File) Open() (io.ReadCloser, error) {
+	if f.Range() {
+		return OpenRange(f.Path, f.Offset, f.Length)
+	}
+	return Open(f.Path)
+}
+
+// ImportID - returns id of the atomic import of the file, hex encoded SHA-256 of its path.
+func ImportID(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:])
+}
+
+// ImportOf - returns id of the atomic import the file is a part of, for files saved without it,
+// the id of the split file of chunks and byte ranges, or the id of the file.
+func ImportOf(file File) string {
+	if file.ImportID != "" {
+		return file.ImportID
+	}
+	if file.Parent != "" {
+		return ImportID(file.Parent)
+	}
+	return ImportID(file.Path)
+}
+
+// Checksum - returns checksum and size of the file content.
+func Checksum(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("can't open file=%s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("can't read file=%s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// Match - reports if the path matches any of the glob patterns.
+// Patterns with a slash match the path relative to the directory, others match the name.
+func Match(dir string, patterns []string, filePath string) bool {
+	rel, err := filepath.Rel(dir, filePath)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range patterns {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ChunkName - returns name of the chunk of lines from start to end of the split file.
+func ChunkName(start int, end int, name string) string {
+	return fmt.Sprintf("%d_%d_%s%s", start, end, strings.TrimSuffix(name, ".csv"), ChunkSuffix)
+}
+
+// IsChunk - reports if the file name is a name of a chunk of a split file.
+func IsChunk(name string) bool {
+	return strings.HasSuffix(name, ChunkSuffix)
+}
+
+// TempName - returns name of the file while it is written, it is renamed to the name once it is complete.
+func TempName(name string) string {
+	return name + TempSuffix
+}
+
+// IsTemp - reports if the file name is a name of a file that is still written.
+func IsTemp(name string) bool {
+	return strings.HasSuffix(name, TempSuffix)
+}
+
 func NewFileQueueInMem(size int) *FileQueueInMem {
 	return &FileQueueInMem{
 		data: make(chan File, size),
@@ -76,23 +221,95 @@ func (q *FileQueueInMem) Empty() bool {
 	return len(q.data) == 0
 }
 
+// Len - returns number of files waiting in the queue.
+func (q *FileQueueInMem) Len() int {
+	return len(q.data)
+}
+
 func NewFileCacheInMem() *FileCacheInMem {
 	return &FileCacheInMem{
-		data: make(map[string]File),
+		data:    make(map[string]File),
+		content: make(map[string]File),
 	}
 }
 
 func (c *FileCacheInMem) Put(file File) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.data[file.Path] = file
+	if file.Checksum == "" {
+		return nil
+	}
+	key := contentKey(file.Checksum, file.Size)
+	if file.Status == StatusImported {
+		c.content[key] = file
+	} else if c.content[key].Path == file.Path {
+		delete(c.content, key)
+	}
 	return nil
 }
 
 // Get - gets File from cache by path
 func (c *FileCacheInMem) Get(path string) (File, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	file, ok := c.data[path]
 	return file, ok, nil
 }
 
+// GetByContent - gets imported File from cache by checksum and size of its content
+func (c *FileCacheInMem) GetByContent(checksum string, size int64) (File, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	file, ok := c.content[contentKey(checksum, size)]
+	return file, ok, nil
+}
+
+// Checkpoint - saves rows of the file that are saved to the storage, the checkpoint never goes back.
+func (c *FileCacheInMem) Checkpoint(file File, rows int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.data[file.Path]
+	if !ok || cached.Checkpoint >= rows {
+		return nil
+	}
+	cached.Checkpoint = rows
+	c.data[file.Path] = cached
+	return nil
+}
+
+// Unfinished - gets files that are queued or split but not processed yet, and chunks that are written but not queued yet.
+func (c *FileCacheInMem) Unfinished() ([]File, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var unfinished []File
+	for _, file := range c.data {
+		if file.Status == StatusQueued || file.Status == StatusSplit || file.Status == StatusChunk {
+			unfinished = append(unfinished, file)
+		}
+	}
+	return unfinished, nil
+}
+
+// Chunks - gets chunks of the split file.
+func (c *FileCacheInMem) Chunks(parent string) ([]File, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var chunks []File
+	for _, file := range c.data {
+		if file.Parent == parent {
+			chunks = append(chunks, file)
+		}
+	}
+	return chunks, nil
+}
+
 func (c *FileCacheInMem) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return len(c.data)
 }
+
+func contentKey(checksum string, size int64) string {
+	return fmt.Sprintf("%s:%d", checksum, size)
+}
@@ -1,6 +1,8 @@
 package files
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -74,6 +76,13 @@ func TestFileQueueInMem_Empty_False(t *testing.T) {
 	assert.False(t, empty)
 }
 
+func TestFileQueueInMem_Len(t *testing.T) {
+	files := newTestFileQueueInMem()
+	assert.Equal(t, 0, files.Len())
+	files.data <- File{Path: "test"}
+	assert.Equal(t, 1, files.Len())
+}
+
 func newTestFileCacheInMem() *FileCacheInMem {
 	cache := NewFileCacheInMem()
 	return cache
@@ -118,3 +127,61 @@ func TestFileCacheInMem_Len_Empty(t *testing.T) {
 	l := cache.Len()
 	assert.Equal(t, 0, l)
 }
+
+func TestFileCacheInMem_GetByContent(t *testing.T) {
+	cache := newTestFileCacheInMem()
+	testFile := File{Path: "test", Checksum: "checksum", Size: 10, Status: StatusQueued}
+	err := cache.Put(testFile)
+	assert.NoError(t, err)
+
+	// Files that are not imported yet are not originals.
+	_, ok, err := cache.GetByContent("checksum", 10)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	testFile.Status = StatusImported
+	assert.NoError(t, cache.Put(testFile))
+	// Duplicates don't replace the original.
+	assert.NoError(t, cache.Put(File{Path: "copy", Checksum: "checksum", Size: 10, Status: StatusDuplicate}))
+
+	file, ok, err := cache.GetByContent("checksum", 10)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, testFile, file)
+
+	_, ok, err = cache.GetByContent("checksum", 11)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	testFile.Status = StatusFailed
+	assert.NoError(t, cache.Put(testFile))
+	_, ok, err = cache.GetByContent("checksum", 10)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestFileCacheInMem_Checkpoint(t *testing.T) {
+	cache := newTestFileCacheInMem()
+	testFile := File{Path: "test", Status: StatusQueued}
+	assert.NoError(t, cache.Put(testFile))
+	assert.NoError(t, cache.Put(File{Path: "imported", Status: StatusImported}))
+
+	assert.NoError(t, cache.Checkpoint(testFile, 20))
+	assert.NoError(t, cache.Checkpoint(testFile, 10))
+
+	unfinished, err := cache.Unfinished()
+	assert.NoError(t, err)
+	testFile.Checkpoint = 20
+	assert.Equal(t, []File{testFile}, unfinished)
+}
+
+func TestChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.csv")
+	err := os.WriteFile(path, []byte("test"), 0644)
+	assert.NoError(t, err)
+
+	checksum, size, err := Checksum(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", checksum)
+	assert.Equal(t, int64(4), size)
+}
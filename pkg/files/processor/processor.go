@@ -1,23 +1,30 @@
-//go:generate mockgen -source processor.go -destination repository_mock.go -package processor PricesRepo
+//go:generate mockgen -source processor.go -destination repository_mock.go -package processor PricesRepo,FileArchive
 
 package processor
 
 import (
 	"context"
-	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
-	"os"
 	"prices/pkg/config"
 	"prices/pkg/files"
+	"prices/pkg/files/rows"
+	"prices/pkg/files/validation"
+	"prices/pkg/metrics"
 	"prices/pkg/models"
+	"prices/pkg/retry"
 	"sync"
 	"time"
 
-	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 )
 
+const (
+	// maxLoggedWarnings - how many warnings of an imported file are logged, all of them are counted.
+	maxLoggedWarnings = 10
+)
+
 type (
 	FileQueue interface {
 		Data() (<-chan files.File, error)
@@ -25,20 +32,71 @@ type (
 
 	PricesRepo interface {
 		CreateMany(ctx context.Context, prices []*models.Price) error
-		ImportFile(ctx context.Context, filePath string) error
+		ImportFile(ctx context.Context, filePath string) (*models.ImportResult, error)
+		ImportRange(ctx context.Context, filePath string, offset int64, length int64) (*models.ImportResult, error)
+	}
+
+	// ImportsRepo - stages prices of files if imports are atomic, the archiver commits them once the file is processed.
+	ImportsRepo interface {
+		StageMany(ctx context.Context, importID string, prices []*models.Price) error
+		StageFile(ctx context.Context, importID string, filePath string, offset int64, length int64) (*models.ImportResult, error)
+	}
+
+	FileArchive interface {
+		Processed(file files.File)
+		Failed(file files.File, err error)
+		ImportID(file files.File) string
+	}
+
+	FileCheckpoints interface {
+		Checkpoint(file files.File, rows int64) error
 	}
 
 	V1 struct {
-		ctx     context.Context
-		wg      *sync.WaitGroup
-		wgRead  *sync.WaitGroup
-		wgWrite *sync.WaitGroup
-		config  *config.FileProcessor
-		data    chan []*models.Price
-		files   FileQueue
-		repo    PricesRepo
-		logger  *zap.Logger
-		stop    <-chan bool
+		ctx         context.Context
+		wg          *sync.WaitGroup
+		wgRead      *sync.WaitGroup
+		wgWrite     *sync.WaitGroup
+		config      *config.FileProcessor
+		data        chan batch
+		files       FileQueue
+		repo        PricesRepo
+		imports     ImportsRepo
+		archive     FileArchive
+		checkpoints FileCheckpoints
+		validator   *validation.Validator
+		backoff     *retry.Backoff
+		logger      *zap.Logger
+		stop        <-chan bool
+
+		progressMu sync.Mutex
+		// progress - progress of files read by lines, by path, and by path and range for byte ranges of split files.
+		progress map[string]*fileProgress
+	}
+
+	// batch - prices read from the file.
+	batch struct {
+		file     files.File
+		importID string
+		prices   []*models.Price
+		// seq - number of the batch in the file, starting from 1.
+		seq int
+		// rows - rows of the file read up to the end of the batch, rejected rows included.
+		rows int64
+	}
+
+	// fileProgress - progress of the file read by lines, the file is processed once it is read and all of its batches are saved.
+	fileProgress struct {
+		reading  bool
+		pending  int
+		err      error
+		importID string
+		// sent - batches sent to the processing workers.
+		sent int
+		// saved - rows of the batches saved after a batch that isn't saved yet, by their seq.
+		saved map[int]int64
+		// committed - seq of the last batch saved together with all batches before it.
+		committed int
 	}
 )
 
@@ -48,6 +106,9 @@ func NewProcessor(
 	config *config.FileProcessor,
 	files FileQueue,
 	repo PricesRepo,
+	imports ImportsRepo,
+	archive FileArchive,
+	checkpoints FileCheckpoints,
 	logger *zap.Logger,
 	stop <-chan bool,
 ) *V1 {
@@ -55,16 +116,22 @@ func NewProcessor(
 	wgRead := &sync.WaitGroup{}
 	wgWrite := &sync.WaitGroup{}
 	p := &V1{
-		wg:      wg,
-		wgRead:  wgRead,
-		wgWrite: wgWrite,
-		ctx:     ctx,
-		config:  config,
-		data:    make(chan []*models.Price, config.DataBatchQueueSize),
-		files:   files,
-		repo:    repo,
-		logger:  log,
-		stop:    stop,
+		wg:          wg,
+		wgRead:      wgRead,
+		wgWrite:     wgWrite,
+		ctx:         ctx,
+		config:      config,
+		data:        make(chan batch, config.DataBatchQueueSize),
+		files:       files,
+		repo:        repo,
+		imports:     imports,
+		archive:     archive,
+		checkpoints: checkpoints,
+		validator:   validation.NewValidator(config),
+		backoff:     retry.NewBackoff(config.Retry),
+		logger:      log,
+		stop:        stop,
+		progress:    make(map[string]*fileProgress),
 	}
 	return p
 }
@@ -97,11 +164,23 @@ func (p *V1) ProcessLines() {
 func (p *V1) saveLines() {
 	defer p.wgWrite.Done()
 	p.logger.Sugar().Info("start processing worker")
-	for prices := range p.data {
-		err := p.repo.CreateMany(p.ctx, prices)
+	for b := range p.data {
+		err := p.withRetry("data batch", func() error {
+			started := time.Now()
+			defer func() {
+				metrics.BatchSaveDuration.Observe(time.Since(started).Seconds())
+			}()
+			if p.imports != nil {
+				return p.imports.StageMany(p.ctx, b.importID, b.prices)
+			}
+			return p.repo.CreateMany(p.ctx, b.prices)
+		})
 		if err != nil {
-			p.logger.Sugar().Errorf("worker unable to process data item: (%s)", err.Error())
+			p.failedBatch(b, err)
+		} else {
+			metrics.InsertedRows.Add(float64(len(b.prices)))
 		}
+		p.batchDone(b, err)
 	}
 	p.logger.Sugar().Info("stop processing worker")
 }
@@ -121,76 +200,95 @@ func (p *V1) readFilesByLines() {
 	p.logger.Sugar().Info("stop reading files")
 }
 
+// readFileByLines - reads the file and sends its prices to the processing workers in batches,
+// rows up to the checkpoint of the file are saved before the application stopped, so they are skipped.
 func (p *V1) readFileByLines(file files.File) {
 	defer p.wgRead.Done()
 	p.logger.Sugar().Infof("start reading file=%s", file)
-	f, err := os.Open(file.Path)
+	if file.Checkpoint > 0 {
+		p.logger.Sugar().Infof("resume reading file=%s after rows=%d", file, file.Checkpoint)
+	}
+	p.startFile(file)
+	format := rows.Format(p.config, file)
+	validated := p.validator.File(file, format)
+	// Rows of chunks are validated when the file is split.
+	if p.config.Validation.Enabled && !rows.Chunk(p.config, file) {
+		var err error
+		if validated, err = p.validator.Check(file, format); err != nil {
+			p.logger.Sugar().Errorf("bad file=%s data: (%s)", file, err.Error())
+			p.readDone(file, err)
+			return
+		}
+	}
+	f, err := file.Open()
 	if err != nil {
 		p.logger.Sugar().Errorf("can't open file=%s: (%s)", file, err.Error())
+		p.readDone(file, err)
+		return
+	}
+	defer f.Close()
+	reader, err := rows.NewDecoder(f, format)
+	if err != nil {
+		p.logger.Sugar().Errorf("can't decode file=%s: (%s)", file, err.Error())
+		p.readDone(file, err)
+		return
 	}
-	reader := csv.NewReader(f)
 	var prices []*models.Price
+	var read int64
 	for {
 		line, err := reader.Read()
-		if err != nil {
+		var rowErr *rows.RowError
+		if err != nil && !errors.As(err, &rowErr) {
 			if err == io.EOF {
+				if len(prices) > 0 {
+					p.send(file, prices, read)
+				}
 				p.logger.Sugar().Infof("done reading file=%s", file)
-				_ = f.Close()
+				err = validated.Close()
+				if err != nil {
+					p.logger.Sugar().Errorf("bad file=%s data: (%s)", file, err.Error())
+				}
+				p.readDone(file, err)
 				break
 			}
 			p.logger.Sugar().Errorf("can't read file=%s data: (%s)", file, err.Error())
+			_ = validated.Close()
+			p.readDone(file, err)
 			return
 		}
-		if price := p.toPrice(file.Path, line); price != nil {
-			prices = append(prices, price)
-			if len(prices) == p.config.DataBatchSize {
-				p.logger.Sugar().Infof("send file=%s data batch to processing", file)
-				p.data <- prices
-				prices = nil
-			}
+		read++
+		if read <= file.Checkpoint {
+			validated.Skip()
+			continue
+		}
+		if rowErr != nil {
+			validated.Reject(rowErr.Row, rowErr.Err)
+			p.logger.Sugar().Errorf("bad file=%s data: (%s)", file, rowErr.Error())
+			continue
+		}
+		price, err := validated.Validate(line)
+		if err != nil {
+			p.logger.Sugar().Errorf("bad file=%s data: (%s)", file, err.Error())
+			continue
+		}
+		prices = append(prices, price)
+		if len(prices) == p.config.DataBatchSize {
+			p.send(file, prices, read)
+			prices = nil
 		}
 	}
 }
 
-func (p *V1) toPrice(path string, line []string) *models.Price {
-	price := &models.Price{}
-	if len(line) > 3 {
-		p.logger.Sugar().Errorf("bad file=%s format, only 3 columns expected", path)
-		return nil
-	}
-
-	id, priceData, expirationDate := line[0], line[1], line[2]
-
-	price.ID = id
-	parsedPrice, err := p.parsePriceData(priceData)
-	if err != nil {
-		p.logger.Sugar().Errorf("bad file=%s data, cant parse price: (%s)", path, err.Error())
-		return nil
-	}
-	price.Price = *parsedPrice
-	expDate, err := p.parseExpirationDate(expirationDate)
-	if err != nil {
-		p.logger.Sugar().Errorf("bad file=%s data, cant parse expirationDate: (%s)", path, err.Error())
-		return nil
-	}
-	price.ExpirationDate = *expDate
-	return price
-}
-
-func (p *V1) parsePriceData(priceData string) (*decimal.Decimal, error) {
-	price, err := decimal.NewFromString(priceData)
-	if err != nil {
-		return nil, fmt.Errorf("can't parse price data=%s as a floating point number", priceData)
-	}
-	return &price, nil
-}
-
-func (p *V1) parseExpirationDate(expirationDate string) (*time.Time, error) {
-	expDate, err := time.Parse("2006-01-02 15:04:05 -0700 MST", expirationDate)
-	if err != nil {
-		return nil, fmt.Errorf("can't parse expiration date=%s as a timestamp", expirationDate)
-	}
-	return &expDate, nil
+// send - sends the batch of the file, ending at the row, to the processing workers.
+func (p *V1) send(file files.File, prices []*models.Price, rows int64) {
+	p.logger.Sugar().Infof("send file=%s data batch to processing", file)
+	p.progressMu.Lock()
+	progress := p.progress[file.String()]
+	progress.pending++
+	progress.sent++
+	seq := progress.sent
+	p.progressMu.Unlock()
+	p.data <- batch{file: file, importID: progress.importID, prices: prices, seq: seq, rows: rows}
 }
 
 func (p *V1) ProcessFiles() {
@@ -211,10 +309,78 @@ func (p *V1) saveFiles() {
 	}
 	for file := range data {
 		p.logger.Sugar().Infof("save file=%s to storage", file)
-		err := p.repo.ImportFile(p.ctx, file.Path)
+		var result *models.ImportResult
+		err := p.withRetry(fmt.Sprintf("file=%s", file), func() (err error) {
+			started := time.Now()
+			defer func() {
+				metrics.FileImportDuration.Observe(time.Since(started).Seconds())
+			}()
+			if p.imports != nil {
+				result, err = p.imports.StageFile(p.ctx, p.importID(file), file.Path, file.Offset, file.Length)
+				return err
+			}
+			if file.Range() {
+				result, err = p.repo.ImportRange(p.ctx, file.Path, file.Offset, file.Length)
+				return err
+			}
+			result, err = p.repo.ImportFile(p.ctx, file.Path)
+			return err
+		})
 		if err != nil {
-			p.logger.Sugar().Errorf("worker unable to process file=%s: (%s)", file, err.Error())
+			p.failedFile(file, err)
+			p.archive.Failed(file, err)
+			continue
 		}
+		p.imported(file, result)
+		p.archive.Processed(file)
 	}
 	p.logger.Info("stop save files worker")
 }
+
+// importID - returns id of the atomic import of the file, empty if imports are not atomic.
+func (p *V1) importID(file files.File) string {
+	if p.imports == nil {
+		return ""
+	}
+	return p.archive.ImportID(file)
+}
+
+// withRetry - runs save, retrying it while the storage fails with transient errors and the retry budget allows.
+func (p *V1) withRetry(what string, save func() error) error {
+	return p.backoff.Do(p.ctx, save, func(attempt int, wait time.Duration, err error) {
+		metrics.StorageRetries.Inc()
+		p.logger.Sugar().Warnf("retry saving %s in %s after attempt=%d: (%s)", what, wait, attempt, err.Error())
+	})
+}
+
+// imported - logs and counts rows and warnings of the imported file.
+func (p *V1) imported(file files.File, result *models.ImportResult) {
+	metrics.ImportedFiles.Inc()
+	metrics.ImportedRows.Add(float64(result.RowsAffected))
+	metrics.SkippedRows.Add(float64(result.RowsSkipped))
+	p.logger.Sugar().Infof(
+		"imported file=%s, records=%d, affected=%d, skipped=%d, warnings=%d",
+		file, result.Records, result.RowsAffected, result.RowsSkipped, len(result.Warnings),
+	)
+	for i, warning := range result.Warnings {
+		metrics.ImportWarnings.WithLabelValues(warning.Level).Inc()
+		if i < maxLoggedWarnings {
+			p.logger.Sugar().Warnf("file=%s import %s %d: %s", file, warning.Level, warning.Code, warning.Message)
+		}
+	}
+	if len(result.Warnings) > maxLoggedWarnings {
+		p.logger.Sugar().Warnf("file=%s import has %d more warnings", file, len(result.Warnings)-maxLoggedWarnings)
+	}
+}
+
+// failedBatch - failure path of a data batch that couldn't be saved even after retries.
+func (p *V1) failedBatch(b batch, err error) {
+	metrics.FailedBatches.Inc()
+	p.logger.Sugar().Errorf("worker unable to process data batch of %d prices of file=%s: (%s)", len(b.prices), b.file, err.Error())
+}
+
+// failedFile - failure path of a file that couldn't be imported even after retries.
+func (p *V1) failedFile(file files.File, err error) {
+	metrics.FailedFiles.Inc()
+	p.logger.Sugar().Errorf("worker unable to process file=%s: (%s)", file, err.Error())
+}
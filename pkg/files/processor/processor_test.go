@@ -5,8 +5,11 @@ import (
 	"encoding/csv"
 	"fmt"
 	"os"
+	"path/filepath"
 	"prices/pkg/config"
+	"prices/pkg/errors"
 	"prices/pkg/files"
+	"prices/pkg/files/validation"
 	"prices/pkg/models"
 	"prices/pkg/testutils"
 	"sync"
@@ -41,10 +44,11 @@ func newTestLineProcessor(t *testing.T) (*V1, chan bool) {
 	ctrl := gomock.NewController(t)
 
 	repo := NewMockPricesRepo(ctrl)
+	archive := NewMockFileArchive(ctrl)
 
 	stop := make(chan bool)
 
-	prcssr := NewProcessor(ctx, wg, cfg, fls, repo, log, stop)
+	prcssr := NewProcessor(ctx, wg, cfg, fls, repo, nil, archive, files.NewFileCacheInMem(), log, stop)
 
 	return prcssr, stop
 }
@@ -64,6 +68,11 @@ func newTestFileProcessor(t *testing.T) (*V1, chan bool) {
 		DataBatchSize:      1,
 		DataBatchQueueSize: 1,
 		ImportByLines:      false,
+		Retry: config.Retry{
+			MaxAttempts:     3,
+			InitialInterval: time.Millisecond,
+			MaxInterval:     time.Millisecond,
+		},
 	}
 
 	ctx := context.Background()
@@ -71,104 +80,196 @@ func newTestFileProcessor(t *testing.T) (*V1, chan bool) {
 	ctrl := gomock.NewController(t)
 
 	repo := NewMockPricesRepo(ctrl)
+	archive := NewMockFileArchive(ctrl)
 
 	stop := make(chan bool)
 
-	prcssr := NewProcessor(ctx, wg, cfg, fls, repo, log, stop)
+	prcssr := NewProcessor(ctx, wg, cfg, fls, repo, nil, archive, files.NewFileCacheInMem(), log, stop)
 
 	return prcssr, stop
 }
 
-func TestProcessor_ParsePriceData(t *testing.T) {
-	prcssr, _ := newTestFileProcessor(t)
+// testPrice - converts the line to a price the way the validator of the processor does.
+func testPrice(t *testing.T, p *V1, line []string) *models.Price {
+	price, err := p.validator.Validate(line)
+	assert.NoError(t, err)
+	return price
+}
 
-	priceData := "3333.3333"
+func TestProcessor_ReadFileByLines(t *testing.T) {
+	prcssr, _ := newTestLineProcessor(t)
+	dir := prcssr.config.FilesDir
 
-	expected, err := decimal.NewFromString(priceData)
-	assert.NoError(t, err)
+	data := prcssr.data
 
-	res, err := prcssr.parsePriceData(priceData)
-	assert.NoError(t, err)
+	testutils.GenerateTestData(2, dir)
 
-	assert.Equal(t, &expected, res)
-}
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
 
-func TestProcessor_ParseExpirationDate(t *testing.T) {
-	prcssr, _ := newTestFileProcessor(t)
+	entry := entries[0]
 
-	expirationDate := "2023-08-25 10:42:33 +0200 CEST"
+	file := files.File{
+		Path: fmt.Sprintf("%s/%s", dir, entry.Name()),
+		Name: entry.Name(),
+	}
 
-	expected, err := time.Parse("2006-01-02 15:04:05 -0700 MST", expirationDate)
+	f, err := os.Open(file.Path)
 	assert.NoError(t, err)
 
-	res, err := prcssr.parseExpirationDate(expirationDate)
+	reader := csv.NewReader(f)
+	lines, err := reader.ReadAll()
 	assert.NoError(t, err)
 
-	assert.Equal(t, &expected, res)
+	f.Close()
+
+	prcssr.wgRead.Add(1)
+	go prcssr.readFileByLines(file)
+
+	line1 := testPrice(t, prcssr, lines[0])
+	assert.Equal(t, []*models.Price{line1}, (<-data).prices)
+
+	line2 := testPrice(t, prcssr, lines[1])
+	assert.Equal(t, []*models.Price{line2}, (<-data).prices)
 }
 
-func TestProcessor_ToPrice(t *testing.T) {
-	prcssr, _ := newTestFileProcessor(t)
+func TestProcessor_ReadFileByLines_JSONLines(t *testing.T) {
+	prcssr, _ := newTestLineProcessor(t)
+	dir := prcssr.config.FilesDir
 
-	line := []string{
-		"d65d3cba-40c7-11ee-afc6-a45e60d0762b",
-		"666.2111109",
-		"2023-08-23 10:42:33 +0200 CEST",
-	}
-	path := "test"
+	data := prcssr.data
 
-	price, err := decimal.NewFromString(line[1])
+	file := files.File{
+		Path: fmt.Sprintf("%s/%s", dir, "1_prices.jsonl"),
+		Name: "1_prices.jsonl",
+	}
+	err := os.WriteFile(file.Path, []byte(`{"id": "test_id_1", "price": 2109.555555, "expiration_date": "2023-08-23 16:32:48 +0200 CEST"}`+"\n"), 0644)
 	assert.NoError(t, err)
 
-	expirationDate, err := time.Parse("2006-01-02 15:04:05 -0700 MST", line[2])
-	assert.NoError(t, err)
+	prcssr.wgRead.Add(1)
+	go prcssr.readFileByLines(file)
+
+	line := testPrice(t, prcssr, []string{"test_id_1", "2109.555555", "2023-08-23 16:32:48 +0200 CEST"})
+	assert.NotNil(t, line)
+	assert.Equal(t, []*models.Price{line}, (<-data).prices)
+}
+
+func TestProcessor_ReadFileByLines_Rejects(t *testing.T) {
+	prcssr, _ := newTestLineProcessor(t)
+	dir := prcssr.config.FilesDir
+	prcssr.config.Validation = config.Validation{Enabled: true, MaxRejectedPercent: 10}
+	prcssr.config.FileArchive.FailedDir = filepath.Join(dir, "failed")
+	archive := prcssr.archive.(*MockFileArchive)
 
-	expected := &models.Price{
-		ID:             line[0],
-		Price:          price,
-		ExpirationDate: expirationDate,
+	file := files.File{
+		Path: filepath.Join(dir, "1_prices.csv"),
+		Name: "1_prices.csv",
 	}
+	err := os.WriteFile(file.Path, []byte("test_id_1,1.5,2023-08-23 16:32:48 +0200 CEST\ntest_id_2,1.5\n"), 0644)
+	assert.NoError(t, err)
 
-	res := prcssr.toPrice(path, line)
-	assert.Equal(t, *expected, *res)
+	archive.EXPECT().Failed(file, gomock.Any()).Do(func(_ files.File, err error) {
+		assert.ErrorIs(t, err, validation.ErrTooManyRejects)
+	})
+	prcssr.wgRead.Add(1)
+	prcssr.readFileByLines(file)
+
+	// The file fails before any of its rows is sent to be saved.
+	assert.Empty(t, prcssr.data)
+	report, err := os.ReadFile(filepath.Join(dir, "failed", "1_prices.csv"+validation.RejectsSuffix))
+	assert.NoError(t, err)
+	assert.Equal(t, "file,line,reason,id,price,expiration_date\n1_prices.csv,2,row has 2 columns instead of 3,test_id_2,1.5\n", string(report))
 }
 
-func TestProcessor_ReadFileByLines(t *testing.T) {
+func TestProcessor_ReadFileByLines_RejectsMapped(t *testing.T) {
 	prcssr, _ := newTestLineProcessor(t)
 	dir := prcssr.config.FilesDir
+	prcssr.config.Validation = config.Validation{Enabled: true, MaxRejectedPercent: 50}
+	prcssr.config.FileArchive.FailedDir = filepath.Join(dir, "failed")
+	prcssr.config.FileFormats = []config.FileFormat{{Pattern: "*.csv", Format: config.FormatCSV, Header: true}}
+	archive := prcssr.archive.(*MockFileArchive)
 
 	data := prcssr.data
 
-	testutils.GenerateTestData(2, dir)
-
-	entries, err := os.ReadDir(dir)
+	file := files.File{
+		Path: filepath.Join(dir, "1_prices.csv"),
+		Name: "1_prices.csv",
+	}
+	err := os.WriteFile(file.Path, []byte("price,id,expiration_date\n"+
+		"1.5,test_id_1,2023-08-23 16:32:48 +0200 CEST\n"+
+		"2.5,test_id_2\n"+
+		"3.5,test_id_3,2023-08-23 16:32:48 +0200 CEST\n"), 0644)
 	assert.NoError(t, err)
-	assert.Len(t, entries, 1)
 
-	entry := entries[0]
+	prcssr.wgRead.Add(1)
+	go prcssr.readFileByLines(file)
 
-	file := files.File{
-		Path: fmt.Sprintf("%s/%s", dir, entry.Name()),
-		Name: entry.Name(),
+	// The short row is rejected, the rows after it are still read.
+	var batches []batch
+	for _, id := range []string{"test_id_1", "test_id_3"} {
+		b := <-data
+		assert.Equal(t, id, b.prices[0].ID)
+		batches = append(batches, b)
 	}
+	prcssr.wgRead.Wait()
+	prcssr.batchDone(batches[0], nil)
+	archive.EXPECT().Processed(file)
+	prcssr.batchDone(batches[1], nil)
 
-	f, err := os.Open(file.Path)
+	report, err := os.ReadFile(filepath.Join(dir, "failed", "1_prices.csv"+validation.RejectsSuffix))
 	assert.NoError(t, err)
+	assert.Equal(t, "file,line,reason,id,price,expiration_date\n"+
+		"1_prices.csv,3,\"row has 2 columns, expiration_date is in column 2\",2.5,test_id_2\n", string(report))
+}
 
-	reader := csv.NewReader(f)
-	lines, err := reader.ReadAll()
-	assert.NoError(t, err)
+func TestProcessor_ReadFileByLines_Resume(t *testing.T) {
+	prcssr, _ := newTestLineProcessor(t)
+	dir := prcssr.config.FilesDir
+	archive := prcssr.archive.(*MockFileArchive)
+	cache := prcssr.checkpoints.(*files.FileCacheInMem)
 
-	f.Close()
+	data := prcssr.data
+
+	file := files.File{
+		Path:       filepath.Join(dir, "1_prices.csv"),
+		Name:       "1_prices.csv",
+		Status:     files.StatusQueued,
+		Checkpoint: 1,
+	}
+	assert.NoError(t, cache.Put(file))
+	err := os.WriteFile(file.Path, []byte(""+
+		"test_id_1,1.5,2023-08-23 16:32:48 +0200 CEST\n"+
+		"test_id_2,2.5,2023-08-23 16:32:48 +0200 CEST\n"+
+		"test_id_3,3.5,2023-08-23 16:32:48 +0200 CEST\n"+
+		"test_id_4,4.5,2023-08-23 16:32:48 +0200 CEST\n"), 0644)
+	assert.NoError(t, err)
 
 	prcssr.wgRead.Add(1)
 	go prcssr.readFileByLines(file)
 
-	line1 := prcssr.toPrice(file.Path, lines[0])
-	assert.Equal(t, []*models.Price{line1}, <-data)
+	// Rows up to the checkpoint are skipped.
+	var batches []batch
+	for _, id := range []string{"test_id_2", "test_id_3", "test_id_4"} {
+		b := <-data
+		assert.Equal(t, id, b.prices[0].ID)
+		batches = append(batches, b)
+	}
+	prcssr.wgRead.Wait()
 
-	line2 := prcssr.toPrice(file.Path, lines[1])
-	assert.Equal(t, []*models.Price{line2}, <-data)
+	checkpoint := func() int64 {
+		cached, _, err := cache.Get(file.Path)
+		assert.NoError(t, err)
+		return cached.Checkpoint
+	}
+	// The checkpoint moves once all batches before the saved one are saved.
+	prcssr.batchDone(batches[1], nil)
+	assert.Equal(t, int64(1), checkpoint())
+	prcssr.batchDone(batches[0], nil)
+	assert.Equal(t, int64(3), checkpoint())
+
+	archive.EXPECT().Processed(file)
+	prcssr.batchDone(batches[2], nil)
 }
 
 func TestProcessor_SaveLines(t *testing.T) {
@@ -212,8 +313,30 @@ func TestProcessor_SaveLines(t *testing.T) {
 	repo.EXPECT().CreateMany(prcssr.ctx, prices[0]).Return(nil)
 	repo.EXPECT().CreateMany(prcssr.ctx, prices[1]).Return(nil)
 
-	data <- prices[0]
-	data <- prices[1]
+	file := files.File{Path: "test1.csv"}
+	data <- batch{file: file, prices: prices[0]}
+	data <- batch{file: file, prices: prices[1]}
+
+	close(data)
+
+	prcssr.wgWrite.Wait()
+}
+
+func TestProcessor_SaveLines_Imports(t *testing.T) {
+	prcssr, _ := newTestLineProcessor(t)
+	imports := NewMockImportsRepo(gomock.NewController(t))
+	prcssr.imports = imports
+
+	data := prcssr.data
+	price := &models.Price{ID: "test_id_1", Price: decimal.NewFromInt(1)}
+
+	prcssr.wgWrite.Add(1)
+	go prcssr.saveLines()
+
+	// Prices of atomic imports are staged instead of created.
+	imports.EXPECT().StageMany(prcssr.ctx, "abcd", []*models.Price{price}).Return(nil)
+
+	data <- batch{file: files.File{Path: "test1.csv"}, importID: "abcd", prices: []*models.Price{price}}
 
 	close(data)
 
@@ -232,8 +355,94 @@ func TestProcessor_SaveFiles(t *testing.T) {
 	prcssr.wgWrite.Add(1)
 	go prcssr.saveFiles()
 
-	repo.EXPECT().ImportFile(prcssr.ctx, file1.Path).Return(nil)
-	repo.EXPECT().ImportFile(prcssr.ctx, file2.Path).Return(nil)
+	archive := prcssr.archive.(*MockFileArchive)
+
+	repo.EXPECT().ImportFile(prcssr.ctx, file1.Path).Return(&models.ImportResult{}, nil)
+	repo.EXPECT().ImportFile(prcssr.ctx, file2.Path).Return(&models.ImportResult{}, nil)
+	archive.EXPECT().Processed(file1)
+	archive.EXPECT().Processed(file2)
+
+	err := filesQ.Put(file1)
+	assert.NoError(t, err)
+	err = filesQ.Put(file2)
+	assert.NoError(t, err)
+
+	err = filesQ.Close()
+	assert.NoError(t, err)
+
+	prcssr.wgWrite.Wait()
+}
+
+func TestProcessor_SaveFiles_Imports(t *testing.T) {
+	prcssr, _ := newTestFileProcessor(t)
+	imports := NewMockImportsRepo(gomock.NewController(t))
+	prcssr.imports = imports
+
+	filesQ := prcssr.files.(*files.FileQueueInMem)
+	archive := prcssr.archive.(*MockFileArchive)
+
+	file := files.File{Path: "test1.csv", Offset: 10, Length: 20}
+
+	prcssr.wgWrite.Add(1)
+	go prcssr.saveFiles()
+
+	archive.EXPECT().ImportID(file).Return("abcd")
+	imports.EXPECT().StageFile(prcssr.ctx, "abcd", file.Path, file.Offset, file.Length).Return(&models.ImportResult{}, nil)
+	archive.EXPECT().Processed(file)
+
+	err := filesQ.Put(file)
+	assert.NoError(t, err)
+
+	err = filesQ.Close()
+	assert.NoError(t, err)
+
+	prcssr.wgWrite.Wait()
+}
+
+func TestProcessor_SaveFiles_RetryTransient(t *testing.T) {
+	prcssr, _ := newTestFileProcessor(t)
+
+	filesQ := prcssr.files.(*files.FileQueueInMem)
+	repo := prcssr.repo.(*MockPricesRepo)
+
+	file := files.File{Path: "test1.csv"}
+
+	prcssr.wgWrite.Add(1)
+	go prcssr.saveFiles()
+
+	gomock.InOrder(
+		repo.EXPECT().ImportFile(prcssr.ctx, file.Path).Return(nil, fmt.Errorf("deadlock: %w", errors.ErrTransient)),
+		repo.EXPECT().ImportFile(prcssr.ctx, file.Path).Return(&models.ImportResult{}, nil),
+	)
+	prcssr.archive.(*MockFileArchive).EXPECT().Processed(file)
+
+	err := filesQ.Put(file)
+	assert.NoError(t, err)
+
+	err = filesQ.Close()
+	assert.NoError(t, err)
+
+	prcssr.wgWrite.Wait()
+}
+
+func TestProcessor_SaveFiles_RetryBudget(t *testing.T) {
+	prcssr, _ := newTestFileProcessor(t)
+
+	filesQ := prcssr.files.(*files.FileQueueInMem)
+	repo := prcssr.repo.(*MockPricesRepo)
+
+	file1 := files.File{Path: "test1.csv"}
+	file2 := files.File{Path: "test2.csv"}
+
+	prcssr.wgWrite.Add(1)
+	go prcssr.saveFiles()
+
+	repo.EXPECT().ImportFile(prcssr.ctx, file1.Path).Return(nil, fmt.Errorf("deadlock: %w", errors.ErrTransient)).Times(3)
+	repo.EXPECT().ImportFile(prcssr.ctx, file2.Path).Return(nil, fmt.Errorf("syntax error")).Times(1)
+
+	archive := prcssr.archive.(*MockFileArchive)
+	archive.EXPECT().Failed(file1, gomock.Any())
+	archive.EXPECT().Failed(file2, gomock.Any())
 
 	err := filesQ.Put(file1)
 	assert.NoError(t, err)
@@ -280,10 +489,10 @@ func TestProcessor_ProcessLines(t *testing.T) {
 
 	prices := [][]*models.Price{
 		{
-			prcssr.toPrice(file.Path, lines[0]),
+			testPrice(t, prcssr, lines[0]),
 		},
 		{
-			prcssr.toPrice(file.Path, lines[1]),
+			testPrice(t, prcssr, lines[1]),
 		},
 	}
 
@@ -291,6 +500,7 @@ func TestProcessor_ProcessLines(t *testing.T) {
 
 	repo.EXPECT().CreateMany(prcssr.ctx, prices[0]).Return(nil)
 	repo.EXPECT().CreateMany(prcssr.ctx, prices[1]).Return(nil)
+	prcssr.archive.(*MockFileArchive).EXPECT().Processed(file)
 
 	err = filesQ.Put(file)
 	assert.NoError(t, err)
@@ -326,7 +536,8 @@ func TestProcessor_ProcessFiles(t *testing.T) {
 
 	go prcssr.Process()
 
-	repo.EXPECT().ImportFile(prcssr.ctx, file.Path).Return(nil)
+	repo.EXPECT().ImportFile(prcssr.ctx, file.Path).Return(&models.ImportResult{}, nil)
+	prcssr.archive.(*MockFileArchive).EXPECT().Processed(file)
 
 	err = filesQ.Put(file)
 	assert.NoError(t, err)
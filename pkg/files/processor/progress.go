@@ -0,0 +1,97 @@
+package processor
+
+import (
+	"fmt"
+	"prices/pkg/files"
+	"prices/pkg/metrics"
+)
+
+// startFile - starts tracking progress of the file read by lines.
+func (p *V1) startFile(file files.File) {
+	p.progressMu.Lock()
+	defer p.progressMu.Unlock()
+	p.progress[file.String()] = &fileProgress{reading: true, importID: p.importID(file), saved: make(map[int]int64)}
+}
+
+// readDone - reports that the file is read, or that it can't be read.
+func (p *V1) readDone(file files.File, err error) {
+	p.progressMu.Lock()
+	progress := p.progress[file.String()]
+	progress.reading = false
+	if err != nil && progress.err == nil {
+		progress.err = fmt.Errorf("can't read file: %w", err)
+	}
+	done := p.done(file, progress)
+	p.progressMu.Unlock()
+
+	if done {
+		p.finish(file, progress)
+	}
+}
+
+// batchDone - reports that the batch of the file is saved, or that it can't be saved.
+// Once the batch and all batches before it are saved, the rows read up to its end are saved as the checkpoint of the file.
+func (p *V1) batchDone(b batch, err error) {
+	file := b.file
+	p.progressMu.Lock()
+	progress, ok := p.progress[file.String()]
+	if !ok {
+		p.progressMu.Unlock()
+		return
+	}
+	progress.pending--
+	if err != nil && progress.err == nil {
+		progress.err = fmt.Errorf("can't save data batch: %w", err)
+	}
+	var checkpoint int64
+	if err == nil {
+		progress.saved[b.seq] = b.rows
+		for rows, ok := progress.saved[progress.committed+1]; ok; rows, ok = progress.saved[progress.committed+1] {
+			delete(progress.saved, progress.committed+1)
+			progress.committed++
+			checkpoint = rows
+		}
+	}
+	done := p.done(file, progress)
+	p.progressMu.Unlock()
+
+	if done {
+		p.finish(file, progress)
+		return
+	}
+	if checkpoint > 0 {
+		p.checkpoint(file, checkpoint)
+	}
+}
+
+// checkpoint - saves rows of the file that are saved to the storage, byte ranges of split files are not in the cache,
+// so they are resumed by the checkpoint of their split file once they are imported.
+func (p *V1) checkpoint(file files.File, rows int64) {
+	if file.Range() {
+		return
+	}
+	if err := p.checkpoints.Checkpoint(file, rows); err != nil {
+		p.logger.Sugar().Errorf("can't save file=%s checkpoint: (%s)", file, err.Error())
+	}
+}
+
+// done - reports if the file is read and all of its batches are saved, and stops tracking it if it is.
+// Must be called with the lock held.
+func (p *V1) done(file files.File, progress *fileProgress) bool {
+	if progress.reading || progress.pending > 0 {
+		return false
+	}
+	delete(p.progress, file.String())
+	return true
+}
+
+// finish - archives the processed file, or quarantines it if any of its batches failed.
+func (p *V1) finish(file files.File, progress *fileProgress) {
+	if progress.err != nil {
+		metrics.FailedFiles.Inc()
+		p.archive.Failed(file, progress.err)
+		return
+	}
+	metrics.ImportedFiles.Inc()
+	p.archive.Processed(file)
+}
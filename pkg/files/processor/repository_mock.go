@@ -89,11 +89,12 @@ func (mr *MockPricesRepoMockRecorder) CreateMany(ctx, prices interface{}) *gomoc
 }
 
 // ImportFile mocks base method.
-func (m *MockPricesRepo) ImportFile(ctx context.Context, filePath string) error {
+func (m *MockPricesRepo) ImportFile(ctx context.Context, filePath string) (*models.ImportResult, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "ImportFile", ctx, filePath)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret0, _ := ret[0].(*models.ImportResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // ImportFile indicates an expected call of ImportFile.
@@ -101,3 +102,168 @@ func (mr *MockPricesRepoMockRecorder) ImportFile(ctx, filePath interface{}) *gom
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImportFile", reflect.TypeOf((*MockPricesRepo)(nil).ImportFile), ctx, filePath)
 }
+
+// ImportRange mocks base method.
+func (m *MockPricesRepo) ImportRange(ctx context.Context, filePath string, offset, length int64) (*models.ImportResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImportRange", ctx, filePath, offset, length)
+	ret0, _ := ret[0].(*models.ImportResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImportRange indicates an expected call of ImportRange.
+func (mr *MockPricesRepoMockRecorder) ImportRange(ctx, filePath, offset, length interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImportRange", reflect.TypeOf((*MockPricesRepo)(nil).ImportRange), ctx, filePath, offset, length)
+}
+
+// MockImportsRepo is a mock of ImportsRepo interface.
+type MockImportsRepo struct {
+	ctrl     *gomock.Controller
+	recorder *MockImportsRepoMockRecorder
+}
+
+// MockImportsRepoMockRecorder is the mock recorder for MockImportsRepo.
+type MockImportsRepoMockRecorder struct {
+	mock *MockImportsRepo
+}
+
+// NewMockImportsRepo creates a new mock instance.
+func NewMockImportsRepo(ctrl *gomock.Controller) *MockImportsRepo {
+	mock := &MockImportsRepo{ctrl: ctrl}
+	mock.recorder = &MockImportsRepoMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockImportsRepo) EXPECT() *MockImportsRepoMockRecorder {
+	return m.recorder
+}
+
+// StageFile mocks base method.
+func (m *MockImportsRepo) StageFile(ctx context.Context, importID, filePath string, offset, length int64) (*models.ImportResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StageFile", ctx, importID, filePath, offset, length)
+	ret0, _ := ret[0].(*models.ImportResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StageFile indicates an expected call of StageFile.
+func (mr *MockImportsRepoMockRecorder) StageFile(ctx, importID, filePath, offset, length interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StageFile", reflect.TypeOf((*MockImportsRepo)(nil).StageFile), ctx, importID, filePath, offset, length)
+}
+
+// StageMany mocks base method.
+func (m *MockImportsRepo) StageMany(ctx context.Context, importID string, prices []*models.Price) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StageMany", ctx, importID, prices)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StageMany indicates an expected call of StageMany.
+func (mr *MockImportsRepoMockRecorder) StageMany(ctx, importID, prices interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StageMany", reflect.TypeOf((*MockImportsRepo)(nil).StageMany), ctx, importID, prices)
+}
+
+// MockFileArchive is a mock of FileArchive interface.
+type MockFileArchive struct {
+	ctrl     *gomock.Controller
+	recorder *MockFileArchiveMockRecorder
+}
+
+// MockFileArchiveMockRecorder is the mock recorder for MockFileArchive.
+type MockFileArchiveMockRecorder struct {
+	mock *MockFileArchive
+}
+
+// NewMockFileArchive creates a new mock instance.
+func NewMockFileArchive(ctrl *gomock.Controller) *MockFileArchive {
+	mock := &MockFileArchive{ctrl: ctrl}
+	mock.recorder = &MockFileArchiveMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockFileArchive) EXPECT() *MockFileArchiveMockRecorder {
+	return m.recorder
+}
+
+// Failed mocks base method.
+func (m *MockFileArchive) Failed(file files.File, err error) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Failed", file, err)
+}
+
+// Failed indicates an expected call of Failed.
+func (mr *MockFileArchiveMockRecorder) Failed(file, err interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Failed", reflect.TypeOf((*MockFileArchive)(nil).Failed), file, err)
+}
+
+// ImportID mocks base method.
+func (m *MockFileArchive) ImportID(file files.File) string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImportID", file)
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// ImportID indicates an expected call of ImportID.
+func (mr *MockFileArchiveMockRecorder) ImportID(file interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImportID", reflect.TypeOf((*MockFileArchive)(nil).ImportID), file)
+}
+
+// Processed mocks base method.
+func (m *MockFileArchive) Processed(file files.File) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Processed", file)
+}
+
+// Processed indicates an expected call of Processed.
+func (mr *MockFileArchiveMockRecorder) Processed(file interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Processed", reflect.TypeOf((*MockFileArchive)(nil).Processed), file)
+}
+
+// MockFileCheckpoints is a mock of FileCheckpoints interface.
+type MockFileCheckpoints struct {
+	ctrl     *gomock.Controller
+	recorder *MockFileCheckpointsMockRecorder
+}
+
+// MockFileCheckpointsMockRecorder is the mock recorder for MockFileCheckpoints.
+type MockFileCheckpointsMockRecorder struct {
+	mock *MockFileCheckpoints
+}
+
+// NewMockFileCheckpoints creates a new mock instance.
+func NewMockFileCheckpoints(ctrl *gomock.Controller) *MockFileCheckpoints {
+	mock := &MockFileCheckpoints{ctrl: ctrl}
+	mock.recorder = &MockFileCheckpointsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockFileCheckpoints) EXPECT() *MockFileCheckpointsMockRecorder {
+	return m.recorder
+}
+
+// Checkpoint mocks base method.
+func (m *MockFileCheckpoints) Checkpoint(file files.File, rows int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Checkpoint", file, rows)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Checkpoint indicates an expected call of Checkpoint.
+func (mr *MockFileCheckpointsMockRecorder) Checkpoint(file, rows interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Checkpoint", reflect.TypeOf((*MockFileCheckpoints)(nil).Checkpoint), file, rows)
+}
@@ -0,0 +1,451 @@
+package rows
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"prices/pkg/config"
+	"prices/pkg/files"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultDelimiter = ','
+	defaultQuote     = '"'
+
+	// byteOrderMark - some editors start files with it, it isn't a part of the first column name.
+	byteOrderMark = "\ufeff"
+)
+
+// names - default names of the columns of the price fields, in the order of the fields in rows.
+var names = []string{"id", "price", "expiration_date"}
+
+type (
+	// Decoder - reads rows of a prices file, a row is the id, the price and the expiration date of a price.
+	Decoder interface {
+		// Read - returns the next row, io.EOF once all rows are read.
+		// A row that can't be decoded is returned as *RowError, the rows after it can still be read.
+		Read() ([]string, error)
+	}
+
+	// RowError - row that can't be decoded, it is rejected as a row that can't be parsed.
+	RowError struct {
+		// Row - fields of the row as they are read, the whole line for rows that can't be split into fields.
+		Row []string
+		Err error
+	}
+
+	// Factory - creates decoder of the format reading r.
+	Factory func(r io.Reader, format config.FileFormat) (Decoder, error)
+)
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{
+		config.FormatCSV:       newCSV,
+		config.FormatTSV:       newTSV,
+		config.FormatJSONLines: newJSONLines,
+	}
+	// extensions - formats of files that match no format of the config, other files are decoded as csv files.
+	extensions = map[string]string{
+		".tsv":    config.FormatTSV,
+		".jsonl":  config.FormatJSONLines,
+		".ndjson": config.FormatJSONLines,
+	}
+)
+
+func (e *RowError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// Register - adds the format, so files can be decoded by it, replacing the format with the same name.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	factories[name] = factory
+}
+
+// NewDecoder - creates decoder of rows of the format.
+// Rows of files with a header or columns are the price fields found by the header or the columns,
+// decoders of JSON Lines find them by the keys of the objects.
+// Expiration dates of formats with date layouts or a time zone are converted to files.DateLayout in UTC.
+func NewDecoder(r io.Reader, format config.FileFormat) (Decoder, error) {
+	mu.RLock()
+	factory, ok := factories[format.Format]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown file format=%s", format.Format)
+	}
+	decoder, err := factory(r, format)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := decoder.(*jsonLines); !ok && mapped(format) {
+		decoder, err = newMapping(decoder, format)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if dated(format) {
+		return newDating(decoder, format)
+	}
+	return decoder, nil
+}
+
+// Format - returns format of the file, chunks written by the splitter are always csv files.
+// The path of the file before the scanner renamed it is matched with the formats of the config,
+// and if none matches the format is taken from its extension.
+func Format(cfg *config.FileProcessor, file files.File) config.FileFormat {
+	if Chunk(cfg, file) {
+		return config.FileFormat{Format: config.FormatCSV}
+	}
+	source := sourcePath(file)
+	for _, format := range cfg.FileFormats {
+		if files.Match(cfg.FilesDir, []string{format.Pattern}, source) {
+			return format
+		}
+	}
+	if format, ok := extensions[strings.ToLower(filepath.Ext(files.DecompressedName(source)))]; ok {
+		return config.FileFormat{Format: format}
+	}
+	return config.FileFormat{Format: config.FormatCSV}
+}
+
+// Chunk - reports if the file is a chunk written by the splitter.
+func Chunk(cfg *config.FileProcessor, file files.File) bool {
+	source := sourcePath(file)
+	return filepath.Clean(filepath.Dir(source)) == filepath.Clean(cfg.ChunksDir()) && files.IsChunk(filepath.Base(source))
+}
+
+// sourcePath - returns path of the file before the scanner renamed it.
+func sourcePath(file files.File) string {
+	if file.Source == "" {
+		return file.Path
+	}
+	return file.Source
+}
+
+// Default - reports if files of the format are comma separated values quoted by double quotes,
+// with the id, the price and the expiration date columns only and dates of files.DateLayout,
+// the only files that are imported as they are.
+// Files of other formats are split into chunks of that format first.
+func Default(format config.FileFormat) bool {
+	if format.Format != "" && format.Format != config.FormatCSV || mapped(format) || dated(format) {
+		return false
+	}
+	delimiter, quote, err := csvRunes(format)
+	return err == nil && delimiter == defaultDelimiter && quote == defaultQuote
+}
+
+// mapped - reports if the price fields are found by the header or the columns of the format.
+func mapped(format config.FileFormat) bool {
+	return format.Header || format.Columns != config.Columns{}
+}
+
+// columns - returns columns of the price fields set by the format, in the order of the fields in rows.
+func columns(format config.FileFormat) []string {
+	return []string{format.Columns.ID, format.Columns.Price, format.Columns.ExpirationDate}
+}
+
+// mapping - decoder of the price fields of rows of another decoder.
+type mapping struct {
+	decoder Decoder
+	format  config.FileFormat
+	// indexes - indexes of the price fields in rows, nil until the header is read.
+	indexes []int
+}
+
+func newMapping(decoder Decoder, format config.FileFormat) (Decoder, error) {
+	m := &mapping{decoder: decoder, format: format}
+	if format.Header {
+		return m, nil
+	}
+	for i, column := range columns(format) {
+		if column == "" {
+			m.indexes = append(m.indexes, i)
+			continue
+		}
+		index, err := strconv.Atoi(column)
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("column=%s of %s must be a number for files without a header", column, names[i])
+		}
+		m.indexes = append(m.indexes, index)
+	}
+	return m, nil
+}
+
+func (m *mapping) Read() ([]string, error) {
+	if m.indexes == nil {
+		if err := m.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+	row, err := m.decoder.Read()
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]string, len(m.indexes))
+	for i, index := range m.indexes {
+		if index >= len(row) {
+			return nil, &RowError{Row: row, Err: fmt.Errorf("row has %d columns, %s is in column %d", len(row), names[i], index)}
+		}
+		fields[i] = row[index]
+	}
+	return fields, nil
+}
+
+// readHeader - finds the price fields by names of the columns in the header, names are not case sensitive.
+func (m *mapping) readHeader() error {
+	header, err := m.decoder.Read()
+	if err == io.EOF {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("can't read header: %w", err)
+	}
+	byName := make(map[string]int, len(header))
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, byteOrderMark)
+		}
+		byName[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	indexes := make([]int, 0, len(names))
+	for i, column := range columns(m.format) {
+		if column == "" {
+			column = names[i]
+		}
+		index, ok := byName[strings.ToLower(column)]
+		if !ok {
+			return fmt.Errorf("header has no column=%s of %s", column, names[i])
+		}
+		indexes = append(indexes, index)
+	}
+	m.indexes = indexes
+	return nil
+}
+
+// dated - reports if expiration dates of the format are parsed by its date layouts or time zone.
+func dated(format config.FileFormat) bool {
+	return len(format.DateLayouts) > 0 || format.TimeZone != ""
+}
+
+// dating - decoder converting expiration dates of rows of another decoder to files.DateLayout in UTC.
+// Dates that match no layout are kept as they are, so the row is skipped as any other row that can't be parsed.
+type dating struct {
+	decoder Decoder
+	layouts []string
+	loc     *time.Location
+}
+
+func newDating(decoder Decoder, format config.FileFormat) (Decoder, error) {
+	loc := time.UTC
+	if format.TimeZone != "" {
+		var err error
+		loc, err = time.LoadLocation(format.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("can't load time zone=%s: %w", format.TimeZone, err)
+		}
+	}
+	return &dating{decoder: decoder, layouts: format.DateLayouts, loc: loc}, nil
+}
+
+func (d *dating) Read() ([]string, error) {
+	row, err := d.decoder.Read()
+	if err != nil || len(row) < len(names) {
+		return row, err
+	}
+	if date, err := files.ParseDate(row[2], d.layouts, d.loc); err == nil {
+		row[2] = files.FormatDate(date)
+	}
+	return row, nil
+}
+
+func newCSV(r io.Reader, format config.FileFormat) (Decoder, error) {
+	delimiter, quote, err := csvRunes(format)
+	if err != nil {
+		return nil, err
+	}
+	if quote == defaultQuote {
+		reader := csv.NewReader(r)
+		reader.Comma = delimiter
+		// Rows with a wrong number of columns are rejected by the validation, not by the decoder.
+		reader.FieldsPerRecord = -1
+		return reader, nil
+	}
+	return newDelimited(r, delimiter, quote), nil
+}
+
+func newTSV(r io.Reader, _ config.FileFormat) (Decoder, error) {
+	return newDelimited(r, '\t', 0), nil
+}
+
+func csvRunes(format config.FileFormat) (rune, rune, error) {
+	delimiter, quote := rune(defaultDelimiter), rune(defaultQuote)
+	if format.Delimiter != "" {
+		if utf8.RuneCountInString(format.Delimiter) != 1 {
+			return 0, 0, fmt.Errorf("csv delimiter=%q must be a single character", format.Delimiter)
+		}
+		delimiter, _ = utf8.DecodeRuneInString(format.Delimiter)
+	}
+	if format.Quote != "" {
+		if utf8.RuneCountInString(format.Quote) != 1 {
+			return 0, 0, fmt.Errorf("csv quote=%q must be a single character", format.Quote)
+		}
+		quote, _ = utf8.DecodeRuneInString(format.Quote)
+	}
+	if delimiter == quote {
+		return 0, 0, fmt.Errorf("csv delimiter and quote=%q must be different", format.Quote)
+	}
+	return delimiter, quote, nil
+}
+
+// delimited - decoder of delimited values, fields in quotes may contain the delimiter, new lines and doubled quotes.
+// Values are never quoted if the quote is 0.
+type delimited struct {
+	r         *bufio.Reader
+	delimiter rune
+	quote     rune
+	line      int
+}
+
+func newDelimited(r io.Reader, delimiter rune, quote rune) *delimited {
+	return &delimited{r: bufio.NewReader(r), delimiter: delimiter, quote: quote}
+}
+
+func (d *delimited) Read() ([]string, error) {
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			continue
+		}
+		return d.parse(line)
+	}
+}
+
+func (d *delimited) parse(line string) ([]string, error) {
+	var (
+		fields []string
+		field  strings.Builder
+		quoted bool
+	)
+	for {
+		r, size := utf8.DecodeRuneInString(line)
+		switch {
+		case size == 0 && quoted:
+			// A quoted field goes on on the next line.
+			next, err := d.readLine()
+			if err == io.EOF {
+				return nil, fmt.Errorf("line %d: extraneous or missing %q in quoted field", d.line, d.quote)
+			}
+			if err != nil {
+				return nil, err
+			}
+			field.WriteRune('\n')
+			line = next
+			continue
+		case size == 0:
+			return append(fields, field.String()), nil
+		case quoted && r == d.quote:
+			if next, _ := utf8.DecodeRuneInString(line[size:]); next == d.quote {
+				field.WriteRune(d.quote)
+				size *= 2
+			} else {
+				quoted = false
+			}
+		case quoted:
+			field.WriteRune(r)
+		case r == d.delimiter:
+			fields = append(fields, field.String())
+			field.Reset()
+		case r == d.quote && d.quote != 0 && field.Len() == 0:
+			quoted = true
+		default:
+			field.WriteRune(r)
+		}
+		line = line[size:]
+	}
+}
+
+// readLine - returns the next line without the line ending.
+func (d *delimited) readLine() (string, error) {
+	line, err := d.r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	d.line++
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// jsonLines - decoder of JSON objects, one per line, the price fields are found by the keys set as columns of the format.
+// Prices may be numbers or strings, numbers are kept as they are written, other keys are ignored.
+type jsonLines struct {
+	r    *bufio.Reader
+	keys []string
+	line int
+}
+
+func newJSONLines(r io.Reader, format config.FileFormat) (Decoder, error) {
+	keys := columns(format)
+	for i := range keys {
+		if keys[i] == "" {
+			keys[i] = names[i]
+		}
+	}
+	return &jsonLines{r: bufio.NewReader(r), keys: keys}, nil
+}
+
+func (j *jsonLines) Read() ([]string, error) {
+	for {
+		line, err := j.r.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+		j.line++
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(line, &object); err != nil {
+			return nil, j.rowError(line, fmt.Errorf("can't parse JSON object: %w", err))
+		}
+		fields := make([]string, len(j.keys))
+		for i, key := range j.keys {
+			value, ok := object[key]
+			if !ok {
+				continue
+			}
+			var field json.Number
+			if err := json.Unmarshal(value, &field); err != nil {
+				var text string
+				if err := json.Unmarshal(value, &text); err != nil {
+					return nil, j.rowError(line, fmt.Errorf("%s must be a string or a number: %w", key, err))
+				}
+				field = json.Number(text)
+			}
+			fields[i] = field.String()
+		}
+		return fields, nil
+	}
+}
+
+// rowError - returns the line that can't be decoded as a rejected row of a single field.
+func (j *jsonLines) rowError(line []byte, err error) error {
+	return &RowError{Row: []string{string(bytes.TrimSpace(line))}, Err: fmt.Errorf("line %d: %w", j.line, err)}
+}
@@ -0,0 +1,193 @@
+package rows
+
+import (
+	"io"
+	"path/filepath"
+	"prices/pkg/config"
+	"prices/pkg/files"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readAll(t *testing.T, data string, format config.FileFormat) ([][]string, error) {
+	decoder, err := NewDecoder(strings.NewReader(data), format)
+	assert.NoError(t, err)
+	var lines [][]string
+	for {
+		line, err := decoder.Read()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestDecoder_CSV(t *testing.T) {
+	lines, err := readAll(t, "id_1,1.5,2023-08-24 10:01:40 +0000 UTC\n\"id,2\",2,2023-08-24 10:01:40 +0000 UTC\n", config.FileFormat{Format: config.FormatCSV})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"id_1", "1.5", "2023-08-24 10:01:40 +0000 UTC"},
+		{"id,2", "2", "2023-08-24 10:01:40 +0000 UTC"},
+	}, lines)
+}
+
+func TestDecoder_CSV_DelimiterQuote(t *testing.T) {
+	data := "id_1;1.5;2023-08-24 10:01:40 +0000 UTC\r\n'id;''2''\nx';2;2023-08-24 10:01:40 +0000 UTC\n"
+	lines, err := readAll(t, data, config.FileFormat{Format: config.FormatCSV, Delimiter: ";", Quote: "'"})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"id_1", "1.5", "2023-08-24 10:01:40 +0000 UTC"},
+		{"id;'2'\nx", "2", "2023-08-24 10:01:40 +0000 UTC"},
+	}, lines)
+
+	_, err = readAll(t, "'id;1;2\n", config.FileFormat{Format: config.FormatCSV, Delimiter: ";", Quote: "'"})
+	assert.Error(t, err)
+}
+
+func TestDecoder_TSV(t *testing.T) {
+	lines, err := readAll(t, "id_1\t1.5\t2023-08-24 10:01:40 +0000 UTC\n\n\"id_2\"\t2\t2023-08-24 10:01:40 +0000 UTC", config.FileFormat{Format: config.FormatTSV})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"id_1", "1.5", "2023-08-24 10:01:40 +0000 UTC"},
+		{"\"id_2\"", "2", "2023-08-24 10:01:40 +0000 UTC"},
+	}, lines)
+}
+
+func TestDecoder_JSONLines(t *testing.T) {
+	data := `{"id": "id_1", "price": 2109.555555555555, "expiration_date": "2023-08-24 10:01:40 +0000 UTC"}` + "\n\n" +
+		`{"expiration_date": "2023-08-24 10:01:40 +0000 UTC", "price": "2", "id": "id_2"}`
+	lines, err := readAll(t, data, config.FileFormat{Format: config.FormatJSONLines})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"id_1", "2109.555555555555", "2023-08-24 10:01:40 +0000 UTC"},
+		{"id_2", "2", "2023-08-24 10:01:40 +0000 UTC"},
+	}, lines)
+
+	// Lines that can't be decoded are rejected, the next lines are still read.
+	decoder, err := NewDecoder(strings.NewReader("{\"id\": \"id_1\",\n{\"id\": [1]}\n"+`{"id": "id_3", "price": 3, "expiration_date": "x"}`), config.FileFormat{Format: config.FormatJSONLines})
+	assert.NoError(t, err)
+	var rowErr *RowError
+	_, err = decoder.Read()
+	assert.ErrorAs(t, err, &rowErr)
+	assert.ErrorContains(t, err, "line 1")
+	assert.Equal(t, []string{"{\"id\": \"id_1\","}, rowErr.Row)
+	_, err = decoder.Read()
+	assert.ErrorAs(t, err, &rowErr)
+	assert.ErrorContains(t, err, "line 2: id must be a string or a number")
+	line, err := decoder.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id_3", "3", "x"}, line)
+	_, err = decoder.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDecoder_Header(t *testing.T) {
+	data := "\ufeffSKU;Valid Until;Extra;ID;PRICE\nsku_1;2023-08-24 10:01:40 +0000 UTC;x;id_1;1.5\n"
+	lines, err := readAll(t, data, config.FileFormat{Format: config.FormatCSV, Delimiter: ";", Header: true,
+		Columns: config.Columns{ExpirationDate: "valid until"}})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"id_1", "1.5", "2023-08-24 10:01:40 +0000 UTC"}}, lines)
+
+	lines, err = readAll(t, "", config.FileFormat{Format: config.FormatCSV, Header: true})
+	assert.NoError(t, err)
+	assert.Empty(t, lines)
+
+	_, err = readAll(t, "id,price\nid_1,1\n", config.FileFormat{Format: config.FormatCSV, Header: true})
+	assert.ErrorContains(t, err, "expiration_date")
+}
+
+func TestDecoder_Columns(t *testing.T) {
+	lines, err := readAll(t, "x\t2023-08-24 10:01:40 +0000 UTC\t1.5\tid_1\n", config.FileFormat{Format: config.FormatTSV,
+		Columns: config.Columns{ID: "3", Price: "2", ExpirationDate: "1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"id_1", "1.5", "2023-08-24 10:01:40 +0000 UTC"}}, lines)
+
+	// Short rows are rejected, the next rows are still read.
+	decoder, err := NewDecoder(strings.NewReader("id_1,1.5\nid_2,2,2023-08-24 10:01:40 +0000 UTC\n"), config.FileFormat{Format: config.FormatCSV,
+		Columns: config.Columns{Price: "1"}})
+	assert.NoError(t, err)
+	var rowErr *RowError
+	_, err = decoder.Read()
+	assert.ErrorAs(t, err, &rowErr)
+	assert.ErrorContains(t, err, "expiration_date")
+	assert.Equal(t, []string{"id_1", "1.5"}, rowErr.Row)
+	line, err := decoder.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id_2", "2", "2023-08-24 10:01:40 +0000 UTC"}, line)
+
+	_, err = NewDecoder(strings.NewReader(""), config.FileFormat{Format: config.FormatCSV, Columns: config.Columns{ID: "sku"}})
+	assert.Error(t, err)
+}
+
+func TestDecoder_JSONLines_Columns(t *testing.T) {
+	data := `{"sku": "id_1", "amount": 1.50, "valid_until": "2023-08-24 10:01:40 +0000 UTC", "extra": {"a": 1}}`
+	lines, err := readAll(t, data, config.FileFormat{Format: config.FormatJSONLines,
+		Columns: config.Columns{ID: "sku", Price: "amount", ExpirationDate: "valid_until"}})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"id_1", "1.50", "2023-08-24 10:01:40 +0000 UTC"}}, lines)
+}
+
+func TestDecoder_Dates(t *testing.T) {
+	data := "id_1,1.5,2023-08-24T12:01:40+02:00\nid_2,2,2023-08-24T12:01:40\nid_3,3,1692871300\nid_4,4,tomorrow\n"
+	lines, err := readAll(t, data, config.FileFormat{Format: config.FormatCSV, TimeZone: "Europe/Berlin",
+		DateLayouts: []string{time.RFC3339, "2006-01-02T15:04:05", files.DateLayoutUnix}})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"id_1", "1.5", "2023-08-24 10:01:40 +0000 UTC"},
+		{"id_2", "2", "2023-08-24 10:01:40 +0000 UTC"},
+		{"id_3", "3", "2023-08-24 10:01:40 +0000 UTC"},
+		{"id_4", "4", "tomorrow"},
+	}, lines)
+
+	_, err = NewDecoder(strings.NewReader(""), config.FileFormat{Format: config.FormatCSV, TimeZone: "Mars/Olympus"})
+	assert.Error(t, err)
+}
+
+func TestNewDecoder_Unknown(t *testing.T) {
+	_, err := NewDecoder(strings.NewReader(""), config.FileFormat{Format: "xml"})
+	assert.Error(t, err)
+
+	_, err = NewDecoder(strings.NewReader(""), config.FileFormat{Format: config.FormatCSV, Delimiter: ";;"})
+	assert.Error(t, err)
+}
+
+func TestFormat(t *testing.T) {
+	cfg := &config.FileProcessor{
+		FilesDir: "/data",
+		FileFormats: []config.FileFormat{
+			{Pattern: "vendor_a/*", Format: config.FormatCSV, Delimiter: ";"},
+			{Pattern: "*.txt", Format: config.FormatTSV},
+		},
+	}
+
+	for source, expected := range map[string]config.FileFormat{
+		"/data/vendor_a/prices.csv":         {Pattern: "vendor_a/*", Format: config.FormatCSV, Delimiter: ";"},
+		"/data/vendor_b/prices.txt":         {Pattern: "*.txt", Format: config.FormatTSV},
+		"/data/prices.tsv.gz":               {Format: config.FormatTSV},
+		"/data/prices.ndjson":               {Format: config.FormatJSONLines},
+		"/data/prices.csv":                  {Format: config.FormatCSV},
+		"/data/0_10_1_prices.chunk.csv":     {Format: config.FormatCSV},
+		"/data/2024_01_15_prices.txt":       {Pattern: "*.txt", Format: config.FormatTSV},
+		"/data/vendor_a/0_10_1_a.chunk.csv": {Pattern: "vendor_a/*", Format: config.FormatCSV, Delimiter: ";"},
+	} {
+		file := files.File{Path: filepath.Join("/data", "1_"+filepath.Base(source)), Source: source}
+		assert.Equal(t, expected, Format(cfg, file), source)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	assert.True(t, Default(config.FileFormat{Format: config.FormatCSV}))
+	assert.True(t, Default(config.FileFormat{Format: config.FormatCSV, Delimiter: ",", Quote: "\""}))
+	assert.False(t, Default(config.FileFormat{Format: config.FormatCSV, Delimiter: ";"}))
+	assert.False(t, Default(config.FileFormat{Format: config.FormatTSV}))
+	assert.False(t, Default(config.FileFormat{Format: config.FormatJSONLines}))
+	assert.False(t, Default(config.FileFormat{Format: config.FormatCSV, Header: true}))
+	assert.False(t, Default(config.FileFormat{Format: config.FormatCSV, Columns: config.Columns{ID: "0"}}))
+	assert.False(t, Default(config.FileFormat{Format: config.FormatCSV, DateLayouts: []string{time.RFC3339}}))
+}
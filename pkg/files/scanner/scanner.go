@@ -1,19 +1,27 @@
 package scanner
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"prices/pkg/config"
 	"prices/pkg/files"
+	"prices/pkg/files/rows"
+	"prices/pkg/metrics"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"go.uber.org/zap"
 )
 
 const (
 	CSV = ".csv"
+
+	defaultSettleDuration = 500 * time.Millisecond
 )
 
 type (
@@ -25,6 +33,13 @@ type (
 	FileCache interface {
 		Put(file files.File) error
 		Get(key string) (files.File, bool, error)
+		GetByContent(checksum string, size int64) (files.File, bool, error)
+		Unfinished() ([]files.File, error)
+		Chunks(parent string) ([]files.File, error)
+	}
+
+	FileArchive interface {
+		Skipped(file files.File)
 	}
 
 	V1 struct {
@@ -34,6 +49,8 @@ type (
 		files      FileQueue
 		splitFiles FileQueue
 		cache      FileCache
+		archive    FileArchive
+		watcher    *fsnotify.Watcher
 		logger     *zap.Logger
 	}
 )
@@ -45,6 +62,7 @@ func NewScanner(
 	files FileQueue,
 	splitFiles FileQueue,
 	cache FileCache,
+	archive FileArchive,
 	stop <-chan bool,
 ) *V1 {
 	log := logger.Named("FileScanner")
@@ -53,6 +71,7 @@ func NewScanner(
 		config:     config,
 		stop:       stop,
 		cache:      cache,
+		archive:    archive,
 		files:      files,
 		splitFiles: splitFiles,
 		logger:     log,
@@ -67,12 +86,52 @@ func (s *V1) Scan() {
 	}
 	s.wg.Add(1)
 	ticker := time.NewTicker(s.config.FileScanner.CheckEveryDuration)
+	defer ticker.Stop()
+
+	// Events of a nil watcher are nil channels, which are never selected, so the poll mode only rescans by the ticker.
+	s.watcher = s.watch()
+	var (
+		events  <-chan fsnotify.Event
+		errs    <-chan error
+		settled <-chan time.Time
+	)
+	// pending - paths changed by events, by the time of the last change.
+	pending := make(map[string]time.Time)
+	settle := s.config.FileScanner.SettleDuration
+	if settle <= 0 {
+		settle = defaultSettleDuration
+	}
+	if s.watcher != nil {
+		defer s.watcher.Close()
+		events = s.watcher.Events
+		errs = s.watcher.Errors
+		settleTicker := time.NewTicker(settle / 2)
+		defer settleTicker.Stop()
+		settled = settleTicker.C
+	}
+
+	s.resume()
 	s.scanDir()
 	for {
 		select {
 		case <-ticker.C:
 			s.logger.Sugar().Infof("rescan directory=%s", s.config.FilesDir)
 			s.scanDir()
+		case event := <-events:
+			if event.Has(fsnotify.Create) || event.Has(fsnotify.Write) {
+				pending[event.Name] = time.Now()
+			}
+		case err := <-errs:
+			// Events are lost if the watcher falls behind, so the directory is rescanned instead of waiting for the ticker.
+			s.logger.Sugar().Errorf("can't watch directory=%s, rescan it: (%s)", s.config.FilesDir, err.Error())
+			s.scanDir()
+		case now := <-settled:
+			for path, changed := range pending {
+				if now.Sub(changed) >= settle {
+					delete(pending, path)
+					s.addPath(path)
+				}
+			}
 		case <-s.stop:
 			s.logger.Sugar().Infof("stop scanning files in directory=%s", s.config.FilesDir)
 			s.wg.Done()
@@ -81,58 +140,354 @@ func (s *V1) Scan() {
 	}
 }
 
+// watch - starts watching the directory in the watch mode, returns nil in the poll mode
+// or if the directory can't be watched, then new files are only found by rescans.
+func (s *V1) watch() *fsnotify.Watcher {
+	if s.config.FileScanner.Mode != config.ScannerModeWatch {
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		s.logger.Sugar().Errorf("can't create watcher, fall back to polling: (%s)", err.Error())
+		return nil
+	}
+	if err := watcher.Add(s.config.FilesDir); err != nil {
+		_ = watcher.Close()
+		s.logger.Sugar().Errorf("can't watch directory=%s, fall back to polling: (%s)", s.config.FilesDir, err.Error())
+		return nil
+	}
+	s.logger.Sugar().Infof("watch directory=%s", s.config.FilesDir)
+	return watcher
+}
+
+// resume - queues files of the instance that were queued but not processed when the application stopped,
+// files read by lines are resumed after the rows saved before, other files are imported again.
+// Split files whose chunks or ranges aren't all imported are split again, so their chunks are tracked again,
+// chunks aren't resumed on their own.
+// With claims, files of the claim directory of the instance are resumed in place.
+func (s *V1) resume() {
+	unfinished, err := s.cache.Unfinished()
+	if err != nil {
+		s.logger.Sugar().Errorf("can't get unfinished files from cache: (%s)", err.Error())
+		return
+	}
+	dir := s.config.FilesDir
+	if s.config.FileClaims.Enabled {
+		dir = s.config.FileClaims.InstanceDir()
+	}
+	// parents - unfinished split files, and split files of unfinished chunks.
+	parents := make(map[string]bool)
+	for _, file := range unfinished {
+		if rel, err := filepath.Rel(dir, file.Path); err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if file.Status == files.StatusSplit {
+			parents[file.Path] = true
+			continue
+		}
+		if file.Parent != "" {
+			parents[file.Parent] = true
+			continue
+		}
+		if _, err := os.Stat(file.Path); err != nil {
+			s.logger.Sugar().Warnf("can't resume file=%s: (%s)", file, err.Error())
+			continue
+		}
+		s.logger.Sugar().Infof("resume file=%s after rows=%d", file, file.Checkpoint)
+		if err := s.files.Put(file); err != nil {
+			s.logger.Sugar().Errorf("can't add file=%s to files queue: (%s)", file, err.Error())
+		}
+	}
+	for path := range parents {
+		s.split(path)
+	}
+}
+
+// split - splits the split file again, its chunks that are not imported are removed, because they are written again,
+// and the splitter skips chunks that are imported. Byte ranges are not in the cache, the file is divided again from its checkpoint.
+func (s *V1) split(path string) {
+	file, ok, err := s.cache.Get(path)
+	if err != nil {
+		s.logger.Sugar().Errorf("can't get file=%s from cache: (%s)", path, err.Error())
+		return
+	}
+	if !ok || file.Status != files.StatusSplit {
+		return
+	}
+	if _, err := os.Stat(file.Path); err != nil {
+		s.logger.Sugar().Warnf("can't resume file=%s: (%s)", file, err.Error())
+		return
+	}
+	chunks, err := s.cache.Chunks(file.Path)
+	if err != nil {
+		s.logger.Sugar().Errorf("can't get chunks of file=%s from cache: (%s)", file, err.Error())
+		return
+	}
+	for _, chunk := range chunks {
+		if chunk.Status == files.StatusImported {
+			continue
+		}
+		if err := os.Remove(chunk.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			s.logger.Sugar().Errorf("can't remove chunk=%s: (%s)", chunk, err.Error())
+			return
+		}
+	}
+	s.logger.Sugar().Infof("split file=%s again", file)
+	if err := s.splitFiles.Put(file); err != nil {
+		s.logger.Sugar().Errorf("can't add file=%s to splitFile queue: (%s)", file, err.Error())
+	}
+}
+
 func (s *V1) scanDir() {
-	dir, err := os.ReadDir(s.config.FilesDir)
+	s.scanTree(s.config.FilesDir, 0)
+	if s.config.FileClaims.Enabled {
+		// Chunks are written to the claim directory, its subdirectories are not scanned.
+		s.scanTree(s.config.FileClaims.InstanceDir(), s.config.FileScanner.MaxDepth)
+	}
+}
+
+// scanTree - adds valid files of the directory and scans its subdirectories up to MaxDepth.
+func (s *V1) scanTree(dir string, depth int) {
+	if s.watcher != nil {
+		if err := s.watcher.Add(dir); err != nil {
+			s.logger.Sugar().Errorf("can't watch directory=%s: (%s)", dir, err.Error())
+		}
+	}
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		s.logger.Sugar().Errorf("can't open directory=%s: (%s)", s.config.FilesDir, err.Error())
+		s.logger.Sugar().Errorf("can't open directory=%s: (%s)", dir, err.Error())
 		return
 	}
-	for _, entry := range dir {
-		if s.valid(entry) {
-			s.add(entry)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if depth < s.config.FileScanner.MaxDepth && s.validDir(s.getPath(dir, entry)) {
+				s.scanTree(s.getPath(dir, entry), depth+1)
+			}
+			continue
+		}
+		if s.valid(dir, entry) {
+			s.add(dir, entry)
 		}
 	}
 }
 
-func (s *V1) valid(entry os.DirEntry) bool {
-	if entry.IsDir() {
+// addPath - adds the file or scans the directory reported by a watch event, if it still exists.
+func (s *V1) addPath(path string) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		// Renamed or removed after the event, e.g. by the scanner itself.
+		return
+	}
+	rel, err := filepath.Rel(s.config.FilesDir, path)
+	if err != nil {
+		return
+	}
+	depth := len(strings.Split(filepath.ToSlash(rel), "/"))
+	if info.IsDir() {
+		if depth <= s.config.FileScanner.MaxDepth && s.validDir(path) {
+			s.scanTree(path, depth)
+		}
+		return
+	}
+	entry := fs.FileInfoToDirEntry(info)
+	dir := filepath.Dir(path)
+	if s.valid(dir, entry) {
+		s.add(dir, entry)
+	}
+}
+
+// validDir - reports if the subdirectory is scanned.
+// The snapshots directory is never scanned, its files are imported by the snapshots importer,
+// and neither are the directories of processed, failed and claimed files.
+func (s *V1) validDir(path string) bool {
+	for _, dir := range []string{s.config.Snapshots.Dir, s.config.FileArchive.ProcessedDir, s.config.FileArchive.FailedDir, s.config.FileClaims.Dir} {
+		if dir != "" && filepath.Clean(path) == filepath.Clean(dir) {
+			return false
+		}
+	}
+	return !s.match(s.config.FileScanner.Exclude, path)
+}
+
+func (s *V1) valid(dir string, entry os.DirEntry) bool {
+	if entry.IsDir() || files.IsTemp(entry.Name()) {
 		return false
 	}
 
-	extension := filepath.Ext(s.getPath(entry))
-	return extension == CSV
+	path := s.getPath(dir, entry)
+	if s.chunk(dir, entry) {
+		return s.csv(path)
+	}
+	if s.claimed(dir) {
+		return false
+	}
+	if s.match(s.config.FileScanner.Exclude, path) {
+		return false
+	}
+	if len(s.config.FileScanner.Include) == 0 {
+		return s.csv(path)
+	}
+	return s.match(s.config.FileScanner.Include, path)
+}
+
+// csv - reports if the file is a .csv file once it is decompressed, e.g. prices.csv.gz.
+func (s *V1) csv(path string) bool {
+	return filepath.Ext(files.DecompressedName(path)) == CSV
+}
+
+// chunk - reports if the file is a chunk written by the splitter, chunks are added whatever the patterns,
+// because the split file has already matched them.
+func (s *V1) chunk(dir string, entry os.DirEntry) bool {
+	return filepath.Clean(dir) == filepath.Clean(s.config.ChunksDir()) && files.IsChunk(entry.Name())
+}
+
+// match - reports if the path matches any of the patterns.
+func (s *V1) match(patterns []string, filePath string) bool {
+	return files.Match(s.config.FilesDir, patterns, filePath)
+}
+
+// route - returns queue of the file, the queue of the first matching route,
+// or the split queue for files bigger than MaxFileSizeBytes, the size of compressed files is their uncompressed size.
+func (s *V1) route(dir string, entry os.DirEntry, size int64) string {
+	path := s.getPath(dir, entry)
+	if !s.chunk(dir, entry) {
+		for _, route := range s.config.FileScanner.Routes {
+			if s.match([]string{route.Pattern}, path) {
+				return route.Queue
+			}
+		}
+	}
+	if size >= s.config.MaxFileSizeBytes {
+		return config.RouteSplit
+	}
+	return config.RouteProcess
+}
+
+// importable - reports if the file can be imported as it is, files that aren't comma separated values are split into csv chunks,
+// unless they are processed by lines. Rows are validated while files are split, so with validation enabled all files are split,
+// except chunks.
+func (s *V1) importable(file files.File) bool {
+	if s.config.ImportByLines || rows.Chunk(s.config, file) {
+		return true
+	}
+	return !s.config.Validation.Enabled && rows.Default(rows.Format(s.config, file))
+}
+
+// getByContent - gets imported file with the same content from the cache.
+// Files that are not imported yet are not originals, because they can still fail.
+// Empty files have nothing to import twice, so they are never duplicates.
+func (s *V1) getByContent(checksum string, size int64) (files.File, bool, error) {
+	if size == 0 {
+		return files.File{}, false, nil
+	}
+	return s.cache.GetByContent(checksum, size)
+}
+
+func (s *V1) getPath(dir string, entry os.DirEntry) string {
+	return filepath.Join(dir, entry.Name())
+}
+
+// claim - renames the file with the "<unix nano>_" prefix, into the claim directory of the instance if claims are enabled,
+// keeping its path relative to the files directory. The rename is atomic, so only one of the instances sharing
+// the directory claims the file, the others get fs.ErrNotExist.
+func (s *V1) claim(dir string, entry os.DirEntry) (string, error) {
+	newName := fmt.Sprintf("%d_%s", time.Now().UnixNano(), entry.Name())
+	newDir := dir
+	if s.config.FileClaims.Enabled && !s.claimed(dir) {
+		rel, err := filepath.Rel(s.config.FilesDir, dir)
+		if err != nil {
+			return "", err
+		}
+		newDir = filepath.Join(s.config.FileClaims.InstanceDir(), rel)
+		if err := os.MkdirAll(newDir, 0755); err != nil {
+			return "", err
+		}
+	}
+	newPath := filepath.Join(newDir, newName)
+	if err := os.Rename(s.getPath(dir, entry), newPath); err != nil {
+		return "", err
+	}
+	return newPath, nil
 }
 
-func (s *V1) getPath(entry os.DirEntry) string {
-	return filepath.Join(s.config.FilesDir, entry.Name())
+// claimed - reports if the directory is the claim directory of the instance, only chunks written there by the splitter are added from it.
+func (s *V1) claimed(dir string) bool {
+	return s.config.FileClaims.Enabled && filepath.Clean(dir) == filepath.Clean(s.config.FileClaims.InstanceDir())
 }
 
-func (s *V1) add(entry os.DirEntry) {
-	path := s.getPath(entry)
-	if _, ok, err := s.cache.Get(path); ok {
+func (s *V1) add(dir string, entry os.DirEntry) {
+	path := s.getPath(dir, entry)
+	// Chunks are in the cache once the splitter writes them, with the path of their split file, they are added once.
+	cached, ok, err := s.cache.Get(path)
+	if err != nil {
+		s.logger.Sugar().Errorf("can't get file=%s from cache: (%s)", path, err.Error())
+		return
+	}
+	if ok && cached.Status != files.StatusChunk {
+		return
+	}
+
+	// With claims the file is claimed before it is read, so instances sharing the directory never read the same file.
+	// Chunks are never renamed, so they keep the path they are saved with to the cache.
+	chunk := s.chunk(dir, entry)
+	newPath := path
+	if s.config.FileClaims.Enabled && !chunk {
+		var err error
+		if newPath, err = s.claim(dir, entry); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				s.logger.Sugar().Debugf("skip file=%s, it is claimed by another instance", path)
+				return
+			}
+			s.logger.Sugar().Errorf("can't claim file=%s: (%s)", path, err.Error())
+			return
+		}
+	}
+
+	checksum, size, err := files.Checksum(newPath)
+	if err != nil {
+		s.logger.Sugar().Errorf("can't get file=%s checksum: (%s)", newPath, err.Error())
+		return
+	}
+	metrics.DetectedFiles.Inc()
+	// Chunks are parts of a split file, so they are neither duplicates nor originals of other files,
+	// they are kept in the cache without the checksum.
+	if chunk {
+		checksum = ""
+	} else if original, ok, err := s.getByContent(checksum, size); ok {
+		// The duplicate is kept in the cache by its own path, so it is not read again by the next scans.
+		s.logger.Sugar().Warnf("skip file=%s, it has the same content as file=%s", path, original)
+		duplicate := files.File{Path: newPath, Name: filepath.Base(newPath), Checksum: checksum, Size: size, Status: files.StatusDuplicate, Source: path}
+		if err := s.cache.Put(duplicate); err != nil {
+			s.logger.Sugar().Errorf("can't add file=%s to cache: (%s)", newPath, err.Error())
+			return
+		}
+		s.archive.Skipped(duplicate)
 		return
 	} else if err != nil {
-		s.logger.Sugar().Errorf("can't get file=%s from cache: (%s)", path, err.Error())
+		s.logger.Sugar().Errorf("can't get file=%s from cache by content: (%s)", path, err.Error())
 		return
 	}
 
 	s.logger.Sugar().Infof("add entry=%s to files queue", path)
 
-	now := time.Now()
-	newName := fmt.Sprintf("%d_%s", now.UnixNano(), entry.Name())
-	newPath := fmt.Sprintf("%s/%s", s.config.FilesDir, newName)
-	if err := os.Rename(path, newPath); err != nil {
-		s.logger.Sugar().Errorf("can't reanme entry=%s to=%s: (%s)", path, newPath, err.Error())
-		return
+	if newPath == path && !chunk {
+		if newPath, err = s.claim(dir, entry); err != nil {
+			s.logger.Sugar().Errorf("can't reanme entry=%s: (%s)", path, err.Error())
+			return
+		}
+	}
+	// Chunks are parts of the import of their split file, saved with them by the splitter.
+	newFile := files.File{Path: newPath, Name: filepath.Base(newPath), Checksum: checksum, Size: size, Source: path, Parent: cached.Parent, ImportID: cached.ImportID}
+	if !chunk {
+		newFile.ImportID = files.ImportID(newPath)
 	}
-	newFile := files.File{Path: newPath, Name: newName}
 
-	newFileInfo, err := os.Stat(newFile.Path)
+	uncompressed, err := files.UncompressedSize(newPath)
 	if err != nil {
-		s.logger.Sugar().Errorf("can't get file=%s info: (%s)", newFile, err.Error())
-		return
+		s.logger.Sugar().Errorf("can't get file=%s uncompressed size: (%s)", newFile, err.Error())
+		uncompressed = size
 	}
-	if newFileInfo.Size() >= s.config.MaxFileSizeBytes {
+	if s.route(dir, entry, uncompressed) == config.RouteSplit || !s.importable(newFile) {
+		newFile.Status = files.StatusSplit
 		if err := s.cache.Put(newFile); err != nil {
 			s.logger.Sugar().Errorf("can't add file=%s to cache: (%s)", newFile, err.Error())
 			return
@@ -144,6 +499,7 @@ func (s *V1) add(entry os.DirEntry) {
 		return
 	}
 
+	newFile.Status = files.StatusQueued
 	if err := s.cache.Put(newFile); err != nil {
 		s.logger.Sugar().Errorf("can't add entry=%s to cache: (%s)", newFile, err.Error())
 		return
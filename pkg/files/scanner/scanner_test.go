@@ -2,8 +2,13 @@ package scanner
 
 import (
 	"os"
+	"path/filepath"
 	"prices/pkg/config"
 	"prices/pkg/files"
+	"prices/pkg/files/archiver"
+	"prices/pkg/files/claims"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -33,7 +38,9 @@ func newTestScanner(t *testing.T) (*V1, chan bool) {
 
 	stop := make(chan bool)
 
-	scnnr := NewScanner(wg, log, cfg, filesQ, splitFilesQ, cache, stop)
+	archive := archiver.NewArchiver(wg, log, cfg, cache, nil, stop)
+
+	scnnr := NewScanner(wg, log, cfg, filesQ, splitFilesQ, cache, archive, stop)
 
 	return scnnr, stop
 }
@@ -49,7 +56,7 @@ func TestScanner_GetPath(t *testing.T) {
 	assert.Len(t, entries, 1)
 
 	expected := file.Name()
-	path := scnnr.getPath(entries[0])
+	path := scnnr.getPath(dir, entries[0])
 	assert.Equal(t, expected, path)
 }
 
@@ -63,7 +70,7 @@ func TestScanner_Valid_CSV(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, entries, 1)
 
-	assert.True(t, scnnr.valid(entries[0]))
+	assert.True(t, scnnr.valid(dir, entries[0]))
 }
 
 func TestScanner_Valid_DIR(t *testing.T) {
@@ -76,7 +83,7 @@ func TestScanner_Valid_DIR(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, entries, 1)
 
-	assert.False(t, scnnr.valid(entries[0]))
+	assert.False(t, scnnr.valid(dir, entries[0]))
 }
 
 func TestScanner_Valid_TXT(t *testing.T) {
@@ -89,7 +96,132 @@ func TestScanner_Valid_TXT(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, entries, 1)
 
-	assert.False(t, scnnr.valid(entries[0]))
+	assert.False(t, scnnr.valid(dir, entries[0]))
+}
+
+func TestScanner_Valid_Temp(t *testing.T) {
+	scnnr, _ := newTestScanner(t)
+	dir := scnnr.config.FilesDir
+	scnnr.config.FileScanner.Include = []string{"*"}
+	err := os.WriteFile(filepath.Join(dir, files.TempName(files.ChunkName(0, 50, "1_prices.csv"))), nil, 0644)
+	assert.NoError(t, err)
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.False(t, scnnr.valid(dir, entries[0]))
+}
+
+func TestScanner_Resume(t *testing.T) {
+	scnnr, _ := newTestScanner(t)
+	dir := scnnr.config.FilesDir
+	scnnr.files = files.NewFileQueueInMem(2)
+	cache := scnnr.cache.(*files.FileCacheInMem)
+
+	file := files.File{Path: filepath.Join(dir, "1_prices.csv"), Name: "1_prices.csv", Status: files.StatusQueued, Checkpoint: 10}
+	assert.NoError(t, os.WriteFile(file.Path, nil, 0644))
+	assert.NoError(t, cache.Put(file))
+	// Files that are gone or out of the files directory are not resumed.
+	assert.NoError(t, cache.Put(files.File{Path: filepath.Join(dir, "2_prices.csv"), Name: "2_prices.csv", Status: files.StatusQueued}))
+	assert.NoError(t, cache.Put(files.File{Path: "/other/3_prices.csv", Name: "3_prices.csv", Status: files.StatusQueued}))
+
+	scnnr.resume()
+
+	assert.NoError(t, scnnr.files.(*files.FileQueueInMem).Close())
+	data, err := scnnr.files.Data()
+	assert.NoError(t, err)
+	var resumed []files.File
+	for f := range data {
+		resumed = append(resumed, f)
+	}
+	assert.Equal(t, []files.File{file}, resumed)
+}
+
+func TestScanner_Resume_Chunks(t *testing.T) {
+	scnnr, _ := newTestScanner(t)
+	dir := scnnr.config.FilesDir
+	scnnr.files = files.NewFileQueueInMem(2)
+	cache := scnnr.cache.(*files.FileCacheInMem)
+
+	parent := files.File{Path: writeTestFile(t, dir, "1_prices.csv", []byte("line1\nline2\nline3\n")), Name: "1_prices.csv", Status: files.StatusSplit}
+	assert.NoError(t, cache.Put(parent))
+	imported := files.File{Path: writeTestFile(t, dir, files.ChunkName(0, 1, parent.Name), []byte("line1\n")), Status: files.StatusImported, Parent: parent.Path}
+	queued := files.File{Path: writeTestFile(t, dir, files.ChunkName(1, 2, parent.Name), []byte("line2\n")), Status: files.StatusQueued, Parent: parent.Path}
+	written := files.File{Path: writeTestFile(t, dir, files.ChunkName(2, 3, parent.Name), []byte("line3\n")), Status: files.StatusChunk, Parent: parent.Path}
+	for _, chunk := range []files.File{imported, queued, written} {
+		assert.NoError(t, cache.Put(chunk))
+	}
+
+	scnnr.resume()
+
+	// Chunks are not resumed on their own, their split file is split again instead.
+	assert.True(t, scnnr.files.(*files.FileQueueInMem).Empty())
+	split, err := scnnr.splitFiles.(*files.FileQueueInMem).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, parent, split)
+	assert.FileExists(t, imported.Path)
+	assert.NoFileExists(t, queued.Path)
+	assert.NoFileExists(t, written.Path)
+}
+
+func TestScanner_Resume_Split(t *testing.T) {
+	scnnr, _ := newTestScanner(t)
+	dir := scnnr.config.FilesDir
+	cache := scnnr.cache.(*files.FileCacheInMem)
+
+	// The split file has no chunks in the cache, e.g. it was split into byte ranges.
+	parent := files.File{Path: writeTestFile(t, dir, "1_prices.csv", []byte("line1\n")), Name: "1_prices.csv", Status: files.StatusSplit}
+	assert.NoError(t, cache.Put(parent))
+
+	scnnr.resume()
+
+	split, err := scnnr.splitFiles.(*files.FileQueueInMem).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, parent, split)
+}
+
+func TestScanner_Add_Chunk(t *testing.T) {
+	scnnr, _ := newTestScanner(t)
+	dir := scnnr.config.FilesDir
+	scnnr.files = files.NewFileQueueInMem(1)
+	cache := scnnr.cache.(*files.FileCacheInMem)
+
+	chunk := files.File{Path: writeTestFile(t, dir, files.ChunkName(0, 1, "1_prices.csv"), []byte("line1\n")), Status: files.StatusChunk, Parent: filepath.Join(dir, "1_prices.csv"), ImportID: "1111"}
+	assert.NoError(t, cache.Put(chunk))
+
+	scnnr.scanDir()
+
+	// Chunks keep their path, so the chunk saved by the splitter is queued with its split file and its import.
+	queued, err := scnnr.files.(*files.FileQueueInMem).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, chunk.Path, queued.Path)
+	assert.Equal(t, chunk.Parent, queued.Parent)
+	assert.Equal(t, chunk.ImportID, queued.ImportID)
+	assert.Equal(t, files.StatusQueued, queued.Status)
+}
+
+func TestScanner_Resume_Claims(t *testing.T) {
+	scnnr, _ := newTestScanner(t)
+	dir := scnnr.config.FilesDir
+	scnnr.files = files.NewFileQueueInMem(1)
+	scnnr.config.FileClaims = config.FileClaims{Enabled: true, Dir: filepath.Join(dir, "claims"), Instance: "instance_a"}
+	cache := scnnr.cache.(*files.FileCacheInMem)
+
+	// The file was claimed and checkpointed by the previous run of the instance.
+	assert.NoError(t, os.MkdirAll(scnnr.config.FileClaims.InstanceDir(), 0755))
+	file := files.File{Path: writeTestFile(t, scnnr.config.FileClaims.InstanceDir(), "1_prices.csv", []byte("line1\nline2\n")), Name: "1_prices.csv", Status: files.StatusQueued, Checkpoint: 1}
+	assert.NoError(t, cache.Put(file))
+
+	clms := claims.NewClaims(scnnr.wg, zap.NewNop(), scnnr.config, cache, nil, scnnr.stop)
+	assert.NoError(t, clms.Start())
+	scnnr.resume()
+
+	// The file is resumed in place after its checkpoint, not released and imported again from the start.
+	resumed, err := scnnr.files.(*files.FileQueueInMem).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, file, resumed)
+	assert.FileExists(t, file.Path)
 }
 
 func TestScanner_Add_NewFile(t *testing.T) {
@@ -112,7 +244,7 @@ func TestScanner_Add_NewFile(t *testing.T) {
 
 	testWg.Add(1)
 	go func() {
-		scnnr.add(entries[0])
+		scnnr.add(dir, entries[0])
 		testWg.Done()
 	}()
 
@@ -160,7 +292,7 @@ func TestScanner_Add_NewFile_PushToSplitQueue(t *testing.T) {
 
 	testWg.Add(1)
 	go func() {
-		scnnr.add(entries[0])
+		scnnr.add(dir, entries[0])
 		testWg.Done()
 	}()
 
@@ -182,6 +314,23 @@ func TestScanner_Add_NewFile_PushToSplitQueue(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestScanner_Importable_Validation(t *testing.T) {
+	scnnr, _ := newTestScanner(t)
+	dir := scnnr.config.FilesDir
+	file := files.File{Path: filepath.Join(dir, "1_prices.csv"), Source: filepath.Join(dir, "prices.csv")}
+	chunk := files.File{Path: filepath.Join(dir, files.ChunkName(0, 10, "1_prices.csv")), Source: filepath.Join(dir, files.ChunkName(0, 10, "1_prices.csv"))}
+
+	assert.True(t, scnnr.importable(file))
+
+	// Files are split to validate their rows, chunks are imported as they are.
+	scnnr.config.Validation.Enabled = true
+	assert.False(t, scnnr.importable(file))
+	assert.True(t, scnnr.importable(chunk))
+
+	scnnr.config.ImportByLines = true
+	assert.True(t, scnnr.importable(file))
+}
+
 func TestScanner_Add_FileAlreadyInCache(t *testing.T) {
 	scnnr, _ := newTestScanner(t)
 	dir := scnnr.config.FilesDir
@@ -202,7 +351,7 @@ func TestScanner_Add_FileAlreadyInCache(t *testing.T) {
 
 	testWg.Add(1)
 	go func() {
-		scnnr.add(entries[0])
+		scnnr.add(dir, entries[0])
 		testWg.Done()
 	}()
 
@@ -301,3 +450,203 @@ func TestScanner_Scan_Stop(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, entries, numFiles)
 }
+
+func TestScanner_Scan_Watch(t *testing.T) {
+	scnnr, stop := newTestScanner(t)
+	scnnr.config.FileScanner = config.FileScanner{
+		Mode:               config.ScannerModeWatch,
+		CheckEveryDuration: time.Hour,
+		SettleDuration:     10 * time.Millisecond,
+	}
+	dir := scnnr.config.FilesDir
+	filesQ := scnnr.files.(*files.FileQueueInMem)
+
+	go scnnr.Scan()
+	defer close(stop)
+
+	// The first scan finds nothing, the file is only found by the watch event.
+	time.Sleep(50 * time.Millisecond)
+	file, err := os.CreateTemp(dir, "*.csv")
+	assert.NoError(t, err)
+	_, err = file.WriteString("test_id_1,1,2023-08-24 10:01:40\n")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	data, err := filesQ.Data()
+	assert.NoError(t, err)
+	select {
+	case newFile := <-data:
+		_, err = os.Stat(newFile.Path)
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("file isn't added by the watch event")
+	}
+
+	_, err = os.Stat(file.Name())
+	assert.Error(t, err)
+	assert.True(t, filesQ.Empty())
+}
+
+func TestScanner_ScanDir_Patterns(t *testing.T) {
+	scnnr, _ := newTestScanner(t)
+	dir := scnnr.config.FilesDir
+	filesQ := files.NewFileQueueInMem(10)
+	splitFilesQ := files.NewFileQueueInMem(10)
+	scnnr.files = filesQ
+	scnnr.splitFiles = splitFilesQ
+	scnnr.config.Snapshots.Dir = filepath.Join(dir, "snapshots")
+	scnnr.config.FileScanner = config.FileScanner{
+		MaxDepth: 2,
+		Include:  []string{"vendor_*.csv", "vendor_*.txt"},
+		Exclude:  []string{"*_draft.csv", "archive"},
+		Routes: []config.Route{
+			{Pattern: "big/*/*", Queue: config.RouteSplit},
+		},
+	}
+
+	for _, name := range []string{
+		"vendor_a.csv",
+		"other.csv",
+		"vendor_a_draft.csv",
+		files.ChunkName(0, 50, "1692870834247604000_vendor_b.csv"),
+		"2024_01_15_vendor_g.csv",
+		"2023/08/vendor_c.txt",
+		"2023/08/24/vendor_too_deep.csv",
+		"archive/vendor_d.csv",
+		"snapshots/20230824/vendor_e.csv",
+		"big/2023/vendor_f.csv",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, nil, 0644))
+	}
+
+	scnnr.scanDir()
+
+	names := func(q *files.FileQueueInMem) []string {
+		var res []string
+		for !q.Empty() {
+			file, err := q.Get()
+			assert.NoError(t, err)
+			rel, err := filepath.Rel(dir, file.Path)
+			assert.NoError(t, err)
+			// Strip the prefix added by the scanner, chunks are not renamed.
+			name := file.Name
+			if file.Path != file.Source {
+				name = name[strings.Index(name, "_")+1:]
+			}
+			res = append(res, filepath.Join(filepath.Dir(rel), name))
+		}
+		sort.Strings(res)
+		return res
+	}
+	assert.Equal(t, []string{
+		"0_50_1692870834247604000_vendor_b.chunk.csv",
+		filepath.FromSlash("2023/08/vendor_c.txt"),
+		"vendor_a.csv",
+	}, names(filesQ))
+	assert.Equal(t, []string{filepath.FromSlash("big/2023/vendor_f.csv")}, names(splitFilesQ))
+}
+
+func TestScanner_Add_Duplicate(t *testing.T) {
+	scnnr, _ := newTestScanner(t)
+	dir := scnnr.config.FilesDir
+	filesQ := files.NewFileQueueInMem(2)
+	scnnr.files = filesQ
+	cache := scnnr.cache.(*files.FileCacheInMem)
+
+	data := []byte("test_id_1,1,2023-08-24 10:01:40 +0000 UTC\n")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "prices.csv"), data, 0644))
+	scnnr.scanDir()
+	file, err := filesQ.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, files.StatusQueued, file.Status)
+
+	// The original is not imported yet, so a copy of it is imported too, in case it fails.
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "prices_queued_copy.csv"), data, 0644))
+	scnnr.scanDir()
+	queuedCopy, err := filesQ.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, files.StatusQueued, queuedCopy.Status)
+
+	file.Status = files.StatusImported
+	assert.NoError(t, cache.Put(file))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "prices_copy.csv"), data, 0644))
+	scnnr.scanDir()
+	scnnr.scanDir()
+	assert.True(t, filesQ.Empty())
+
+	duplicate, ok, err := cache.Get(filepath.Join(dir, "prices_copy.csv"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, files.StatusDuplicate, duplicate.Status)
+	assert.Equal(t, file.Checksum, duplicate.Checksum)
+}
+
+func TestScanner_Add_Chunk_NotDuplicate(t *testing.T) {
+	scnnr, _ := newTestScanner(t)
+	dir := scnnr.config.FilesDir
+	filesQ := files.NewFileQueueInMem(2)
+	scnnr.files = filesQ
+	cache := scnnr.cache.(*files.FileCacheInMem)
+	data := []byte("test_id_1,1,2023-08-24 10:01:40 +0000 UTC\n")
+
+	checksum, size, err := files.Checksum(writeTestFile(t, dir, "original.csv", data))
+	assert.NoError(t, err)
+	assert.NoError(t, cache.Put(files.File{Path: "/processed/original.csv", Checksum: checksum, Size: size, Status: files.StatusImported}))
+	chunkPath := writeTestFile(t, dir, files.ChunkName(0, 1, "1_prices.csv"), data)
+	assert.NoError(t, os.Remove(filepath.Join(dir, "original.csv")))
+
+	scnnr.scanDir()
+
+	chunk, err := filesQ.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, files.StatusQueued, chunk.Status)
+	assert.Equal(t, chunkPath, chunk.Source)
+	assert.Empty(t, chunk.Checksum)
+	_, ok, err := cache.GetByContent(checksum, size)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func writeTestFile(t *testing.T, dir string, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+func TestScanner_Add_Claims(t *testing.T) {
+	scnnr, stop := newTestScanner(t)
+	dir := scnnr.config.FilesDir
+	filesQ := files.NewFileQueueInMem(2)
+	scnnr.files = filesQ
+
+	instanceA := *scnnr.config
+	instanceA.FileClaims = config.FileClaims{Enabled: true, Dir: filepath.Join(dir, "claims"), Instance: "instance_a"}
+	scnnr.config = &instanceA
+
+	instanceB := instanceA
+	instanceB.FileClaims.Instance = "instance_b"
+	other := NewScanner(scnnr.wg, zap.NewNop(), &instanceB, filesQ, scnnr.splitFiles, files.NewFileCacheInMem(), scnnr.archive, stop)
+
+	err := os.MkdirAll(filepath.Join(dir, "vendor"), 0755)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "vendor", "prices.csv"), []byte("test_id_1,1,2023-08-24 10:01:40 +0000 UTC\n"), 0644)
+	assert.NoError(t, err)
+
+	entries, err := os.ReadDir(filepath.Join(dir, "vendor"))
+	assert.NoError(t, err)
+	scnnr.add(filepath.Join(dir, "vendor"), entries[0])
+	other.add(filepath.Join(dir, "vendor"), entries[0])
+
+	file, err := filesQ.Get()
+	assert.NoError(t, err)
+	assert.True(t, filesQ.Empty())
+	assert.Equal(t, filepath.Join(instanceA.FileClaims.InstanceDir(), "vendor", file.Name), file.Path)
+	assert.Equal(t, filepath.Join(dir, "vendor", "prices.csv"), file.Source)
+	assert.FileExists(t, file.Path)
+
+	// The claims directory is not scanned, and only chunks are added from the claim directory of the instance.
+	scnnr.scanDir()
+	assert.True(t, filesQ.Empty())
+}
@@ -1,17 +1,29 @@
 package splitter
 
 import (
+	"bytes"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"prices/pkg/config"
 	"prices/pkg/files"
+	"prices/pkg/files/rows"
+	"prices/pkg/files/validation"
+	"prices/pkg/metrics"
+	"strings"
 	"sync"
 
 	"go.uber.org/zap"
 )
 
+const (
+	// lineEndBufferSize - how many bytes are read at once looking for the end of a range.
+	lineEndBufferSize = 64 << 10
+)
+
 type (
 	FileLines struct {
 		File   files.File
@@ -24,6 +36,12 @@ type (
 		Data() (<-chan files.File, error)
 	}
 
+	ChunkTracker interface {
+		AddChunk(file files.File, chunk files.File) bool
+		Split(file files.File, err error)
+		Failed(file files.File, err error)
+	}
+
 	V1 struct {
 		wg         *sync.WaitGroup
 		wgInternal *sync.WaitGroup
@@ -31,7 +49,11 @@ type (
 		stop       <-chan bool
 		fileLines  chan FileLines
 		files      FileQueue
-		logger     *zap.Logger
+		// processFiles - queue of the processor, byte ranges of split files are sent directly to it.
+		processFiles FileQueue
+		tracker      ChunkTracker
+		validator    *validation.Validator
+		logger       *zap.Logger
 	}
 )
 
@@ -40,25 +62,36 @@ func NewSplitter(
 	logger *zap.Logger,
 	config *config.FileProcessor,
 	splitFiles FileQueue,
+	processFiles FileQueue,
+	tracker ChunkTracker,
 	stop <-chan bool,
 ) *V1 {
 	log := logger.Named("FileSplitter")
 	wgInternal := &sync.WaitGroup{}
 	s := &V1{
-		wg:         wg,
-		wgInternal: wgInternal,
-		config:     config,
-		stop:       stop,
-		files:      splitFiles,
-		fileLines:  make(chan FileLines, config.FileSplitter.FileLinesQueueSize),
-		logger:     log,
+		wg:           wg,
+		wgInternal:   wgInternal,
+		config:       config,
+		stop:         stop,
+		files:        splitFiles,
+		processFiles: processFiles,
+		tracker:      tracker,
+		validator:    validation.NewValidator(config),
+		fileLines:    make(chan FileLines, config.FileSplitter.FileLinesQueueSize),
+		logger:       log,
 	}
 	return s
 }
 
+// QueueLength - returns number of chunks read from split files that wait to be written.
+func (s *V1) QueueLength() int {
+	return len(s.fileLines)
+}
+
 func (s *V1) Split() {
 	s.logger.Sugar().Infof("start file V1")
 	s.wg.Add(1)
+	s.removeTemp()
 	go s.splitFiles()
 	for i := 0; i < s.config.FileSplitter.WorkersCount; i++ {
 		s.logger.Sugar().Infof("start file V1 worker")
@@ -88,17 +121,40 @@ func (s *V1) splitFiles() {
 func (s *V1) splitFile(file files.File) {
 	s.logger.Sugar().Infof("try to split file=%s", file)
 	defer s.wgInternal.Done()
-	f, err := os.Open(file.Path)
+	if s.ranges(file) {
+		s.splitRanges(file)
+		return
+	}
+	format := rows.Format(s.config, file)
+	validated := s.validator.File(file, format)
+	// Rows are checked before any chunk is written, so a file with too many rejected rows isn't imported in part.
+	if s.config.Validation.Enabled {
+		var err error
+		if validated, err = s.validator.Check(file, format); err != nil {
+			s.logger.Sugar().Errorf("bad file=%s data: (%s)", file, err.Error())
+			s.tracker.Split(file, err)
+			return
+		}
+	}
+	f, err := files.Open(file.Path)
 	if err != nil {
 		s.logger.Sugar().Errorf("can't open file=%s: (%s)", file, err.Error())
+		s.tracker.Split(file, err)
+		return
+	}
+	reader, err := rows.NewDecoder(f, format)
+	if err != nil {
+		s.logger.Sugar().Errorf("can't decode file=%s: (%s)", file, err.Error())
+		_ = f.Close()
+		s.tracker.Split(file, err)
 		return
 	}
-	reader := csv.NewReader(f)
 	var lines [][]string
 	counter := 0
 	for {
 		line, err := reader.Read()
-		if err != nil {
+		var rowErr *rows.RowError
+		if err != nil && !errors.As(err, &rowErr) {
 			if err == io.EOF {
 				if len(lines) > 0 {
 					s.pushFileLines(file, lines, counter-len(lines), counter)
@@ -108,8 +164,21 @@ func (s *V1) splitFile(file files.File) {
 				break
 			}
 			s.logger.Sugar().Errorf("can't read file=%s data: (%s)", file, err.Error())
+			_ = f.Close()
+			_ = validated.Close()
+			s.tracker.Split(file, err)
 			return
 		}
+		if rowErr != nil {
+			validated.Reject(rowErr.Row, rowErr.Err)
+			continue
+		}
+		// Rows are validated only if the validation is enabled, otherwise rows that can't be parsed are skipped on import.
+		if s.config.Validation.Enabled {
+			if _, err := validated.Validate(line); err != nil {
+				continue
+			}
+		}
 		counter += 1
 		lines = append(lines, line)
 		if len(lines)%s.config.FileSplitter.SplitByLines == 0 {
@@ -117,37 +186,177 @@ func (s *V1) splitFile(file files.File) {
 			lines = nil
 		}
 	}
+	if err := validated.Close(); err != nil {
+		s.logger.Sugar().Errorf("bad file=%s data: (%s)", file, err.Error())
+		s.tracker.Split(file, err)
+		return
+	}
+	if rejected := validated.Rejected(); rejected > 0 {
+		s.logger.Sugar().Warnf("rejected rows=%d of file=%s", rejected, file)
+	}
+	s.tracker.Split(file, nil)
+	metrics.SplitFiles.Inc()
 	s.logger.Sugar().Infof("done splitting file=%s", file)
 }
 
+// ranges - reports if the file is divided into byte ranges, the ranges are imported as they are,
+// so the file must be an uncompressed csv file whose rows aren't validated.
+func (s *V1) ranges(file files.File) bool {
+	if s.config.FileSplitter.Mode != config.SplitModeRanges || s.config.Validation.Enabled {
+		return false
+	}
+	if !rows.Default(rows.Format(s.config, file)) {
+		return false
+	}
+	compression, err := files.Compression(file.Path)
+	return err == nil && compression == files.CompressionNone
+}
+
+// splitRanges - divides the file into byte ranges of about SplitByBytes ending with a line break,
+// and sends them to the processing queue. Line breaks in quoted values are not told apart from line breaks between rows.
+// A file that is split again after a restart is divided from its checkpoint, the ranges before it are imported.
+func (s *V1) splitRanges(file files.File) {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		s.logger.Sugar().Errorf("can't open file=%s: (%s)", file, err.Error())
+		s.tracker.Split(file, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		s.logger.Sugar().Errorf("can't get file=%s size: (%s)", file, err.Error())
+		s.tracker.Split(file, err)
+		return
+	}
+
+	size := info.Size()
+	rangeSize := s.config.FileSplitter.SplitByBytes
+	if rangeSize <= 0 {
+		rangeSize = s.config.MaxFileSizeBytes
+	}
+	for offset := file.Checkpoint; offset < size; {
+		end := size
+		if offset+rangeSize < size {
+			if end, err = lineEnd(f, offset+rangeSize-1, size); err != nil {
+				s.logger.Sugar().Errorf("can't read file=%s data: (%s)", file, err.Error())
+				s.tracker.Split(file, err)
+				return
+			}
+		}
+		r := file
+		r.Status = files.StatusQueued
+		r.Offset, r.Length = offset, end-offset
+		r.Checkpoint = 0
+		r.Parent = file.Path
+		offset = end
+		if !s.tracker.AddChunk(file, r) {
+			continue
+		}
+		if err := s.processFiles.Put(r); err != nil {
+			s.logger.Sugar().Errorf("can't add range=%s to files queue: (%s)", r, err.Error())
+			s.tracker.Failed(r, err)
+		}
+	}
+	s.tracker.Split(file, nil)
+	metrics.SplitFiles.Inc()
+	s.logger.Sugar().Infof("done splitting file=%s into ranges", file)
+}
+
+// lineEnd - returns offset right after the first line break at or after the offset, or the size if there is none.
+func lineEnd(f io.ReaderAt, offset int64, size int64) (int64, error) {
+	buf := make([]byte, lineEndBufferSize)
+	for offset < size {
+		n, err := f.ReadAt(buf, offset)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return offset + int64(i) + 1, nil
+		}
+		offset += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// pushFileLines - sends lines of the chunk to be written, unless the chunk was imported before the file was split again.
 func (s *V1) pushFileLines(file files.File, lines [][]string, start int, end int) {
+	path := fmt.Sprintf("%s/%s", s.config.ChunksDir(), files.ChunkName(start, end, chunkName(file)))
 	fileLines := FileLines{
-		File: files.File{
-			Path: fmt.Sprintf("%s/%d_%d_%s", s.config.FilesDir, start, end, file.Name),
-		},
+		File:   files.File{Path: path, Name: filepath.Base(path), Source: path},
 		Lines:  lines,
 		Parent: file,
 	}
+	if !s.tracker.AddChunk(file, fileLines.File) {
+		return
+	}
 	s.fileLines <- fileLines
 }
 
+// chunkName - returns name of the split file, chunks are csv files whatever the format and the compression of the split file.
+func chunkName(file files.File) string {
+	name := files.DecompressedName(file.Name)
+	if ext := filepath.Ext(name); ext != "" && ext != ".csv" {
+		return strings.TrimSuffix(name, ext) + ".csv"
+	}
+	return name
+}
+
 func (s *V1) processSplits() {
 	defer func() {
 		s.logger.Sugar().Infof("stop file V1 worker")
 		s.wgInternal.Done()
 	}()
 	for fl := range s.fileLines {
-		f, err := os.Create(fl.File.Path)
-		if err != nil {
-			s.logger.Sugar().Errorf("can't to create file=%s: (%s)", fl.File.Path, err.Error())
-			s.wgInternal.Done()
-			return
-		}
-		writer := csv.NewWriter(f)
-		err = writer.WriteAll(fl.Lines)
-		if err != nil {
+		if err := s.writeChunk(fl); err != nil {
 			s.logger.Sugar().Errorf("can't write file=%s: (%s)", fl.File.Path, err.Error())
+			s.tracker.Failed(files.File{Path: fl.File.Path, Name: filepath.Base(fl.File.Path), Source: fl.File.Path}, err)
+		}
+	}
+}
+
+// writeChunk - writes lines of the chunk to a temporary file, and renames it to the chunk once it is synced,
+// so the scanner never finds a chunk that is partly written. The temporary file is removed if it can't be written.
+func (s *V1) writeChunk(fl FileLines) error {
+	tmp := files.TempName(fl.File.Path)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(f)
+	err = writer.WriteAll(fl.Lines)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, fl.File.Path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// removeTemp - removes chunks that were left partly written when the application stopped,
+// their split files are split again by the scanner once it starts, so the chunks are written again.
+func (s *V1) removeTemp() {
+	entries, err := os.ReadDir(s.config.ChunksDir())
+	if err != nil {
+		s.logger.Sugar().Errorf("can't read directory=%s: (%s)", s.config.ChunksDir(), err.Error())
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !files.IsTemp(entry.Name()) || !files.IsChunk(strings.TrimSuffix(entry.Name(), files.TempSuffix)) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.config.ChunksDir(), entry.Name())); err != nil {
+			s.logger.Sugar().Errorf("can't remove chunk=%s: (%s)", entry.Name(), err.Error())
 		}
-		f.Close()
 	}
 }
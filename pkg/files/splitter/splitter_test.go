@@ -1,14 +1,21 @@
 package splitter
 
 import (
+	"compress/gzip"
 	"encoding/csv"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"prices/pkg/config"
 	"prices/pkg/files"
+	"prices/pkg/files/archiver"
+	"prices/pkg/files/validation"
 	"prices/pkg/testutils"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
@@ -35,11 +42,25 @@ func newTestSplitter(t *testing.T) (*V1, chan bool) {
 
 	stop := make(chan bool)
 
-	splttr := NewSplitter(wg, log, cfg, splitFiles, stop)
+	tracker := archiver.NewArchiver(wg, log, cfg, files.NewFileCacheInMem(), nil, stop)
+
+	splttr := NewSplitter(wg, log, cfg, splitFiles, files.NewFileQueueInMem(10), tracker, stop)
 
 	return splttr, stop
 }
 
+// waitFiles - waits until all files exist.
+func waitFiles(paths ...string) {
+	for _, path := range paths {
+		for {
+			if _, err := os.Stat(path); err == nil {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
 func TestSplitter_PushFileLines(t *testing.T) {
 	splttr, _ := newTestSplitter(t)
 	dir := splttr.config.FilesDir
@@ -54,7 +75,9 @@ func TestSplitter_PushFileLines(t *testing.T) {
 
 	expectedLines := FileLines{
 		File: files.File{
-			Path: fmt.Sprintf("%s/%d_%d_%s", dir, 0, 1, file.Name),
+			Path:   fmt.Sprintf("%s/%s", dir, files.ChunkName(0, 1, file.Name)),
+			Name:   files.ChunkName(0, 1, file.Name),
+			Source: fmt.Sprintf("%s/%s", dir, files.ChunkName(0, 1, file.Name)),
 		},
 		Lines:  lines,
 		Parent: file,
@@ -84,11 +107,15 @@ func TestSplitter_SplitFile(t *testing.T) {
 	}
 
 	expectedFile1 := files.File{
-		Path: fmt.Sprintf("%s/%d_%d_%s", dir, 0, 50, file.Name),
+		Path:   fmt.Sprintf("%s/%s", dir, files.ChunkName(0, 50, file.Name)),
+		Name:   files.ChunkName(0, 50, file.Name),
+		Source: fmt.Sprintf("%s/%s", dir, files.ChunkName(0, 50, file.Name)),
 	}
 	var expectedFile1Lines [][]string
 	expectedFile2 := files.File{
-		Path: fmt.Sprintf("%s/%d_%d_%s", dir, 50, 100, file.Name),
+		Path:   fmt.Sprintf("%s/%s", dir, files.ChunkName(50, 100, file.Name)),
+		Name:   files.ChunkName(50, 100, file.Name),
+		Source: fmt.Sprintf("%s/%s", dir, files.ChunkName(50, 100, file.Name)),
 	}
 	var expectedFile2Lines [][]string
 
@@ -118,6 +145,169 @@ func TestSplitter_SplitFile(t *testing.T) {
 	assert.Equal(t, expectedFile2Lines, lines2.Lines)
 }
 
+func TestSplitter_SplitFile_Gzip(t *testing.T) {
+	splttr, _ := newTestSplitter(t)
+	dir := splttr.config.FilesDir
+	data := "id_1,1,2023-08-24 10:01:40 +0000 UTC\nid_2,2,2023-08-24 10:01:40 +0000 UTC\n"
+
+	file := files.File{
+		Path: fmt.Sprintf("%s/%s", dir, "1_prices.csv.gz"),
+		Name: "1_prices.csv.gz",
+	}
+	f, err := os.Create(file.Path)
+	assert.NoError(t, err)
+	gz := gzip.NewWriter(f)
+	_, err = gz.Write([]byte(data))
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+	assert.NoError(t, f.Close())
+
+	splttr.wgInternal.Add(1)
+	go splttr.splitFile(file)
+
+	lines := <-splttr.fileLines
+	assert.Equal(t, fmt.Sprintf("%s/%s", dir, files.ChunkName(0, 2, "1_prices.csv")), lines.File.Path)
+	assert.Equal(t, [][]string{
+		{"id_1", "1", "2023-08-24 10:01:40 +0000 UTC"},
+		{"id_2", "2", "2023-08-24 10:01:40 +0000 UTC"},
+	}, lines.Lines)
+}
+
+func TestSplitter_SplitFile_Validation(t *testing.T) {
+	splttr, _ := newTestSplitter(t)
+	dir := splttr.config.FilesDir
+	splttr.config.Validation = config.Validation{Enabled: true, MaxRejectedPercent: 50}
+	splttr.config.FileArchive.FailedDir = filepath.Join(dir, "failed")
+	data := "id_1,1,2023-08-24 10:01:40 +0000 UTC\nid_2,-2,2023-08-24 10:01:40 +0000 UTC\nid_3,3,2023-08-24 10:01:40 +0000 UTC\n"
+
+	file := files.File{
+		Path: filepath.Join(dir, "1_prices.csv"),
+		Name: "1_prices.csv",
+	}
+	assert.NoError(t, os.WriteFile(file.Path, []byte(data), 0644))
+
+	splttr.wgInternal.Add(1)
+	go splttr.splitFile(file)
+
+	lines := <-splttr.fileLines
+	assert.Equal(t, fmt.Sprintf("%s/%s", dir, files.ChunkName(0, 2, "1_prices.csv")), lines.File.Path)
+	assert.Equal(t, [][]string{
+		{"id_1", "1", "2023-08-24 10:01:40 +0000 UTC"},
+		{"id_3", "3", "2023-08-24 10:01:40 +0000 UTC"},
+	}, lines.Lines)
+	splttr.wgInternal.Wait()
+
+	report, err := os.ReadFile(filepath.Join(dir, "failed", "1_prices.csv"+validation.RejectsSuffix))
+	assert.NoError(t, err)
+	assert.Equal(t, "file,line,reason,id,price,expiration_date\n1_prices.csv,2,price=-2 is negative,id_2,-2,2023-08-24 10:01:40 +0000 UTC\n", string(report))
+}
+
+func TestSplitter_SplitFile_TooManyRejects(t *testing.T) {
+	splttr, _ := newTestSplitter(t)
+	dir := splttr.config.FilesDir
+	splttr.config.Validation = config.Validation{Enabled: true, MaxRejectedPercent: 10}
+	splttr.config.FileSplitter.SplitByLines = 1
+	splttr.config.FileArchive.FailedDir = filepath.Join(dir, "failed")
+	data := "id_1,1,2023-08-24 10:01:40 +0000 UTC\nid_2,-2,2023-08-24 10:01:40 +0000 UTC\nid_3,3,2023-08-24 10:01:40 +0000 UTC\n"
+
+	file := files.File{
+		Path: filepath.Join(dir, "1_prices.csv"),
+		Name: "1_prices.csv",
+	}
+	assert.NoError(t, os.WriteFile(file.Path, []byte(data), 0644))
+
+	splttr.wgInternal.Add(1)
+	splttr.splitFile(file)
+
+	// The file fails before any of its chunks is written.
+	assert.Empty(t, splttr.fileLines)
+	report, err := os.ReadFile(filepath.Join(dir, "failed", "1_prices.csv"+validation.RejectsSuffix))
+	assert.NoError(t, err)
+	assert.Equal(t, "file,line,reason,id,price,expiration_date\n1_prices.csv,2,price=-2 is negative,id_2,-2,2023-08-24 10:01:40 +0000 UTC\n", string(report))
+}
+
+func TestSplitter_SplitFile_Ranges(t *testing.T) {
+	splttr, _ := newTestSplitter(t)
+	dir := splttr.config.FilesDir
+	splttr.config.FileSplitter.Mode = config.SplitModeRanges
+	splttr.config.FileSplitter.SplitByBytes = 30
+	data := "id_1,1,2023-08-24 10:01:40 +0000 UTC\nid_2,2,2023-08-24 10:01:40 +0000 UTC\nid_3,3,2023-08-24 10:01:40 +0000 UTC"
+
+	file := files.File{
+		Path: filepath.Join(dir, "1_prices.csv"),
+		Name: "1_prices.csv",
+	}
+	assert.NoError(t, os.WriteFile(file.Path, []byte(data), 0644))
+
+	splttr.wgInternal.Add(1)
+	splttr.splitFile(file)
+
+	fls, err := splttr.processFiles.Data()
+	assert.NoError(t, err)
+	var ranges []string
+	for _, expected := range []struct{ offset, length int64 }{{0, 37}, {37, 37}, {74, 36}} {
+		r := <-fls
+		assert.Equal(t, expected.offset, r.Offset)
+		assert.Equal(t, expected.length, r.Length)
+		assert.Equal(t, files.StatusQueued, r.Status)
+		rc, err := r.Open()
+		assert.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		assert.NoError(t, rc.Close())
+		ranges = append(ranges, string(b))
+	}
+	assert.Equal(t, data, strings.Join(ranges, ""))
+	// No chunk files are written.
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestSplitter_SplitFile_Ranges_Resume(t *testing.T) {
+	splttr, _ := newTestSplitter(t)
+	dir := splttr.config.FilesDir
+	splttr.config.FileSplitter.Mode = config.SplitModeRanges
+	splttr.config.FileSplitter.SplitByBytes = 30
+	data := "id_1,1,2023-08-24 10:01:40 +0000 UTC\nid_2,2,2023-08-24 10:01:40 +0000 UTC\nid_3,3,2023-08-24 10:01:40 +0000 UTC"
+
+	// The file was split before a restart, and its first range is imported.
+	file := files.File{
+		Path:       filepath.Join(dir, "1_prices.csv"),
+		Name:       "1_prices.csv",
+		Status:     files.StatusSplit,
+		Checkpoint: 37,
+	}
+	assert.NoError(t, os.WriteFile(file.Path, []byte(data), 0644))
+
+	splttr.wgInternal.Add(1)
+	splttr.splitFile(file)
+
+	fls, err := splttr.processFiles.Data()
+	assert.NoError(t, err)
+	for _, expected := range []struct{ offset, length int64 }{{37, 37}, {74, 36}} {
+		r := <-fls
+		assert.Equal(t, expected.offset, r.Offset)
+		assert.Equal(t, expected.length, r.Length)
+		assert.Equal(t, int64(0), r.Checkpoint)
+	}
+	assert.Empty(t, fls)
+}
+
+func TestSplitter_Ranges(t *testing.T) {
+	splttr, _ := newTestSplitter(t)
+	dir := splttr.config.FilesDir
+	path := filepath.Join(dir, "1_prices.csv")
+	assert.NoError(t, os.WriteFile(path, []byte("id_1,1,2023-08-24 10:01:40 +0000 UTC\n"), 0644))
+	file := files.File{Path: path, Name: "1_prices.csv"}
+
+	assert.False(t, splttr.ranges(file))
+	splttr.config.FileSplitter.Mode = config.SplitModeRanges
+	assert.True(t, splttr.ranges(file))
+	splttr.config.Validation.Enabled = true
+	assert.False(t, splttr.ranges(file))
+}
+
 func TestSplitter_ProcessSplits(t *testing.T) {
 	splttr, _ := newTestSplitter(t)
 	dir := splttr.config.FilesDir
@@ -129,7 +319,9 @@ func TestSplitter_ProcessSplits(t *testing.T) {
 
 	lines := FileLines{
 		File: files.File{
-			Path: fmt.Sprintf("%s/%d_%d_%s", dir, 0, 1, file.Name),
+			Path:   fmt.Sprintf("%s/%s", dir, files.ChunkName(0, 1, file.Name)),
+			Name:   files.ChunkName(0, 1, file.Name),
+			Source: fmt.Sprintf("%s/%s", dir, files.ChunkName(0, 1, file.Name)),
 		},
 		Lines: [][]string{
 			{"id_1", "price_1", "expirationDate_1"},
@@ -141,13 +333,10 @@ func TestSplitter_ProcessSplits(t *testing.T) {
 	go splttr.processSplits()
 	splttr.fileLines <- lines
 
-	for {
-		entries, err := os.ReadDir(dir)
-		assert.NoError(t, err)
-		if len(entries) == 1 {
-			break
-		}
-	}
+	// Chunks are renamed once they are written.
+	waitFiles(lines.File.Path)
+	_, err := os.Stat(files.TempName(lines.File.Path))
+	assert.ErrorIs(t, err, os.ErrNotExist)
 
 	f, err := os.Open(lines.File.Path)
 	assert.NoError(t, err)
@@ -159,6 +348,22 @@ func TestSplitter_ProcessSplits(t *testing.T) {
 	assert.Equal(t, lines.Lines, resLines)
 }
 
+func TestSplitter_RemoveTemp(t *testing.T) {
+	splttr, _ := newTestSplitter(t)
+	dir := splttr.config.FilesDir
+	chunk := filepath.Join(dir, files.TempName(files.ChunkName(0, 50, "1_prices.csv")))
+	other := filepath.Join(dir, "prices.csv.tmp")
+	assert.NoError(t, os.WriteFile(chunk, []byte("id_1,1"), 0644))
+	assert.NoError(t, os.WriteFile(other, []byte("id_1,1"), 0644))
+
+	splttr.removeTemp()
+
+	_, err := os.Stat(chunk)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(other)
+	assert.NoError(t, err)
+}
+
 func TestSplitter_Split(t *testing.T) {
 	splttr, stop := newTestSplitter(t)
 	dir := splttr.config.FilesDir
@@ -179,11 +384,15 @@ func TestSplitter_Split(t *testing.T) {
 	}
 
 	expectedFile1 := files.File{
-		Path: fmt.Sprintf("%s/%d_%d_%s", dir, 0, 50, file.Name),
+		Path:   fmt.Sprintf("%s/%s", dir, files.ChunkName(0, 50, file.Name)),
+		Name:   files.ChunkName(0, 50, file.Name),
+		Source: fmt.Sprintf("%s/%s", dir, files.ChunkName(0, 50, file.Name)),
 	}
 	var expectedFile1Lines [][]string
 	expectedFile2 := files.File{
-		Path: fmt.Sprintf("%s/%d_%d_%s", dir, 50, 100, file.Name),
+		Path:   fmt.Sprintf("%s/%s", dir, files.ChunkName(50, 100, file.Name)),
+		Name:   files.ChunkName(50, 100, file.Name),
+		Source: fmt.Sprintf("%s/%s", dir, files.ChunkName(50, 100, file.Name)),
 	}
 	var expectedFile2Lines [][]string
 
@@ -204,13 +413,7 @@ func TestSplitter_Split(t *testing.T) {
 	err = filesQ.Put(file)
 	assert.NoError(t, err)
 
-	for {
-		entries, err := os.ReadDir(dir)
-		assert.NoError(t, err)
-		if len(entries) == 3 {
-			break
-		}
-	}
+	waitFiles(expectedFile1.Path, expectedFile2.Path)
 
 	stop <- true
 	err = filesQ.Close()
@@ -0,0 +1,294 @@
+package validation
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"prices/pkg/config"
+	"prices/pkg/files"
+	"prices/pkg/files/archiver"
+	"prices/pkg/files/rows"
+	"prices/pkg/metrics"
+	"prices/pkg/models"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// RejectsSuffix - suffix of the report of rejected rows written next to the file in the failed directory.
+	RejectsSuffix = ".rejects.csv"
+
+	columns = 3
+	// Prices are DECIMAL(20,10) in the storage.
+	priceScale         = 10
+	priceIntegerDigits = 10
+)
+
+var (
+	// ErrTooManyRejects - more rows of the file are rejected than the validation allows.
+	ErrTooManyRejects = errors.New("too many rejected rows")
+
+	// Dates the DATETIME column of the storage can keep.
+	minExpirationDate = time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxExpirationDate = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+	maxPrice     = decimal.New(1, priceIntegerDigits)
+	rejectHeader = []string{"file", "line", "reason", "id", "price", "expiration_date"}
+)
+
+type (
+	// Validator - converts rows of files to prices, and rejects rows that can't be parsed or fail the checks of the config.
+	// Safe for concurrent usage.
+	Validator struct {
+		config *config.FileProcessor
+		now    func() time.Time
+	}
+
+	// File - validation of rows of a file, rejected rows are written to the report of the file
+	// in the failed directory once the first of them is rejected. Not safe for concurrent usage.
+	File struct {
+		validator *Validator
+		file      files.File
+		// line - line of the last row, multiline values are counted as a single line.
+		line     int64
+		rows     int64
+		rejected int64
+		report   *os.File
+		writer   *csv.Writer
+		err      error
+		// checked - rows of the file are checked before it is imported, so they are converted without being rejected again.
+		checked bool
+	}
+)
+
+func NewValidator(config *config.FileProcessor) *Validator {
+	return &Validator{config: config, now: time.Now}
+}
+
+// Validate - converts the row to a price, or returns the reason the row is rejected.
+// Rows that can't be parsed are always rejected, other checks are made if the validation is enabled.
+func (v *Validator) Validate(row []string) (*models.Price, error) {
+	if len(row) != columns {
+		return nil, fmt.Errorf("row has %d columns instead of %d", len(row), columns)
+	}
+	id, priceData, expirationDate := row[0], row[1], row[2]
+	if id == "" {
+		return nil, errors.New("id is empty")
+	}
+	price, err := decimal.NewFromString(priceData)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse price=%s as a decimal number", priceData)
+	}
+	expDate, err := files.ParseDate(expirationDate, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse expiration date=%s as a timestamp", expirationDate)
+	}
+	if v.config.Validation.Enabled {
+		if err := v.check(id, price, expDate); err != nil {
+			return nil, err
+		}
+	}
+	return &models.Price{ID: id, Price: price, ExpirationDate: expDate}, nil
+}
+
+// check - checks the parsed fields of the row against the config.
+func (v *Validator) check(id string, price decimal.Decimal, expirationDate time.Time) error {
+	cfg := v.config.Validation
+	if cfg.RequireUUID {
+		if _, err := uuid.Parse(id); err != nil || len(id) != len(uuid.Nil.String()) {
+			return fmt.Errorf("id=%s is not a UUID", id)
+		}
+	}
+
+	if price.IsNegative() {
+		return fmt.Errorf("price=%s is negative", price)
+	}
+	if !price.LessThan(maxPrice) || !price.Truncate(priceScale).Equal(price) {
+		return fmt.Errorf("price=%s doesn't fit %d integer and %d fractional digits", price, priceIntegerDigits, priceScale)
+	}
+
+	if expirationDate.Before(minExpirationDate) || expirationDate.After(maxExpirationDate) {
+		return fmt.Errorf("expiration date=%s is out of range", files.FormatDate(expirationDate))
+	}
+	now := v.now()
+	if cfg.MaxExpirationAge > 0 && expirationDate.Before(now.Add(-cfg.MaxExpirationAge)) {
+		return fmt.Errorf("expiration date=%s is older than %s", files.FormatDate(expirationDate), cfg.MaxExpirationAge)
+	}
+	if cfg.MaxExpirationAhead > 0 && expirationDate.After(now.Add(cfg.MaxExpirationAhead)) {
+		return fmt.Errorf("expiration date=%s is later than %s from now", files.FormatDate(expirationDate), cfg.MaxExpirationAhead)
+	}
+	return nil
+}
+
+// File - starts validation of rows of the file, the header of the format is counted as the first line.
+func (v *Validator) File(file files.File, format config.FileFormat) *File {
+	f := &File{validator: v, file: file}
+	if format.Header {
+		f.line = 1
+	}
+	return f
+}
+
+// Check - validates all rows of the file before any of them is imported, and returns ErrTooManyRejects
+// if the file has a bigger percent of rejected rows than allowed, so a file that fails isn't imported in part.
+// Rejected rows are written to the report, rows up to the checkpoint of the file are saved before the application stopped,
+// so they are skipped. Returns validation of the rows for the import, which doesn't reject them again.
+func (v *Validator) Check(file files.File, format config.FileFormat) (*File, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("can't open file=%s to validate rows: %w", file.Path, err)
+	}
+	defer f.Close()
+	decoder, err := rows.NewDecoder(f, format)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode file=%s to validate rows: %w", file.Path, err)
+	}
+
+	validated := v.File(file, format)
+	var read int64
+	for {
+		row, err := decoder.Read()
+		var rowErr *rows.RowError
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !errors.As(err, &rowErr) {
+			_ = validated.Close()
+			return nil, fmt.Errorf("can't read file=%s rows to validate them: %w", file.Path, err)
+		}
+		read++
+		switch {
+		case read <= file.Checkpoint:
+			validated.Skip()
+		case rowErr != nil:
+			validated.Reject(rowErr.Row, rowErr.Err)
+		default:
+			_, _ = validated.Validate(row)
+		}
+	}
+	if err := validated.Close(); err != nil {
+		return nil, err
+	}
+
+	checked := v.File(file, format)
+	checked.checked = true
+	checked.rejected = validated.rejected
+	return checked, nil
+}
+
+// Validate - converts the next row of the file to a price, or returns the reason the row is rejected.
+// If the validation is enabled, the rejected row is written to the report.
+func (f *File) Validate(row []string) (*models.Price, error) {
+	if f.checked {
+		return f.validator.Validate(row)
+	}
+	f.line++
+	f.rows++
+	metrics.ParsedRows.Inc()
+	price, err := f.validator.Validate(row)
+	if err == nil {
+		return price, nil
+	}
+	f.rejectRow(row, err)
+	return nil, err
+}
+
+// Reject - rejects the next row of the file, which can't be decoded for the reason,
+// the same way as Validate rejects rows that can't be parsed.
+func (f *File) Reject(row []string, reason error) {
+	if f.checked {
+		return
+	}
+	f.line++
+	f.rows++
+	metrics.ParsedRows.Inc()
+	f.rejectRow(row, reason)
+}
+
+// Skip - skips the next row of the file, it is saved before the import of the file is resumed,
+// so it is not counted in the percent of rejected rows.
+func (f *File) Skip() {
+	f.line++
+}
+
+// Rejected - returns how many rows of the file are rejected.
+func (f *File) Rejected() int64 {
+	return f.rejected
+}
+
+// Close - closes the report, and returns ErrTooManyRejects if the file has a bigger percent of rejected rows than allowed.
+func (f *File) Close() error {
+	if f.checked {
+		return nil
+	}
+	err := f.err
+	if f.report != nil {
+		f.writer.Flush()
+		if writeErr := f.writer.Error(); err == nil && writeErr != nil {
+			err = fmt.Errorf("can't write rejected rows report: %w", writeErr)
+		}
+		if closeErr := f.report.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("can't close rejected rows report: %w", closeErr)
+		}
+	}
+	maxPercent := f.validator.config.Validation.MaxRejectedPercent
+	if f.rejected > 0 && float64(f.rejected)*100 > maxPercent*float64(f.rows) {
+		return fmt.Errorf("%w: %d of %d rows, more than %g%%", ErrTooManyRejects, f.rejected, f.rows, maxPercent)
+	}
+	return err
+}
+
+// rejectRow - counts the rejected row and writes it to the report, if the validation is enabled.
+func (f *File) rejectRow(row []string, reason error) {
+	if !f.validator.config.Validation.Enabled {
+		return
+	}
+	f.rejected++
+	metrics.RejectedRows.Inc()
+	f.reject(row, reason)
+}
+
+// reject - writes the row to the report, rows are not written if the report can't be created.
+func (f *File) reject(row []string, reason error) {
+	if f.err != nil || f.validator.config.FileArchive.FailedDir == "" {
+		return
+	}
+	if f.report == nil {
+		if f.err = f.create(); f.err != nil {
+			return
+		}
+	}
+	line := append([]string{f.file.Name, strconv.FormatInt(f.line, 10), reason.Error()}, row...)
+	if err := f.writer.Write(line); err != nil {
+		f.err = fmt.Errorf("can't write rejected rows report: %w", err)
+	}
+}
+
+// create - creates the report next to the place the file is quarantined to,
+// the report of a file which import is resumed is appended to.
+func (f *File) create() error {
+	path, err := archiver.Destination(f.validator.config, f.validator.config.FileArchive.FailedDir, f.file)
+	if err != nil {
+		return fmt.Errorf("can't create rejected rows report: %w", err)
+	}
+	report, err := os.OpenFile(path+RejectsSuffix, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("can't create rejected rows report: %w", err)
+	}
+	info, err := report.Stat()
+	if err != nil {
+		_ = report.Close()
+		return fmt.Errorf("can't create rejected rows report: %w", err)
+	}
+	f.report = report
+	f.writer = csv.NewWriter(report)
+	if info.Size() > 0 {
+		return nil
+	}
+	return f.writer.Write(rejectHeader)
+}
@@ -0,0 +1,155 @@
+package validation
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"prices/pkg/config"
+	"prices/pkg/files"
+	"prices/pkg/models"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+var testNow = time.Date(2023, 8, 24, 0, 0, 0, 0, time.UTC)
+
+func newTestValidator(t *testing.T, validation config.Validation) *Validator {
+	dir := t.TempDir()
+	v := NewValidator(&config.FileProcessor{
+		FilesDir:    dir,
+		FileArchive: config.FileArchive{FailedDir: filepath.Join(dir, "failed")},
+		Validation:  validation,
+	})
+	v.now = func() time.Time { return testNow }
+	return v
+}
+
+func TestValidator_Validate(t *testing.T) {
+	v := newTestValidator(t, config.Validation{})
+
+	price, err := v.Validate([]string{"d65d3cba-40c7-11ee-afc6-a45e60d0762b", "666.2111109", "2023-08-23 10:42:33 +0200 CEST"})
+	assert.NoError(t, err)
+	assert.Equal(t, &models.Price{
+		ID:             "d65d3cba-40c7-11ee-afc6-a45e60d0762b",
+		Price:          decimal.RequireFromString("666.2111109"),
+		ExpirationDate: time.Date(2023, 8, 23, 8, 42, 33, 0, time.UTC),
+	}, price)
+
+	// Checks of the config are made only if the validation is enabled.
+	_, err = v.Validate([]string{"test_id_1", "-1", "2023-08-23 10:42:33 +0200 CEST"})
+	assert.NoError(t, err)
+
+	for _, row := range [][]string{
+		{"test_id_1", "1"},
+		{"test_id_1", "1", "2023-08-23 10:42:33 +0200 CEST", "extra"},
+		{"", "1", "2023-08-23 10:42:33 +0200 CEST"},
+		{"test_id_1", "one", "2023-08-23 10:42:33 +0200 CEST"},
+		{"test_id_1", "1", "tomorrow"},
+	} {
+		_, err := v.Validate(row)
+		assert.Error(t, err, row)
+	}
+}
+
+func TestValidator_Validate_Enabled(t *testing.T) {
+	v := newTestValidator(t, config.Validation{
+		Enabled:            true,
+		RequireUUID:        true,
+		MaxExpirationAge:   24 * time.Hour,
+		MaxExpirationAhead: 365 * 24 * time.Hour,
+	})
+	const id = "d65d3cba-40c7-11ee-afc6-a45e60d0762b"
+
+	for _, row := range [][]string{
+		{id, "0", "2023-08-24 10:01:40 +0000 UTC"},
+		{id, "9999999999.9999999999", "2023-08-23 10:01:40 +0000 UTC"},
+		{id, "1.50000000000000", "2024-08-23 00:00:00 +0000 UTC"},
+	} {
+		_, err := v.Validate(row)
+		assert.NoError(t, err, row)
+	}
+
+	for reason, row := range map[string][]string{
+		"not a uuid":        {"test_id_1", "1", "2023-08-24 10:01:40 +0000 UTC"},
+		"uuid with braces":  {"{" + id + "}", "1", "2023-08-24 10:01:40 +0000 UTC"},
+		"negative price":    {id, "-0.01", "2023-08-24 10:01:40 +0000 UTC"},
+		"too big price":     {id, "10000000000", "2023-08-24 10:01:40 +0000 UTC"},
+		"too precise price": {id, "1.00000000001", "2023-08-24 10:01:40 +0000 UTC"},
+		"expired":           {id, "1", "2023-08-22 23:59:59 +0000 UTC"},
+		"too far ahead":     {id, "1", "2024-08-23 00:00:01 +0000 UTC"},
+	} {
+		_, err := v.Validate(row)
+		assert.Error(t, err, reason)
+	}
+}
+
+func TestFile(t *testing.T) {
+	v := newTestValidator(t, config.Validation{Enabled: true, MaxRejectedPercent: 50})
+	file := files.File{Path: filepath.Join(v.config.FilesDir, "vendor", "1_prices.csv"), Name: "1_prices.csv"}
+
+	f := v.File(file, config.FileFormat{Header: true})
+	_, err := f.Validate([]string{"id_1", "1", "2023-08-24 10:01:40 +0000 UTC"})
+	assert.NoError(t, err)
+	_, err = f.Validate([]string{"id_2", "-1", "2023-08-24 10:01:40 +0000 UTC"})
+	assert.Error(t, err)
+	_, err = f.Validate([]string{"id_3", "1", "2023-08-24 10:01:40 +0000 UTC"})
+	assert.NoError(t, err)
+	f.Reject([]string{"{id_4"}, errors.New("can't parse JSON object"))
+	assert.NoError(t, f.Close())
+	assert.Equal(t, int64(2), f.Rejected())
+
+	report, err := os.ReadFile(filepath.Join(v.config.FileArchive.FailedDir, "vendor", "1_prices.csv"+RejectsSuffix))
+	assert.NoError(t, err)
+	assert.Equal(t, "file,line,reason,id,price,expiration_date\n"+
+		"1_prices.csv,3,price=-1 is negative,id_2,-1,2023-08-24 10:01:40 +0000 UTC\n"+
+		"1_prices.csv,5,can't parse JSON object,{id_4\n", string(report))
+}
+
+func TestFile_TooManyRejects(t *testing.T) {
+	v := newTestValidator(t, config.Validation{Enabled: true, MaxRejectedPercent: 50})
+	file := files.File{Path: filepath.Join(v.config.FilesDir, "1_prices.csv"), Name: "1_prices.csv"}
+
+	f := v.File(file, config.FileFormat{})
+	_, err := f.Validate([]string{"id_1", "1", "2023-08-24 10:01:40 +0000 UTC"})
+	assert.NoError(t, err)
+	_, err = f.Validate([]string{"id_2", "1"})
+	assert.Error(t, err)
+	_, err = f.Validate([]string{"id_3"})
+	assert.Error(t, err)
+	assert.ErrorIs(t, f.Close(), ErrTooManyRejects)
+
+	// Rejected rows are not reported if the validation is disabled.
+	v = newTestValidator(t, config.Validation{})
+	f = v.File(file, config.FileFormat{})
+	_, err = f.Validate([]string{"id_2", "1"})
+	assert.Error(t, err)
+	assert.NoError(t, f.Close())
+	assert.NoDirExists(t, v.config.FileArchive.FailedDir)
+}
+
+func TestValidator_Check(t *testing.T) {
+	v := newTestValidator(t, config.Validation{Enabled: true, MaxRejectedPercent: 50})
+	file := files.File{Path: filepath.Join(v.config.FilesDir, "1_prices.csv"), Name: "1_prices.csv", Checkpoint: 1}
+	data := "id_1,-1,2023-08-24 10:01:40 +0000 UTC\nid_2,2,2023-08-24 10:01:40 +0000 UTC\nid_3,-3,2023-08-24 10:01:40 +0000 UTC\n"
+	assert.NoError(t, os.WriteFile(file.Path, []byte(data), 0644))
+
+	// Rows up to the checkpoint are not validated again.
+	checked, err := v.Check(file, config.FileFormat{Format: config.FormatCSV})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), checked.Rejected())
+
+	// Rows of the checked file are converted without being rejected again.
+	_, err = checked.Validate([]string{"id_3", "-3", "2023-08-24 10:01:40 +0000 UTC"})
+	assert.Error(t, err)
+	assert.NoError(t, checked.Close())
+	report, err := os.ReadFile(filepath.Join(v.config.FileArchive.FailedDir, "1_prices.csv"+RejectsSuffix))
+	assert.NoError(t, err)
+	assert.Equal(t, "file,line,reason,id,price,expiration_date\n1_prices.csv,3,price=-3 is negative,id_3,-3,2023-08-24 10:01:40 +0000 UTC\n", string(report))
+
+	file.Checkpoint = 0
+	_, err = v.Check(file, config.FileFormat{Format: config.FormatCSV})
+	assert.ErrorIs(t, err, ErrTooManyRejects)
+}
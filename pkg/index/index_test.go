@@ -0,0 +1,213 @@
+package index
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"prices/pkg/models"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestIndex(t *testing.T, prices ...*models.Price) *Reader {
+	dir := t.TempDir()
+	w, err := NewWriter(dir)
+	assert.NoError(t, err)
+	for _, price := range prices {
+		assert.NoError(t, w.Add(price))
+	}
+	path, err := w.Commit()
+	assert.NoError(t, err)
+
+	r, err := Open(path)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = r.Close()
+	})
+	return r
+}
+
+func TestIndex_Get(t *testing.T) {
+	expirationDate := time.Date(2023, 8, 24, 10, 1, 40, 0, time.UTC)
+	testData := []*models.Price{
+		{ID: "test_id_3", Price: decimal.RequireFromString("3.1415926535"), ExpirationDate: expirationDate},
+		{ID: "test_id_1", Price: decimal.RequireFromString("2.71828"), ExpirationDate: expirationDate.AddDate(0, 0, 1)},
+		{ID: "test_id_2", Price: decimal.NewFromInt(1), ExpirationDate: expirationDate.AddDate(0, 0, 2)},
+		{ID: "test_id_1", Price: decimal.NewFromInt(100), ExpirationDate: expirationDate},
+	}
+	r := newTestIndex(t, testData...)
+
+	assert.Equal(t, 3, r.Len())
+	for _, expected := range testData[:3] {
+		price, ok, err := r.Get(expected.ID)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, expected.ID, price.ID)
+		assert.True(t, expected.Price.Equal(price.Price))
+		assert.True(t, expected.ExpirationDate.Equal(price.ExpirationDate))
+	}
+
+	_, ok, err := r.Get("test_id_0")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, err = r.Get("test_id_4")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestIndex_Search(t *testing.T) {
+	r := newTestIndex(t,
+		&models.Price{ID: "b", Price: decimal.NewFromInt(1)},
+		&models.Price{ID: "d", Price: decimal.NewFromInt(2)},
+		&models.Price{ID: "a", Price: decimal.NewFromInt(3)},
+	)
+
+	for id, expected := range map[string]int{"": 0, "b": 1, "c": 2, "e": 3} {
+		i, err := r.Search(id)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, i, id)
+	}
+
+	var ids []string
+	for i := 0; i < r.Len(); i++ {
+		price, err := r.At(i)
+		assert.NoError(t, err)
+		ids = append(ids, price.ID)
+	}
+	assert.Equal(t, []string{"a", "b", "d"}, ids)
+}
+
+func TestIndex_Runs(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir)
+	assert.NoError(t, err)
+	w.runSize = 2
+	for _, price := range []*models.Price{
+		{ID: "e", Price: decimal.NewFromInt(1)},
+		{ID: "c", Price: decimal.NewFromInt(2)},
+		{ID: "a", Price: decimal.NewFromInt(3)},
+		{ID: "e", Price: decimal.NewFromInt(4)},
+		{ID: "b", Price: decimal.NewFromInt(5)},
+		{ID: "a", Price: decimal.NewFromInt(6)},
+		{ID: "d", Price: decimal.NewFromInt(7)},
+	} {
+		assert.NoError(t, w.Add(price))
+	}
+	assert.Len(t, w.runs, 3)
+	path, err := w.Commit()
+	assert.NoError(t, err)
+
+	r, err := Open(path)
+	assert.NoError(t, err)
+	defer r.Close()
+	var ids []string
+	var prices []int64
+	for i := 0; i < r.Len(); i++ {
+		price, err := r.At(i)
+		assert.NoError(t, err)
+		ids = append(ids, price.ID)
+		prices = append(prices, price.Price.IntPart())
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, ids)
+	assert.Equal(t, []int64{3, 5, 2, 7, 1}, prices)
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{path}, paths)
+}
+
+func TestIndex_Empty(t *testing.T) {
+	r := newTestIndex(t)
+	assert.Equal(t, 0, r.Len())
+	_, ok, err := r.Get("test_id_1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestIndex_Files(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir)
+	assert.NoError(t, err)
+	first, err := w.Commit()
+	assert.NoError(t, err)
+
+	w, err = NewWriter(dir)
+	assert.NoError(t, err)
+	second, err := w.Commit()
+	assert.NoError(t, err)
+
+	w, err = NewWriter(dir)
+	assert.NoError(t, err)
+	w.Abort()
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "other.txt"), nil, 0644))
+
+	paths, err := Files(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{first, second}, paths)
+}
+
+func TestIndex_Open_Bad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices_1.idx")
+	assert.NoError(t, os.WriteFile(path, []byte("not an index"), 0644))
+
+	_, err := Open(path)
+	assert.Error(t, err)
+}
+
+func TestIndex_Corrupted(t *testing.T) {
+	testCases := []struct {
+		name    string
+		corrupt func(data []byte)
+	}{
+		{
+			name: "id length",
+			corrupt: func(data []byte) {
+				binary.LittleEndian.PutUint16(data[headerSize:], 0xffff)
+			},
+		},
+		{
+			name: "price length",
+			corrupt: func(data []byte) {
+				binary.LittleEndian.PutUint16(data[headerSize+2+1:], 0xffff)
+			},
+		},
+		{
+			name: "expiration date",
+			corrupt: func(data []byte) {
+				binary.LittleEndian.PutUint16(data[headerSize+2+1:], uint16(len(data)-headerSize-2-1-2-4))
+			},
+		},
+		{
+			name: "offset",
+			corrupt: func(data []byte) {
+				binary.LittleEndian.PutUint64(data[len(data)-8:], uint64(len(data)))
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := NewWriter(dir)
+			assert.NoError(t, err)
+			assert.NoError(t, w.Add(&models.Price{ID: "a", Price: decimal.NewFromInt(1)}))
+			path, err := w.Commit()
+			assert.NoError(t, err)
+			data, err := os.ReadFile(path)
+			assert.NoError(t, err)
+			tc.corrupt(data)
+			assert.NoError(t, os.WriteFile(path, data, 0644))
+
+			r, err := Open(path)
+			assert.NoError(t, err)
+			defer r.Close()
+			_, err = r.At(0)
+			assert.Error(t, err)
+			_, _, err = r.Get("a")
+			assert.Error(t, err)
+		})
+	}
+}
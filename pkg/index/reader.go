@@ -0,0 +1,184 @@
+package index
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"prices/pkg/models"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type (
+	// Reader - reads prices from a memory mapped index file.
+	// Prices are copied out of the mapped memory, so they stay valid after the reader is closed,
+	// but the reader must not be closed while it is being read.
+	Reader struct {
+		path    string
+		data    []byte
+		count   int
+		offsets []byte
+		unmap   func() error
+	}
+)
+
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open index file=%s: %w", path, err)
+	}
+	defer f.Close()
+
+	data, unmap, err := mapFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("can't map index file=%s: %w", path, err)
+	}
+
+	r := &Reader{path: path, data: data, unmap: unmap}
+	if err := r.readHeader(); err != nil {
+		_ = unmap()
+		return nil, fmt.Errorf("bad index file=%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Path - returns path of the index file.
+func (r *Reader) Path() string {
+	return r.path
+}
+
+// Len - returns number of prices in the index.
+func (r *Reader) Len() int {
+	return r.count
+}
+
+// Get - returns price by id, false if there is no such price.
+func (r *Reader) Get(id string) (*models.Price, bool, error) {
+	i, err := r.Search(id)
+	if err != nil {
+		return nil, false, err
+	}
+	if i == r.count {
+		return nil, false, nil
+	}
+	found, err := r.id(i)
+	if err != nil {
+		return nil, false, err
+	}
+	if string(found) != id {
+		return nil, false, nil
+	}
+	price, err := r.At(i)
+	if err != nil {
+		return nil, false, err
+	}
+	return price, true, nil
+}
+
+// Search - returns position of the first price with id greater or equal to the given one, Len if there is none.
+func (r *Reader) Search(id string) (int, error) {
+	var err error
+	i := sort.Search(r.count, func(i int) bool {
+		found, idErr := r.id(i)
+		if idErr != nil {
+			err = idErr
+			return true
+		}
+		return string(found) >= id
+	})
+	if err != nil {
+		return 0, err
+	}
+	return i, nil
+}
+
+// At - returns price at the position in the order of ids.
+func (r *Reader) At(i int) (*models.Price, error) {
+	pos, err := r.offset(i)
+	if err != nil {
+		return nil, err
+	}
+	idData, pos, err := r.field(i, pos)
+	if err != nil {
+		return nil, err
+	}
+	id := string(idData)
+	priceField, pos, err := r.field(i, pos)
+	if err != nil {
+		return nil, err
+	}
+	priceData := string(priceField)
+	if pos+8 > uint64(len(r.data)) {
+		return nil, fmt.Errorf("bad expiration date of price=%d", i)
+	}
+	expirationDate := int64(binary.LittleEndian.Uint64(r.data[pos:]))
+
+	price, err := decimal.NewFromString(priceData)
+	if err != nil {
+		return nil, fmt.Errorf("bad price of id=%s: %w", id, err)
+	}
+	return &models.Price{
+		ID:             id,
+		Price:          price,
+		ExpirationDate: time.Unix(0, expirationDate).UTC(),
+	}, nil
+}
+
+// Close - unmaps the index file.
+func (r *Reader) Close() error {
+	return r.unmap()
+}
+
+func (r *Reader) readHeader() error {
+	if len(r.data) < headerSize || string(r.data[:4]) != magic {
+		return fmt.Errorf("no index header")
+	}
+	if v := binary.LittleEndian.Uint32(r.data[4:]); v != version {
+		return fmt.Errorf("unsupported version=%d", v)
+	}
+	count := binary.LittleEndian.Uint64(r.data[8:])
+	offsetsPos := binary.LittleEndian.Uint64(r.data[16:])
+	if offsetsPos > uint64(len(r.data)) || (uint64(len(r.data))-offsetsPos)/8 != count {
+		return fmt.Errorf("offsets of prices=%d don't match file size=%d", count, len(r.data))
+	}
+	r.count = int(count)
+	r.offsets = r.data[offsetsPos:]
+	return nil
+}
+
+// offset - returns position of the record of the price at the position in the order of ids.
+func (r *Reader) offset(i int) (uint64, error) {
+	if i < 0 || i >= r.count {
+		return 0, fmt.Errorf("no price=%d in index of prices=%d", i, r.count)
+	}
+	pos := binary.LittleEndian.Uint64(r.offsets[i*8:])
+	if pos < headerSize || pos >= uint64(len(r.data)) {
+		return 0, fmt.Errorf("bad offset=%d of price=%d", pos, i)
+	}
+	return pos, nil
+}
+
+// field - returns the length prefixed field of the price record at pos in the mapped memory and the position after it.
+func (r *Reader) field(i int, pos uint64) ([]byte, uint64, error) {
+	if pos+2 > uint64(len(r.data)) {
+		return nil, 0, fmt.Errorf("bad field length of price=%d", i)
+	}
+	fieldLen := uint64(binary.LittleEndian.Uint16(r.data[pos:]))
+	pos += 2
+	if pos+fieldLen > uint64(len(r.data)) {
+		return nil, 0, fmt.Errorf("bad field length=%d of price=%d", fieldLen, i)
+	}
+	return r.data[pos : pos+fieldLen], pos + fieldLen, nil
+}
+
+// id - returns id at the position in the mapped memory, it must not outlive the reader.
+func (r *Reader) id(i int) ([]byte, error) {
+	pos, err := r.offset(i)
+	if err != nil {
+		return nil, err
+	}
+	id, _, err := r.field(i, pos)
+	return id, err
+}
@@ -0,0 +1,327 @@
+package index
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"prices/pkg/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Index file layout, all numbers are little endian:
+//
+//	header:  magic "PIDX" | version uint32 | count uint64 | offsets position uint64
+//	records: id length uint16 | id | price length uint16 | price | expiration date unix nanoseconds int64
+//	offsets: count * uint64 positions of records, ordered by id
+const (
+	magic      = "PIDX"
+	version    = 1
+	headerSize = 24
+
+	// FilePrefix and FileExtension - names of index files, the unix time of the export in between orders them by age.
+	FilePrefix    = "prices_"
+	FileExtension = ".idx"
+
+	// defaultRunSize - how many ids are sorted in memory before they are spilled to a temporary run file.
+	defaultRunSize = 1 << 20
+)
+
+type (
+	// Writer - writes prices to a new index file in the directory.
+	// The file is written under a temporary name and renamed by Commit, so readers never see a partial index.
+	// Ids are sorted by an external merge sort: every runSize ids are sorted in memory and spilled to a temporary run file,
+	// and Commit merges the runs, so the memory used doesn't grow with the number of prices.
+	Writer struct {
+		dir     string
+		file    *os.File
+		buf     *bufio.Writer
+		pos     uint64
+		entries []entry
+		runSize int
+		runs    []*os.File
+	}
+
+	entry struct {
+		id  string
+		pos uint64
+	}
+
+	// run - reads entries of a sorted run, the number of the run orders entries with the same id by the time they were added.
+	run struct {
+		num     int
+		current entry
+		next    func() (entry, bool, error)
+	}
+
+	runHeap []*run
+)
+
+func NewWriter(dir string) (*Writer, error) {
+	file, err := os.CreateTemp(dir, "."+FilePrefix+"*"+FileExtension+".tmp")
+	if err != nil {
+		return nil, fmt.Errorf("can't create index file in directory=%s: %w", dir, err)
+	}
+	w := &Writer{
+		dir:     dir,
+		file:    file,
+		buf:     bufio.NewWriter(file),
+		pos:     headerSize,
+		runSize: defaultRunSize,
+	}
+	if _, err := w.buf.Write(make([]byte, headerSize)); err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("can't write index file=%s: %w", file.Name(), err)
+	}
+	return w, nil
+}
+
+// Add - writes the price to the index, prices can be added in any order.
+func (w *Writer) Add(price *models.Price) error {
+	priceData := price.Price.String()
+	if len(price.ID) > 0xffff || len(priceData) > 0xffff {
+		return fmt.Errorf("price id=%s is too long for the index", price.ID)
+	}
+
+	record := make([]byte, 0, 2+len(price.ID)+2+len(priceData)+8)
+	record = binary.LittleEndian.AppendUint16(record, uint16(len(price.ID)))
+	record = append(record, price.ID...)
+	record = binary.LittleEndian.AppendUint16(record, uint16(len(priceData)))
+	record = append(record, priceData...)
+	record = binary.LittleEndian.AppendUint64(record, uint64(price.ExpirationDate.UnixNano()))
+	if _, err := w.buf.Write(record); err != nil {
+		return fmt.Errorf("can't write index file=%s: %w", w.file.Name(), err)
+	}
+
+	w.entries = append(w.entries, entry{id: price.ID, pos: w.pos})
+	w.pos += uint64(len(record))
+	if len(w.entries) >= w.runSize {
+		return w.spill()
+	}
+	return nil
+}
+
+// Commit - writes offsets of the prices ordered by id and renames the index to its final name.
+// Only the first added price is indexed if ids are duplicated.
+// Returns path of the index file.
+func (w *Writer) Commit() (string, error) {
+	defer w.removeRuns()
+
+	offsetsPos := w.pos
+	count, err := w.writeOffsets()
+	if err != nil {
+		w.Abort()
+		return "", err
+	}
+	if err := w.buf.Flush(); err != nil {
+		w.Abort()
+		return "", fmt.Errorf("can't write index file=%s: %w", w.file.Name(), err)
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = binary.LittleEndian.AppendUint32(header, version)
+	header = binary.LittleEndian.AppendUint64(header, count)
+	header = binary.LittleEndian.AppendUint64(header, offsetsPos)
+	if _, err := w.file.WriteAt(header, 0); err != nil {
+		w.Abort()
+		return "", fmt.Errorf("can't write index file=%s header: %w", w.file.Name(), err)
+	}
+	if err := w.file.Sync(); err != nil {
+		w.Abort()
+		return "", fmt.Errorf("can't sync index file=%s: %w", w.file.Name(), err)
+	}
+	if err := w.file.Close(); err != nil {
+		_ = os.Remove(w.file.Name())
+		return "", fmt.Errorf("can't close index file=%s: %w", w.file.Name(), err)
+	}
+
+	path := filepath.Join(w.dir, fmt.Sprintf("%s%020d%s", FilePrefix, time.Now().UnixNano(), FileExtension))
+	if err := os.Rename(w.file.Name(), path); err != nil {
+		_ = os.Remove(w.file.Name())
+		return "", fmt.Errorf("can't rename index file=%s to=%s: %w", w.file.Name(), path, err)
+	}
+	return path, nil
+}
+
+// Abort - removes the unfinished index file.
+func (w *Writer) Abort() {
+	w.removeRuns()
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
+
+// spill - writes the sorted entries to a new run file: id length uint16 | id | position uint64.
+func (w *Writer) spill() error {
+	sortEntries(w.entries)
+	f, err := os.CreateTemp(w.dir, "."+FilePrefix+"*.run.tmp")
+	if err != nil {
+		return fmt.Errorf("can't create run file of index file=%s: %w", w.file.Name(), err)
+	}
+	w.runs = append(w.runs, f)
+
+	buf := bufio.NewWriter(f)
+	record := make([]byte, 0, 2+0xffff+8)
+	for _, e := range w.entries {
+		record = binary.LittleEndian.AppendUint16(record[:0], uint16(len(e.id)))
+		record = append(record, e.id...)
+		record = binary.LittleEndian.AppendUint64(record, e.pos)
+		if _, err := buf.Write(record); err != nil {
+			return fmt.Errorf("can't write run file=%s: %w", f.Name(), err)
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("can't write run file=%s: %w", f.Name(), err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("can't rewind run file=%s: %w", f.Name(), err)
+	}
+	w.entries = w.entries[:0]
+	return nil
+}
+
+// writeOffsets - merges the run files and the entries left in memory, and writes positions of the records
+// in the order of ids, skipping duplicated ids. Returns the number of written positions.
+func (w *Writer) writeOffsets() (uint64, error) {
+	sortEntries(w.entries)
+	h := make(runHeap, 0, len(w.runs)+1)
+	for i, f := range w.runs {
+		h = append(h, &run{num: i, next: readRun(bufio.NewReader(f))})
+	}
+	h = append(h, &run{num: len(w.runs), next: readEntries(w.entries)})
+
+	runs := h[:0]
+	for _, r := range h {
+		ok, err := r.advance()
+		if err != nil {
+			return 0, fmt.Errorf("can't read run of index file=%s: %w", w.file.Name(), err)
+		}
+		if ok {
+			runs = append(runs, r)
+		}
+	}
+	h = runs
+	heap.Init(&h)
+
+	count := uint64(0)
+	last := ""
+	for h.Len() > 0 {
+		r := h[0]
+		if count == 0 || r.current.id != last {
+			if err := binary.Write(w.buf, binary.LittleEndian, r.current.pos); err != nil {
+				return 0, fmt.Errorf("can't write index file=%s: %w", w.file.Name(), err)
+			}
+			last = r.current.id
+			count++
+		}
+		ok, err := r.advance()
+		if err != nil {
+			return 0, fmt.Errorf("can't read run of index file=%s: %w", w.file.Name(), err)
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return count, nil
+}
+
+func (w *Writer) removeRuns() {
+	for _, f := range w.runs {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+	w.runs = nil
+}
+
+// sortEntries - sorts entries by id, entries with the same id keep the order they were added in.
+func sortEntries(entries []entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].id < entries[j].id
+	})
+}
+
+func readEntries(entries []entry) func() (entry, bool, error) {
+	return func() (entry, bool, error) {
+		if len(entries) == 0 {
+			return entry{}, false, nil
+		}
+		e := entries[0]
+		entries = entries[1:]
+		return e, true, nil
+	}
+}
+
+func readRun(r *bufio.Reader) func() (entry, bool, error) {
+	return func() (entry, bool, error) {
+		var idLen uint16
+		if err := binary.Read(r, binary.LittleEndian, &idLen); err != nil {
+			if errors.Is(err, io.EOF) {
+				return entry{}, false, nil
+			}
+			return entry{}, false, err
+		}
+		id := make([]byte, idLen)
+		if _, err := io.ReadFull(r, id); err != nil {
+			return entry{}, false, err
+		}
+		var pos uint64
+		if err := binary.Read(r, binary.LittleEndian, &pos); err != nil {
+			return entry{}, false, err
+		}
+		return entry{id: string(id), pos: pos}, true, nil
+	}
+}
+
+func (r *run) advance() (bool, error) {
+	e, ok, err := r.next()
+	if err != nil || !ok {
+		return false, err
+	}
+	r.current = e
+	return true, nil
+}
+
+func (h runHeap) Len() int { return len(h) }
+
+func (h runHeap) Less(i, j int) bool {
+	if h[i].current.id != h[j].current.id {
+		return h[i].current.id < h[j].current.id
+	}
+	return h[i].num < h[j].num
+}
+
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *runHeap) Push(x any) { *h = append(*h, x.(*run)) }
+
+func (h *runHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// Files - returns paths of index files in the directory, from the oldest to the newest.
+func Files(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, FilePrefix) && strings.HasSuffix(name, FileExtension) {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	namespace = "prices"
+)
+
+var (
+	PurgedPrices = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "retention",
+		Name:      "purged_prices_total",
+		Help:      "Number of expired prices deleted from the storage.",
+	})
+	ArchivedPrices = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "retention",
+		Name:      "archived_prices_total",
+		Help:      "Number of expired prices written to archive files before deletion.",
+	})
+	PurgeBatchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "retention",
+		Name:      "purge_batch_duration_seconds",
+		Help:      "Duration of listing, archiving and deleting a batch of expired prices.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	DetectedFiles = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "detected_files_total",
+		Help:      "Number of new files found by the scanner, duplicates included.",
+	})
+	SplitFiles = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "split_files_total",
+		Help:      "Number of files split into chunks or byte ranges.",
+	})
+	ImportedFiles = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "imported_files_total",
+		Help:      "Number of files, chunks and byte ranges of split files imported to the storage.",
+	})
+	StorageRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "storage_retries_total",
+		Help:      "Number of imports of data batches and files retried after transient storage errors.",
+	})
+	FailedBatches = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "failed_batches_total",
+		Help:      "Number of data batches that couldn't be saved to the storage.",
+	})
+	FailedFiles = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "failed_files_total",
+		Help:      "Number of files that couldn't be imported to the storage.",
+	})
+	ImportedRows = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "imported_rows_total",
+		Help:      "Number of rows of imported files written to the storage.",
+	})
+	SkippedRows = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "skipped_rows_total",
+		Help:      "Number of rows of imported files skipped by the storage, because they are duplicated or can't be parsed.",
+	})
+	ParsedRows = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "parsed_rows_total",
+		Help:      "Number of rows of files parsed by the application, rows of files imported with LOAD DATA are parsed by the storage.",
+	})
+	InsertedRows = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "inserted_rows_total",
+		Help:      "Number of prices of data batches saved to the storage.",
+	})
+	BatchSaveDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "batch_save_duration_seconds",
+		Help:      "Duration of an attempt to save a data batch to the storage.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	FileImportDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "file_import_duration_seconds",
+		Help:      "Duration of an attempt to import a file or a byte range to the storage with LOAD DATA.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	})
+	RejectedRows = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "rejected_rows_total",
+		Help:      "Number of rows of files rejected by the validation.",
+	})
+	ImportWarnings = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "warnings_total",
+		Help:      "Number of warnings reported by the storage when importing files, by warning level.",
+	}, []string{"level"})
+	ArchivedFiles = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "archived_files_total",
+		Help:      "Number of processed files moved to the processed directory.",
+	})
+	QuarantinedFiles = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "quarantined_files_total",
+		Help:      "Number of failed files moved to the failed directory.",
+	})
+	ReleasedFiles = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "import",
+		Name:      "released_files_total",
+		Help:      "Number of files of expired claims moved back to the files directory.",
+	})
+	SnapshotSwaps = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "snapshots",
+		Name:      "swaps_total",
+		Help:      "Number of snapshots that replaced the prices table.",
+	})
+	FailedSnapshots = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "snapshots",
+		Name:      "failed_total",
+		Help:      "Number of snapshots that failed to import or were rejected by validation.",
+	})
+	SnapshotRows = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "snapshots",
+		Name:      "rows",
+		Help:      "Number of prices in the last swapped snapshot.",
+	})
+)
+
+// RegisterQueue - registers the gauge of the queue length, the length is read on every scrape.
+func RegisterQueue(name string, length func() int) error {
+	return prometheus.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   "import",
+		Name:        "queue_length",
+		Help:        "Number of items waiting in a queue of the FilesApp, by queue.",
+		ConstLabels: prometheus.Labels{"queue": name},
+	}, func() float64 {
+		return float64(length())
+	}))
+}
@@ -4,32 +4,43 @@ import (
 	"embed"
 	"errors"
 	"fmt"
+	"prices/pkg/config"
 
 	_ "github.com/go-sql-driver/mysql" // DB driver
 	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/mysql" // migrate option
+	_ "github.com/golang-migrate/migrate/v4/database/mysql"  // migrate option
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite" // migrate option
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 )
 
-//go:embed sql/*sql
+//go:embed sql/*/*sql
 var migrationsFS embed.FS
 
 const (
-	dbURLPrefix = "mysql://"
+	mysqlDBURLPrefix  = "mysql://"
+	sqliteDBURLPrefix = "sqlite://"
 )
 
-func MigrateDB(dbURL string) error {
-	migrationSource, err := iofs.New(migrationsFS, "sql")
-	if err != nil {
-		return fmt.Errorf("failed to create migration source: %w", err)
+// MigrateDB applies all migrations for the storage dialect, to every shard of the storage if it is sharded.
+func MigrateDB(storage config.Storage) error {
+	if storage.Type == config.StorageIndex {
+		// Index files are written with their own layout by the export.
+		return nil
+	}
+	if len(storage.Shards) > 0 {
+		for _, shard := range storage.ShardStorages() {
+			if err := MigrateDB(shard); err != nil {
+				return fmt.Errorf("can't migrate shard=%s: %w", shard.Name, err)
+			}
+		}
+		return nil
 	}
-	defer migrationSource.Close()
 
-	migration, err := migrate.NewWithSourceInstance("iofs", migrationSource, dbURLPrefix+dbURL)
+	migration, closeMigration, err := newMigration(storage)
 	if err != nil {
 		return err
 	}
-	defer migration.Close()
+	defer closeMigration()
 
 	err = migration.Up()
 	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
@@ -42,18 +53,12 @@ func MigrateDB(dbURL string) error {
 // Migrate looks at the currently active migration version,
 // then migrates either up or down to the specified version.
 // Useful for testing migrations
-func Migrate(dbURL string, version uint) error {
-	migrationSource, err := iofs.New(migrationsFS, "sql")
-	if err != nil {
-		return fmt.Errorf("failed to create migration source: %w", err)
-	}
-	defer migrationSource.Close()
-
-	migration, err := migrate.NewWithSourceInstance("iofs", migrationSource, dbURLPrefix+dbURL)
+func Migrate(storage config.Storage, version uint) error {
+	migration, closeMigration, err := newMigration(storage)
 	if err != nil {
 		return err
 	}
-	defer migration.Close()
+	defer closeMigration()
 
 	err = migration.Migrate(version)
 	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
@@ -62,3 +67,36 @@ func Migrate(dbURL string, version uint) error {
 
 	return nil
 }
+
+func newMigration(storage config.Storage) (*migrate.Migrate, func(), error) {
+	dir, dbURLPrefix, err := dialect(storage.Type)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	migrationSource, err := iofs.New(migrationsFS, dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create migration source: %w", err)
+	}
+
+	migration, err := migrate.NewWithSourceInstance("iofs", migrationSource, dbURLPrefix+storage.DSN)
+	if err != nil {
+		_ = migrationSource.Close()
+		return nil, nil, err
+	}
+
+	return migration, func() {
+		_, _ = migration.Close()
+	}, nil
+}
+
+func dialect(storageType string) (string, string, error) {
+	switch storageType {
+	case config.StorageMySQL:
+		return "sql/mysql", mysqlDBURLPrefix, nil
+	case config.StorageSQLite:
+		return "sql/sqlite", sqliteDBURLPrefix, nil
+	default:
+		return "", "", fmt.Errorf("no migrations for storage=%s", storageType)
+	}
+}
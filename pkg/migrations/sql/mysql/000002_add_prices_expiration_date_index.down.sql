@@ -0,0 +1 @@
+DROP INDEX prices_expiration_date_idx ON prices;
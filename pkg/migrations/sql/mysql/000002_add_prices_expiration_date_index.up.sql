@@ -0,0 +1 @@
+CREATE INDEX prices_expiration_date_idx ON prices (expiration_date);
@@ -0,0 +1,6 @@
+ALTER TABLE prices REMOVE PARTITIONING;
+
+ALTER TABLE prices
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (id),
+    MODIFY expiration_date DATETIME NULL;
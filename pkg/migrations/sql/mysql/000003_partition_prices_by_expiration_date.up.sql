@@ -0,0 +1,12 @@
+-- Prices without expiration date never expire, they are moved to the last partition.
+UPDATE prices SET expiration_date = '9999-12-31 23:59:59' WHERE expiration_date IS NULL;
+
+ALTER TABLE prices
+    MODIFY expiration_date DATETIME NOT NULL,
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (id, expiration_date);
+
+ALTER TABLE prices
+    PARTITION BY RANGE COLUMNS (expiration_date) (
+        PARTITION p_future VALUES LESS THAN (MAXVALUE)
+    );
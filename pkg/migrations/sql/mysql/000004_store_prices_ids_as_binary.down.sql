@@ -0,0 +1,11 @@
+ALTER TABLE prices
+    ADD COLUMN string_id VARCHAR(255) NULL;
+
+UPDATE prices SET string_id = COALESCE(raw_id, BIN_TO_UUID(id));
+
+ALTER TABLE prices
+    DROP PRIMARY KEY,
+    DROP COLUMN id,
+    DROP COLUMN raw_id,
+    CHANGE COLUMN string_id id VARCHAR(255) NOT NULL FIRST,
+    ADD PRIMARY KEY (id, expiration_date);
@@ -0,0 +1,23 @@
+-- Ids in the canonical UUID form are stored as 16 bytes of the UUID,
+-- other ids are stored by their MD5 hash and kept as is in raw_id.
+ALTER TABLE prices
+    ADD COLUMN key_id BINARY(16) NULL,
+    ADD COLUMN raw_id VARCHAR(255) NULL;
+
+UPDATE prices SET
+    key_id = IF(
+        REGEXP_LIKE(id, '^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$', 'c'),
+        UUID_TO_BIN(id),
+        UNHEX(MD5(id))
+    ),
+    raw_id = IF(
+        REGEXP_LIKE(id, '^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$', 'c'),
+        NULL,
+        id
+    );
+
+ALTER TABLE prices
+    DROP PRIMARY KEY,
+    DROP COLUMN id,
+    CHANGE COLUMN key_id id BINARY(16) NOT NULL FIRST,
+    ADD PRIMARY KEY (id, expiration_date);
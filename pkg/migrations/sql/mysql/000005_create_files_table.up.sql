@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS files (
+    path VARCHAR(768) NOT NULL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    checksum CHAR(64) NOT NULL,
+    size BIGINT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX files_checksum_size_idx (checksum, size)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE files
+    DROP INDEX files_status_idx,
+    DROP COLUMN checkpoint;
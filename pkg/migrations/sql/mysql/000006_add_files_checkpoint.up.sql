@@ -0,0 +1,4 @@
+-- Rows of files read by lines that are saved to the storage, so their import is resumed after a restart.
+ALTER TABLE files
+    ADD COLUMN checkpoint BIGINT NOT NULL DEFAULT 0,
+    ADD INDEX files_status_idx (status);
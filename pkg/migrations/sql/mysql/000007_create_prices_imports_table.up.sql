@@ -0,0 +1,10 @@
+-- Prices of files imported atomically, they are moved to the prices table once all of them are staged.
+CREATE TABLE IF NOT EXISTS prices_imports (
+    import_id CHAR(64) NOT NULL,
+    id BINARY(16) NOT NULL,
+    raw_id VARCHAR(255) NULL,
+    price DECIMAL(20, 10),
+    expiration_date DATETIME NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (import_id, id, expiration_date)
+);
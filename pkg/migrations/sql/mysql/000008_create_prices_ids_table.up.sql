@@ -0,0 +1,16 @@
+-- Expiration date of every id of the prices table, it keeps ids unique, because the primary key of the partitioned
+-- prices table includes the expiration date, and it tells the partition to read the price of an id from.
+CREATE TABLE IF NOT EXISTS prices_ids (
+    id BINARY(16) NOT NULL PRIMARY KEY,
+    expiration_date DATETIME NOT NULL,
+    INDEX prices_ids_expiration_date_idx (expiration_date)
+);
+
+-- Of the prices stored with the same id the one that expires first is kept.
+INSERT INTO prices_ids (id, expiration_date)
+SELECT id, MIN(expiration_date) FROM prices
+GROUP BY id;
+
+DELETE p FROM prices p
+JOIN prices_ids i ON i.id = p.id
+WHERE p.expiration_date <> i.expiration_date;
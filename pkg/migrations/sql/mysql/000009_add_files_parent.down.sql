@@ -0,0 +1,3 @@
+ALTER TABLE files
+    DROP INDEX files_parent_idx,
+    DROP COLUMN parent;
@@ -0,0 +1,4 @@
+-- Split files of chunks, so chunks of split files are tracked again after a restart.
+ALTER TABLE files
+    ADD COLUMN parent VARCHAR(768) NOT NULL DEFAULT '',
+    ADD INDEX files_parent_idx (parent);
@@ -0,0 +1,2 @@
+-- Atomic imports of files, chunks and byte ranges of split files are parts of the import of the split file.
+ALTER TABLE files ADD COLUMN import_id CHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS prices (
+    id TEXT PRIMARY KEY,
+    price TEXT,
+    expiration_date DATETIME
+);
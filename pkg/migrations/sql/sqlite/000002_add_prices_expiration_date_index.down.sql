@@ -0,0 +1 @@
+DROP INDEX IF EXISTS prices_expiration_date_idx;
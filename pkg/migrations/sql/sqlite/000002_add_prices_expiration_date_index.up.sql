@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS prices_expiration_date_idx ON prices (expiration_date);
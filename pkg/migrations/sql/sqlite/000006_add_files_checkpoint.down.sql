@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS files_status_idx;
+ALTER TABLE files DROP COLUMN checkpoint;
@@ -0,0 +1,3 @@
+-- Rows of files read by lines that are saved to the storage, so their import is resumed after a restart.
+ALTER TABLE files ADD COLUMN checkpoint INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS files_status_idx ON files (status);
@@ -0,0 +1,9 @@
+-- Prices of files imported atomically, they are moved to the prices table once all of them are staged.
+CREATE TABLE IF NOT EXISTS prices_imports (
+    import_id TEXT NOT NULL,
+    id TEXT NOT NULL,
+    price TEXT,
+    expiration_date DATETIME,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (import_id, id)
+);
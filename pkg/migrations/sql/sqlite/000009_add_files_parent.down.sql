@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS files_parent_idx;
+ALTER TABLE files DROP COLUMN parent;
@@ -0,0 +1,3 @@
+-- Split files of chunks, so chunks of split files are tracked again after a restart.
+ALTER TABLE files ADD COLUMN parent TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS files_parent_idx ON files (parent);
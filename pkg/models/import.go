@@ -0,0 +1,32 @@
+package models
+
+type (
+	// ImportResult - outcome of importing a file to the storage.
+	ImportResult struct {
+		// Records - number of rows read from the file.
+		Records int64
+		// RowsAffected - number of prices written to the storage.
+		RowsAffected int64
+		// RowsSkipped - number of rows that weren't written, because their ids are duplicated or they can't be parsed.
+		RowsSkipped int64
+		// Warnings - warnings reported by the storage, e.g. truncated values or skipped rows.
+		Warnings []ImportWarning
+	}
+
+	ImportWarning struct {
+		Level   string
+		Code    int
+		Message string
+	}
+)
+
+// Add - adds numbers and warnings of the other result to the result.
+func (r *ImportResult) Add(other *ImportResult) {
+	if other == nil {
+		return
+	}
+	r.Records += other.Records
+	r.RowsAffected += other.RowsAffected
+	r.RowsSkipped += other.RowsSkipped
+	r.Warnings = append(r.Warnings, other.Warnings...)
+}
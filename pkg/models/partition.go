@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+type (
+	// Partition - range partition of the prices table by expiration date.
+	Partition struct {
+		Name string
+		// LessThan - partition holds prices that expire before this time, zero for the partition without upper bound.
+		LessThan time.Time
+	}
+)
@@ -0,0 +1,186 @@
+//go:generate mockgen -source manager.go -destination repository_mock.go -package partitions PricesRepo
+
+package partitions
+
+import (
+	"context"
+	"fmt"
+	"prices/pkg/config"
+	"prices/pkg/models"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	partitionNameLayout = "p200601"
+)
+
+type (
+	PricesRepo interface {
+		ListPartitions(ctx context.Context) ([]models.Partition, error)
+		AddPartitions(ctx context.Context, partitions []models.Partition) error
+		DropPartitions(ctx context.Context, names []string) error
+		MergePartitions(ctx context.Context, partitions []models.Partition) error
+	}
+
+	// changes - partitions the manager has to add, drop and merge.
+	changes struct {
+		add  []models.Partition
+		drop []string
+		// merge - the oldest monthly partitions, they are merged into the last of them.
+		merge []models.Partition
+	}
+
+	// Manager - keeps the prices table partitioned by expiration month.
+	// It creates partitions for the upcoming months in advance, drops partitions
+	// that only hold prices expired for longer than the retention period,
+	// and merges the oldest partitions, so lookups by id don't probe more than MaxPartitions partitions.
+	Manager struct {
+		ctx       context.Context
+		wg        *sync.WaitGroup
+		config    *config.Partitions
+		retention time.Duration
+		repo      PricesRepo
+		logger    *zap.Logger
+		stop      <-chan bool
+	}
+)
+
+func NewManager(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	config *config.Partitions,
+	retention time.Duration,
+	repo PricesRepo,
+	logger *zap.Logger,
+	stop <-chan bool,
+) *Manager {
+	log := logger.Named("PartitionManager")
+	m := &Manager{
+		ctx:       ctx,
+		wg:        wg,
+		config:    config,
+		retention: retention,
+		repo:      repo,
+		logger:    log,
+		stop:      stop,
+	}
+	return m
+}
+
+// Run - manages partitions every CheckEveryDuration until stopped.
+func (m *Manager) Run() {
+	m.logger.Sugar().Infof("start managing partitions")
+	m.wg.Add(1)
+	ticker := time.NewTicker(m.config.CheckEveryDuration)
+	defer ticker.Stop()
+	m.manage()
+	for {
+		select {
+		case <-ticker.C:
+			m.manage()
+		case <-m.stop:
+			m.logger.Sugar().Infof("stop managing partitions")
+			m.wg.Done()
+			return
+		}
+	}
+}
+
+// Manage - adds missing partitions up to FutureMonths from now, drops expired partitions
+// and merges the oldest partitions if there are more than MaxPartitions.
+func (m *Manager) Manage() error {
+	existing, err := m.repo.ListPartitions(m.ctx)
+	if err != nil {
+		return err
+	}
+
+	planned, err := m.plan(existing, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	if len(planned.add) > 0 {
+		m.logger.Sugar().Infof("add partitions from=%s to=%s", planned.add[0].Name, planned.add[len(planned.add)-1].Name)
+		if err := m.repo.AddPartitions(m.ctx, planned.add); err != nil {
+			return err
+		}
+	}
+	if len(planned.drop) > 0 {
+		m.logger.Sugar().Infof("drop expired partitions=%v", planned.drop)
+		if err := m.repo.DropPartitions(m.ctx, planned.drop); err != nil {
+			return err
+		}
+	}
+	if len(planned.merge) > 0 {
+		m.logger.Sugar().Infof("merge partitions from=%s to=%s", planned.merge[0].Name, planned.merge[len(planned.merge)-1].Name)
+		if err := m.repo.MergePartitions(m.ctx, planned.merge); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) manage() {
+	if err := m.Manage(); err != nil {
+		m.logger.Sugar().Errorf("can't manage partitions: (%s)", err.Error())
+	}
+}
+
+// plan - returns monthly partitions that have to be added, names of partitions that have to be dropped,
+// and the oldest partitions that have to be merged to keep at most MaxPartitions monthly partitions.
+// The first monthly partition also holds all prices that expire before its month.
+func (m *Manager) plan(existing []models.Partition, now time.Time) (changes, error) {
+	if len(existing) == 0 {
+		return changes{}, fmt.Errorf("prices table is not partitioned")
+	}
+	if !existing[len(existing)-1].LessThan.IsZero() {
+		return changes{}, fmt.Errorf("prices table has no partition without upper bound")
+	}
+	monthly := existing[:len(existing)-1]
+
+	expiredBefore := now.Add(-m.retention)
+	next := monthStart(expiredBefore)
+	if len(monthly) > 0 {
+		next = monthStart(monthly[len(monthly)-1].LessThan)
+	}
+	last := monthStart(now).AddDate(0, m.config.FutureMonths, 0)
+
+	var add []models.Partition
+	for month := next; !month.After(last); month = month.AddDate(0, 1, 0) {
+		add = append(add, models.Partition{
+			Name:     month.Format(partitionNameLayout),
+			LessThan: month.AddDate(0, 1, 0),
+		})
+	}
+
+	var drop []string
+	kept := monthly
+	if m.config.DropExpired && m.retention > 0 {
+		for _, partition := range monthly {
+			if !partition.LessThan.After(expiredBefore) {
+				drop = append(drop, partition.Name)
+			}
+		}
+		kept = monthly[len(drop):]
+	}
+
+	// Only existing partitions are merged, partitions that are added are for the upcoming months.
+	var merge []models.Partition
+	if excess := len(kept) + len(add) - m.config.MaxPartitions; m.config.MaxPartitions > 0 && excess > 0 {
+		merge = kept[:min(excess+1, len(kept))]
+		if len(merge) < 2 {
+			merge = nil
+		}
+	}
+
+	return changes{add: add, drop: drop, merge: merge}, nil
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
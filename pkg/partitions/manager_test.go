@@ -0,0 +1,152 @@
+package partitions
+
+import (
+	"context"
+	"fmt"
+	"prices/pkg/config"
+	"prices/pkg/models"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newTestManager(t *testing.T, dropExpired bool) (*Manager, *MockPricesRepo) {
+	cfg := &config.Partitions{
+		FutureMonths: 2,
+		DropExpired:  dropExpired,
+	}
+
+	ctrl := gomock.NewController(t)
+
+	repo := NewMockPricesRepo(ctrl)
+
+	manager := NewManager(context.Background(), &sync.WaitGroup{}, cfg, 60*24*time.Hour, repo, zap.NewNop(), make(chan bool))
+
+	return manager, repo
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestManager_Plan_NotPartitioned(t *testing.T) {
+	manager, _ := newTestManager(t, false)
+
+	_, err := manager.plan(nil, date(2023, 8, 24))
+	assert.Error(t, err)
+
+	_, err = manager.plan([]models.Partition{{Name: "p202308", LessThan: date(2023, 9, 1)}}, date(2023, 8, 24))
+	assert.Error(t, err)
+}
+
+func TestManager_Plan_Initial(t *testing.T) {
+	manager, _ := newTestManager(t, true)
+
+	planned, err := manager.plan([]models.Partition{{Name: "p_future"}}, date(2023, 8, 24))
+	assert.NoError(t, err)
+	assert.Empty(t, planned.drop)
+	assert.Empty(t, planned.merge)
+	assert.Equal(t, []models.Partition{
+		{Name: "p202306", LessThan: date(2023, 7, 1)},
+		{Name: "p202307", LessThan: date(2023, 8, 1)},
+		{Name: "p202308", LessThan: date(2023, 9, 1)},
+		{Name: "p202309", LessThan: date(2023, 10, 1)},
+		{Name: "p202310", LessThan: date(2023, 11, 1)},
+	}, planned.add)
+}
+
+func TestManager_Plan_Existing(t *testing.T) {
+	existing := []models.Partition{
+		{Name: "p202305", LessThan: date(2023, 6, 1)},
+		{Name: "p202306", LessThan: date(2023, 7, 1)},
+		{Name: "p202307", LessThan: date(2023, 8, 1)},
+		{Name: "p202308", LessThan: date(2023, 9, 1)},
+		{Name: "p202309", LessThan: date(2023, 10, 1)},
+		{Name: "p_future"},
+	}
+
+	manager, _ := newTestManager(t, true)
+	planned, err := manager.plan(existing, date(2023, 8, 24))
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Partition{{Name: "p202310", LessThan: date(2023, 11, 1)}}, planned.add)
+	assert.Equal(t, []string{"p202305"}, planned.drop)
+
+	manager, _ = newTestManager(t, false)
+	planned, err = manager.plan(existing, date(2023, 8, 24))
+	assert.NoError(t, err)
+	assert.Len(t, planned.add, 1)
+	assert.Empty(t, planned.drop)
+}
+
+func TestManager_Plan_MaxPartitions(t *testing.T) {
+	existing := []models.Partition{
+		{Name: "p202305", LessThan: date(2023, 6, 1)},
+		{Name: "p202306", LessThan: date(2023, 7, 1)},
+		{Name: "p202307", LessThan: date(2023, 8, 1)},
+		{Name: "p202308", LessThan: date(2023, 9, 1)},
+		{Name: "p202309", LessThan: date(2023, 10, 1)},
+		{Name: "p_future"},
+	}
+
+	// 5 existing and 1 added partitions are kept as 4, so the 3 oldest are merged into one.
+	manager, _ := newTestManager(t, false)
+	manager.config.MaxPartitions = 4
+	planned, err := manager.plan(existing, date(2023, 8, 24))
+	assert.NoError(t, err)
+	assert.Len(t, planned.add, 1)
+	assert.Equal(t, existing[:3], planned.merge)
+
+	// Dropped partitions are not merged.
+	manager, _ = newTestManager(t, true)
+	manager.config.MaxPartitions = 4
+	planned, err = manager.plan(existing, date(2023, 8, 24))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"p202305"}, planned.drop)
+	assert.Equal(t, existing[1:3], planned.merge)
+
+	manager, _ = newTestManager(t, false)
+	manager.config.MaxPartitions = 6
+	planned, err = manager.plan(existing, date(2023, 8, 24))
+	assert.NoError(t, err)
+	assert.Empty(t, planned.merge)
+}
+
+func TestManager_Manage(t *testing.T) {
+	manager, repo := newTestManager(t, true)
+
+	now := time.Now().UTC()
+	thisMonth := monthStart(now)
+	existing := []models.Partition{
+		{Name: "p_old", LessThan: thisMonth.AddDate(0, -6, 0)},
+		{Name: "p_previous", LessThan: thisMonth},
+		{Name: "p_current", LessThan: thisMonth.AddDate(0, 1, 0)},
+		{Name: "p_future"},
+	}
+	manager.config.MaxPartitions = 3
+
+	gomock.InOrder(
+		repo.EXPECT().ListPartitions(gomock.Any()).Return(existing, nil),
+		repo.EXPECT().AddPartitions(gomock.Any(), []models.Partition{
+			{Name: thisMonth.AddDate(0, 1, 0).Format(partitionNameLayout), LessThan: thisMonth.AddDate(0, 2, 0)},
+			{Name: thisMonth.AddDate(0, 2, 0).Format(partitionNameLayout), LessThan: thisMonth.AddDate(0, 3, 0)},
+		}).Return(nil),
+		repo.EXPECT().DropPartitions(gomock.Any(), []string{"p_old"}).Return(nil),
+		repo.EXPECT().MergePartitions(gomock.Any(), existing[1:3]).Return(nil),
+	)
+
+	err := manager.Manage()
+	assert.NoError(t, err)
+}
+
+func TestManager_Manage_Error(t *testing.T) {
+	manager, repo := newTestManager(t, true)
+
+	repo.EXPECT().ListPartitions(gomock.Any()).Return(nil, fmt.Errorf("test error"))
+
+	err := manager.Manage()
+	assert.Error(t, err)
+}
@@ -0,0 +1,93 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: manager.go
+
+// Package partitions is a generated GoMock package.
+package partitions
+
+import (
+	context "context"
+	models "prices/pkg/models"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockPricesRepo is a mock of PricesRepo interface.
+type MockPricesRepo struct {
+	ctrl     *gomock.Controller
+	recorder *MockPricesRepoMockRecorder
+}
+
+// MockPricesRepoMockRecorder is the mock recorder for MockPricesRepo.
+type MockPricesRepoMockRecorder struct {
+	mock *MockPricesRepo
+}
+
+// NewMockPricesRepo creates a new mock instance.
+func NewMockPricesRepo(ctrl *gomock.Controller) *MockPricesRepo {
+	mock := &MockPricesRepo{ctrl: ctrl}
+	mock.recorder = &MockPricesRepoMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPricesRepo) EXPECT() *MockPricesRepoMockRecorder {
+	return m.recorder
+}
+
+// AddPartitions mocks base method.
+func (m *MockPricesRepo) AddPartitions(ctx context.Context, partitions []models.Partition) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddPartitions", ctx, partitions)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddPartitions indicates an expected call of AddPartitions.
+func (mr *MockPricesRepoMockRecorder) AddPartitions(ctx, partitions interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddPartitions", reflect.TypeOf((*MockPricesRepo)(nil).AddPartitions), ctx, partitions)
+}
+
+// DropPartitions mocks base method.
+func (m *MockPricesRepo) DropPartitions(ctx context.Context, names []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DropPartitions", ctx, names)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DropPartitions indicates an expected call of DropPartitions.
+func (mr *MockPricesRepoMockRecorder) DropPartitions(ctx, names interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DropPartitions", reflect.TypeOf((*MockPricesRepo)(nil).DropPartitions), ctx, names)
+}
+
+// ListPartitions mocks base method.
+func (m *MockPricesRepo) ListPartitions(ctx context.Context) ([]models.Partition, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListPartitions", ctx)
+	ret0, _ := ret[0].([]models.Partition)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPartitions indicates an expected call of ListPartitions.
+func (mr *MockPricesRepoMockRecorder) ListPartitions(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPartitions", reflect.TypeOf((*MockPricesRepo)(nil).ListPartitions), ctx)
+}
+
+// MergePartitions mocks base method.
+func (m *MockPricesRepo) MergePartitions(ctx context.Context, partitions []models.Partition) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MergePartitions", ctx, partitions)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MergePartitions indicates an expected call of MergePartitions.
+func (mr *MockPricesRepoMockRecorder) MergePartitions(ctx, partitions interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MergePartitions", reflect.TypeOf((*MockPricesRepo)(nil).MergePartitions), ctx, partitions)
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/md5"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+type (
+	// idOrdered - storage that lists prices in its own order of ids instead of the order of id strings.
+	idOrdered interface {
+		lessID(a, b string) bool
+	}
+)
+
+// priceKey - converts price id to the BINARY(16) key it is stored by in MySQL.
+// Ids in the canonical UUID form are stored as the 16 bytes of the UUID.
+// Other ids are stored by the MD5 hash of the id, and the id itself is stored in the raw_id column.
+func priceKey(id string) ([]byte, sql.NullString) {
+	if parsed, err := uuid.Parse(id); err == nil && parsed.String() == id {
+		return parsed[:], sql.NullString{}
+	}
+	sum := md5.Sum([]byte(id))
+	return sum[:], sql.NullString{String: id, Valid: true}
+}
+
+// priceID - converts the stored key and raw id back to the price id.
+func priceID(key []byte, raw sql.NullString) (string, error) {
+	if raw.Valid {
+		return raw.String, nil
+	}
+	parsed, err := uuid.FromBytes(key)
+	if err != nil {
+		return "", err
+	}
+	return parsed.String(), nil
+}
+
+// priceKeys - converts price ids to keys, as []any so they can be expanded by the query builder.
+func priceKeys(ids []string) []any {
+	keys := make([]any, len(ids))
+	for i, id := range ids {
+		keys[i], _ = priceKey(id)
+	}
+	return keys
+}
+
+func lessKey(a, b string) bool {
+	keyA, _ := priceKey(a)
+	keyB, _ := priceKey(b)
+	return bytes.Compare(keyA, keyB) < 0
+}
+
+// lessID - returns function that compares ids in the order prices are listed by the storage.
+func lessID(repo Prices) func(a, b string) bool {
+	if ordered, ok := repo.(idOrdered); ok {
+		return ordered.lessID
+	}
+	return func(a, b string) bool {
+		return a < b
+	}
+}
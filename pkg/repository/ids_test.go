@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriceKey(t *testing.T) {
+	testCases := []struct {
+		id  string
+		raw bool
+	}{
+		{id: "d65d3cba-40c7-11ee-afc6-a45e60d0762b", raw: false},
+		{id: "D65D3CBA-40C7-11EE-AFC6-A45E60D0762B", raw: true},
+		{id: "{d65d3cba-40c7-11ee-afc6-a45e60d0762b}", raw: true},
+		{id: "test_id_1", raw: true},
+	}
+	for _, tc := range testCases {
+		key, raw := priceKey(tc.id)
+		assert.Len(t, key, 16, tc.id)
+		assert.Equal(t, tc.raw, raw.Valid, tc.id)
+		id, err := priceID(key, raw)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.id, id)
+	}
+}
+
+func TestLessID(t *testing.T) {
+	repo := &MySQLPrices{}
+	ids := []string{"test_id_1", "test_id_2", "d65d3cba-40c7-11ee-afc6-a45e60d0762b"}
+	for _, a := range ids {
+		for _, b := range ids {
+			assert.Equal(t, string(testKey(a)) < string(testKey(b)), lessID(repo)(a, b))
+		}
+	}
+	assert.True(t, lessID(&SQLitePrices{})("a", "b"))
+}
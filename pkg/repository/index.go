@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"prices/pkg/config"
+	"prices/pkg/errors"
+	"prices/pkg/index"
+	"prices/pkg/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReloadCheckEvery = 10 * time.Second
+)
+
+type (
+	// IndexPrices - read only prices served from the newest index file in the directory set as DSN,
+	// for deployments that serve lookups without a database.
+	// The directory is checked every ReloadCheckEveryDuration, and a newer index file replaces the current one
+	// without interrupting reads.
+	IndexPrices struct {
+		dir        string
+		checkEvery time.Duration
+		mu         sync.RWMutex
+		reader     *index.Reader
+		stop       chan struct{}
+		stopOnce   sync.Once
+	}
+)
+
+func NewIndexPrices(config config.Storage) (*IndexPrices, error) {
+	r := &IndexPrices{
+		dir:        config.DSN,
+		checkEvery: config.ReloadCheckEveryDuration,
+		stop:       make(chan struct{}),
+	}
+	if r.checkEvery <= 0 {
+		r.checkEvery = defaultReloadCheckEvery
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	go r.run()
+	return r, nil
+}
+
+func (r *IndexPrices) CreateMany(ctx context.Context, prices []*models.Price) error {
+	return errors.ErrReadOnly
+}
+
+func (r *IndexPrices) ImportFile(ctx context.Context, filePath string) (*models.ImportResult, error) {
+	return nil, errors.ErrReadOnly
+}
+
+func (r *IndexPrices) ImportRange(ctx context.Context, filePath string, offset int64, length int64) (*models.ImportResult, error) {
+	return nil, errors.ErrReadOnly
+}
+
+func (r *IndexPrices) DeleteMany(ctx context.Context, ids []string) error {
+	return errors.ErrReadOnly
+}
+
+func (r *IndexPrices) Get(ctx context.Context, id string) (*models.Price, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	price, ok, err := r.reader.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("can't get price from index=%s: %w", r.reader.Path(), err)
+	}
+	if !ok {
+		return nil, errors.ErrPriceNotFound
+	}
+	return price, nil
+}
+
+// GetMany - gets prices by ids, ids that are not found are skipped.
+func (r *IndexPrices) GetMany(ctx context.Context, ids []string) ([]*models.Price, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var prices []*models.Price
+	for _, id := range ids {
+		price, ok, err := r.reader.Get(id)
+		if err != nil {
+			return nil, fmt.Errorf("can't get prices from index=%s: %w", r.reader.Path(), err)
+		}
+		if ok {
+			prices = append(prices, price)
+		}
+	}
+	return prices, nil
+}
+
+// List - lists up to limit prices ordered by id, starting after the given id.
+func (r *IndexPrices) List(ctx context.Context, after string, limit int) ([]*models.Price, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	start, err := r.reader.Search(after)
+	if err != nil {
+		return nil, fmt.Errorf("can't list prices from index=%s: %w", r.reader.Path(), err)
+	}
+	var prices []*models.Price
+	for i := start; i < r.reader.Len() && len(prices) < limit; i++ {
+		price, err := r.reader.At(i)
+		if err != nil {
+			return nil, fmt.Errorf("can't list prices from index=%s: %w", r.reader.Path(), err)
+		}
+		if price.ID == after {
+			continue
+		}
+		prices = append(prices, price)
+	}
+	return prices, nil
+}
+
+// ListExpired - lists up to limit prices that expired before the given time, the longest expired first.
+// The index is ordered by id only, so it reads all prices.
+func (r *IndexPrices) ListExpired(ctx context.Context, before time.Time, limit int) ([]*models.Price, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var prices []*models.Price
+	for i := 0; i < r.reader.Len(); i++ {
+		price, err := r.reader.At(i)
+		if err != nil {
+			return nil, fmt.Errorf("can't list expired prices from index=%s: %w", r.reader.Path(), err)
+		}
+		if price.ExpirationDate.Before(before) {
+			prices = append(prices, price)
+		}
+	}
+
+	sort.Slice(prices, func(i, j int) bool {
+		return prices[i].ExpirationDate.Before(prices[j].ExpirationDate)
+	})
+	if len(prices) > limit {
+		prices = prices[:limit]
+	}
+	return prices, nil
+}
+
+// Reload - replaces the current index with the newest index file in the directory, if it is a different file.
+// Reads in progress finish on the current index, it is closed once they are done.
+func (r *IndexPrices) Reload() error {
+	paths, err := index.Files(r.dir)
+	if err != nil {
+		return fmt.Errorf("can't list index files in directory=%s: %w", r.dir, err)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no index files in directory=%s", r.dir)
+	}
+	newest := paths[len(paths)-1]
+
+	r.mu.RLock()
+	current := r.reader
+	r.mu.RUnlock()
+	if current != nil && current.Path() == newest {
+		return nil
+	}
+
+	reader, err := index.Open(newest)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.reader = reader
+	r.mu.Unlock()
+
+	if current != nil {
+		_ = current.Close()
+	}
+	return nil
+}
+
+// Close - stops reloading and closes the current index.
+func (r *IndexPrices) Close() error {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.reader == nil {
+		return nil
+	}
+	err := r.reader.Close()
+	r.reader = nil
+	return err
+}
+
+func (r *IndexPrices) run() {
+	ticker := time.NewTicker(r.checkEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// A broken newer index is retried on the next check, the current one keeps being served.
+			_ = r.Reload()
+		case <-r.stop:
+			return
+		}
+	}
+}
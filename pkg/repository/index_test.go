@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"prices/pkg/config"
+	"prices/pkg/errors"
+	"prices/pkg/index"
+	"prices/pkg/models"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestIndex(t *testing.T, dir string, prices ...*models.Price) string {
+	w, err := index.NewWriter(dir)
+	assert.NoError(t, err)
+	for _, price := range prices {
+		assert.NoError(t, w.Add(price))
+	}
+	path, err := w.Commit()
+	assert.NoError(t, err)
+	return path
+}
+
+func newTestIndexPrices(t *testing.T, dir string) *IndexPrices {
+	repo, err := NewIndexPrices(config.Storage{Type: config.StorageIndex, DSN: dir, ReloadCheckEveryDuration: time.Hour})
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = repo.Close()
+	})
+	return repo
+}
+
+func TestIndexPrices_Read(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC().Truncate(time.Second)
+	testData := []*models.Price{
+		{ID: "test_id_3", Price: decimal.RequireFromString("3.1415926535"), ExpirationDate: now.AddDate(0, 0, -1)},
+		{ID: "test_id_1", Price: decimal.RequireFromString("2.71828"), ExpirationDate: now.AddDate(0, 0, -2)},
+		{ID: "test_id_2", Price: decimal.NewFromInt(1), ExpirationDate: now.AddDate(0, 0, 1)},
+	}
+	writeTestIndex(t, dir, testData...)
+	repo := newTestIndexPrices(t, dir)
+	ctx := context.Background()
+
+	res, err := repo.Get(ctx, "test_id_1")
+	assert.NoError(t, err)
+	assert.True(t, testData[1].Price.Equal(res.Price))
+	assert.True(t, testData[1].ExpirationDate.Equal(res.ExpirationDate))
+
+	_, err = repo.Get(ctx, "test_id_4")
+	assert.ErrorIs(t, err, errors.ErrPriceNotFound)
+
+	many, err := repo.GetMany(ctx, []string{"test_id_3", "test_id_4", "test_id_2"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test_id_3", "test_id_2"}, priceIDs(many))
+
+	list, err := repo.List(ctx, "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test_id_1", "test_id_2"}, priceIDs(list))
+	list, err = repo.List(ctx, "test_id_2", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test_id_3"}, priceIDs(list))
+
+	expired, err := repo.ListExpired(ctx, now, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test_id_1", "test_id_3"}, priceIDs(expired))
+
+	assert.ErrorIs(t, repo.CreateMany(ctx, testData), errors.ErrReadOnly)
+	assert.ErrorIs(t, repo.DeleteMany(ctx, []string{"test_id_1"}), errors.ErrReadOnly)
+	_, err = repo.ImportFile(ctx, "prices.csv")
+	assert.ErrorIs(t, err, errors.ErrReadOnly)
+}
+
+func TestIndexPrices_Reload(t *testing.T) {
+	dir := t.TempDir()
+	writeTestIndex(t, dir, &models.Price{ID: "test_id_1", Price: decimal.NewFromInt(1)})
+	repo := newTestIndexPrices(t, dir)
+	ctx := context.Background()
+
+	_, err := repo.Get(ctx, "test_id_2")
+	assert.ErrorIs(t, err, errors.ErrPriceNotFound)
+
+	writeTestIndex(t, dir, &models.Price{ID: "test_id_2", Price: decimal.NewFromInt(2)})
+	assert.NoError(t, repo.Reload())
+
+	res, err := repo.Get(ctx, "test_id_2")
+	assert.NoError(t, err)
+	assert.True(t, decimal.NewFromInt(2).Equal(res.Price))
+	_, err = repo.Get(ctx, "test_id_1")
+	assert.ErrorIs(t, err, errors.ErrPriceNotFound)
+}
+
+func TestIndexPrices_NoIndex(t *testing.T) {
+	_, err := NewIndexPrices(config.Storage{Type: config.StorageIndex, DSN: t.TempDir()})
+	assert.Error(t, err)
+}
+
+func priceIDs(prices []*models.Price) []string {
+	ids := make([]string, 0, len(prices))
+	for _, price := range prices {
+		ids = append(ids, price.ID)
+	}
+	return ids
+}
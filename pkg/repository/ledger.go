@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"prices/pkg/config"
+	"prices/pkg/errors"
+	"prices/pkg/files"
+	"time"
+)
+
+const (
+	// ledgerQueryTimeout - timeout of a single ledger query, callers of the ledger don't pass a context.
+	ledgerQueryTimeout = 10 * time.Second
+	// ledgerMaxConnections - the ledger is written once per file, so it needs few connections.
+	ledgerMaxConnections = 4
+
+	getFileQuery = `
+		SELECT path, name, checksum, size, status, checkpoint, parent, import_id FROM files
+		WHERE path = ?
+	`
+	getFileByContentQuery = `
+		SELECT path, name, checksum, size, status, checkpoint, parent, import_id FROM files
+		WHERE checksum = ? AND size = ? AND status = 'imported'
+		LIMIT 1
+	`
+	getUnfinishedFilesQuery = `
+		SELECT path, name, checksum, size, status, checkpoint, parent, import_id FROM files
+		WHERE status IN ('queued', 'split', 'chunk')
+	`
+	getChunksQuery = `
+		SELECT path, name, checksum, size, status, checkpoint, parent, import_id FROM files
+		WHERE parent = ?
+	`
+	putFileMySQLQuery = `
+		INSERT INTO files (path, name, checksum, size, status, checkpoint, parent, import_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			name = VALUES(name),
+			checksum = VALUES(checksum),
+			size = VALUES(size),
+			status = VALUES(status),
+			checkpoint = VALUES(checkpoint),
+			parent = VALUES(parent),
+			import_id = VALUES(import_id)
+	`
+	putFileSQLiteQuery = `
+		INSERT INTO files (path, name, checksum, size, status, checkpoint, parent, import_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (path) DO UPDATE SET
+			name = excluded.name,
+			checksum = excluded.checksum,
+			size = excluded.size,
+			status = excluded.status,
+			checkpoint = excluded.checkpoint,
+			parent = excluded.parent,
+			import_id = excluded.import_id,
+			updated_at = CURRENT_TIMESTAMP
+	`
+	checkpointMySQLQuery = `
+		UPDATE files SET checkpoint = GREATEST(checkpoint, ?)
+		WHERE path = ?
+	`
+	checkpointSQLiteQuery = `
+		UPDATE files SET checkpoint = MAX(checkpoint, ?), updated_at = CURRENT_TIMESTAMP
+		WHERE path = ?
+	`
+)
+
+type (
+	// FileLedger - durable FileCache kept in the files table of the storage, so files are not imported again
+	// after restarts, and files with the same content are found whatever their path.
+	// The ledger of a sharded storage is kept in its first shard.
+	FileLedger struct {
+		db              *sql.DB
+		putQuery        string
+		checkpointQuery string
+	}
+)
+
+func NewFileLedger(storage config.Storage) (*FileLedger, error) {
+	if len(storage.Shards) > 0 {
+		storage = storage.ShardStorages()[0]
+	}
+
+	var putQuery, checkpointQuery string
+	switch storage.Type {
+	case config.StorageMySQL:
+		putQuery, checkpointQuery = putFileMySQLQuery, checkpointMySQLQuery
+	case config.StorageSQLite:
+		putQuery, checkpointQuery = putFileSQLiteQuery, checkpointSQLiteQuery
+	default:
+		return nil, fmt.Errorf("unsupported file ledger storage type=%s", storage.Type)
+	}
+
+	db, err := sql.Open(storage.Type, storage.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("can't establish connection to the data storage: %w", err)
+	}
+	db.SetMaxOpenConns(ledgerMaxConnections)
+	db.SetMaxIdleConns(ledgerMaxConnections)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("can't ping data storage: %w", err)
+	}
+
+	return &FileLedger{
+		db:              db,
+		putQuery:        putQuery,
+		checkpointQuery: checkpointQuery,
+	}, nil
+}
+
+// Put - saves the file, replacing the file with the same path.
+func (r *FileLedger) Put(file files.File) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ledgerQueryTimeout)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, r.putQuery, file.Path, file.Name, file.Checksum, file.Size, file.Status, file.Checkpoint, file.Parent, file.ImportID)
+	if err != nil {
+		return fmt.Errorf("can't save file=%s to ledger: %w", file.Path, err)
+	}
+	return nil
+}
+
+// Get - gets file by path.
+func (r *FileLedger) Get(path string) (files.File, bool, error) {
+	return r.get(getFileQuery, path)
+}
+
+// GetByContent - gets any of the imported files with the checksum and size.
+func (r *FileLedger) GetByContent(checksum string, size int64) (files.File, bool, error) {
+	return r.get(getFileByContentQuery, checksum, size)
+}
+
+// Checkpoint - saves rows of the file that are saved to the storage, the checkpoint never goes back,
+// so checkpoints saved out of order keep the latest of them.
+func (r *FileLedger) Checkpoint(file files.File, rows int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ledgerQueryTimeout)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, r.checkpointQuery, rows, file.Path)
+	if err != nil {
+		return fmt.Errorf("can't save file=%s checkpoint to ledger: %w", file.Path, err)
+	}
+	return nil
+}
+
+// Unfinished - gets files that are queued or split but not processed yet, e.g. because the application stopped while it imported them,
+// and chunks that are written but not queued yet.
+func (r *FileLedger) Unfinished() ([]files.File, error) {
+	unfinished, err := r.list(getUnfinishedFilesQuery)
+	if err != nil {
+		return nil, fmt.Errorf("can't get unfinished files from ledger: %w", err)
+	}
+	return unfinished, nil
+}
+
+// Chunks - gets chunks of the split file.
+func (r *FileLedger) Chunks(parent string) ([]files.File, error) {
+	chunks, err := r.list(getChunksQuery, parent)
+	if err != nil {
+		return nil, fmt.Errorf("can't get chunks of file=%s from ledger: %w", parent, err)
+	}
+	return chunks, nil
+}
+
+func (r *FileLedger) Close() error {
+	return r.db.Close()
+}
+
+func (r *FileLedger) get(query string, args ...any) (files.File, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ledgerQueryTimeout)
+	defer cancel()
+
+	var file files.File
+	err := r.db.QueryRowContext(ctx, query, args...).Scan(&file.Path, &file.Name, &file.Checksum, &file.Size, &file.Status, &file.Checkpoint, &file.Parent, &file.ImportID)
+	if errors.ErrorIs(err, sql.ErrNoRows) {
+		return files.File{}, false, nil
+	}
+	if err != nil {
+		return files.File{}, false, fmt.Errorf("can't get file from ledger: %w", err)
+	}
+	return file, true, nil
+}
+
+func (r *FileLedger) list(query string, args ...any) ([]files.File, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ledgerQueryTimeout)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []files.File
+	for rows.Next() {
+		var file files.File
+		if err := rows.Scan(&file.Path, &file.Name, &file.Checksum, &file.Size, &file.Status, &file.Checkpoint, &file.Parent, &file.ImportID); err != nil {
+			return nil, err
+		}
+		list = append(list, file)
+	}
+	return list, rows.Err()
+}
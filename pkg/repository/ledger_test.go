@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"path/filepath"
+	"prices/pkg/config"
+	"prices/pkg/files"
+	"prices/pkg/migrations"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSQLiteFileLedger(t *testing.T) *FileLedger {
+	storage := config.Storage{
+		Type:           config.StorageSQLite,
+		DSN:            filepath.Join(t.TempDir(), "prices.db") + "?_pragma=busy_timeout(5000)",
+		MaxConnections: 1,
+	}
+	err := migrations.MigrateDB(storage)
+	assert.NoError(t, err)
+	ledger, err := NewFileLedger(storage)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = ledger.Close()
+	})
+	return ledger
+}
+
+func TestFileLedger_SQLite(t *testing.T) {
+	ledger := newTestSQLiteFileLedger(t)
+
+	_, ok, err := ledger.Get("/data/1_prices.csv")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	file := files.File{Path: "/data/1_prices.csv", Name: "1_prices.csv", Checksum: "checksum", Size: 10, Status: files.StatusSplit}
+	assert.NoError(t, ledger.Put(file))
+	file.Status = files.StatusQueued
+	assert.NoError(t, ledger.Put(file))
+
+	res, ok, err := ledger.Get(file.Path)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, file, res)
+
+	// Files that are not imported yet are not originals.
+	_, ok, err = ledger.GetByContent("checksum", 10)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	file.Status = files.StatusImported
+	assert.NoError(t, ledger.Put(file))
+	res, ok, err = ledger.GetByContent("checksum", 10)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, file, res)
+
+	_, ok, err = ledger.GetByContent("checksum", 11)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestFileLedger_SQLite_Checkpoint(t *testing.T) {
+	ledger := newTestSQLiteFileLedger(t)
+
+	file := files.File{Path: "/data/1_prices.csv", Name: "1_prices.csv", Checksum: "checksum", Size: 10, Status: files.StatusQueued}
+	assert.NoError(t, ledger.Put(file))
+	assert.NoError(t, ledger.Put(files.File{Path: "/data/2_prices.csv", Name: "2_prices.csv", Checksum: "other", Size: 10, Status: files.StatusImported}))
+	assert.NoError(t, ledger.Checkpoint(file, 200))
+	// Checkpoints saved out of order don't go back.
+	assert.NoError(t, ledger.Checkpoint(file, 100))
+
+	unfinished, err := ledger.Unfinished()
+	assert.NoError(t, err)
+	file.Checkpoint = 200
+	assert.Equal(t, []files.File{file}, unfinished)
+
+	file.Status = files.StatusImported
+	assert.NoError(t, ledger.Put(file))
+	unfinished, err = ledger.Unfinished()
+	assert.NoError(t, err)
+	assert.Empty(t, unfinished)
+}
+
+func TestFileLedger_SQLite_Chunks(t *testing.T) {
+	ledger := newTestSQLiteFileLedger(t)
+
+	parent := files.File{Path: "/data/1_prices.csv", Name: "1_prices.csv", Checksum: "checksum", Size: 10, Status: files.StatusSplit}
+	chunk := files.File{Path: "/data/chunks/0_1_1_prices.chunk.csv", Name: "0_1_1_prices.chunk.csv", Status: files.StatusChunk, Parent: parent.Path, ImportID: files.ImportID(parent.Path)}
+	assert.NoError(t, ledger.Put(parent))
+	assert.NoError(t, ledger.Put(chunk))
+
+	chunks, err := ledger.Chunks(parent.Path)
+	assert.NoError(t, err)
+	assert.Equal(t, []files.File{chunk}, chunks)
+
+	// Split files and chunks that are written but not queued yet are unfinished.
+	unfinished, err := ledger.Unfinished()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []files.File{parent, chunk}, unfinished)
+
+	parent.Status = files.StatusImported
+	assert.NoError(t, ledger.Put(parent))
+	chunk.Status = files.StatusImported
+	assert.NoError(t, ledger.Put(chunk))
+	unfinished, err = ledger.Unfinished()
+	assert.NoError(t, err)
+	assert.Empty(t, unfinished)
+}
+
+func TestFileLedger_MySQL_Checkpoint(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	ledger := &FileLedger{db: db, putQuery: putFileMySQLQuery, checkpointQuery: checkpointMySQLQuery}
+
+	mock.ExpectExec(`
+		UPDATE files SET checkpoint = GREATEST(checkpoint, ?)
+		WHERE path = ?
+	`).WithArgs(int64(100), "/data/1_prices.csv").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, ledger.Checkpoint(files.File{Path: "/data/1_prices.csv"}, 100))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFileLedger_MySQL_Put(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	ledger := &FileLedger{db: db, putQuery: putFileMySQLQuery}
+
+	file := files.File{Path: "/data/1_prices.csv", Name: "1_prices.csv", Checksum: "checksum", Size: 10, Status: files.StatusQueued}
+	mock.ExpectExec(`
+		INSERT INTO files (path, name, checksum, size, status, checkpoint, parent, import_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			name = VALUES(name),
+			checksum = VALUES(checksum),
+			size = VALUES(size),
+			status = VALUES(status),
+			checkpoint = VALUES(checkpoint),
+			parent = VALUES(parent),
+			import_id = VALUES(import_id)
+	`).WithArgs(file.Path, file.Name, file.Checksum, file.Size, file.Status, file.Checkpoint, file.Parent, file.ImportID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, ledger.Put(file))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
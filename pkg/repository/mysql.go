@@ -1,22 +1,127 @@
 package repository
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"net"
 	"prices/pkg/config"
 	"prices/pkg/errors"
+	"prices/pkg/files"
 	"prices/pkg/models"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql" // DB driver
+	"github.com/go-sql-driver/mysql"
 	"github.com/nullism/bqb"
 )
 
+const (
+	// partitionFuture - name of the partition without upper bound, new partitions are split from it.
+	partitionFuture      = "p_future"
+	partitionMaxValue    = "MAXVALUE"
+	partitionBoundLayout = "2006-01-02 15:04:05"
+
+	pricesTable = "prices"
+	// stagingTable - table a snapshot of prices is loaded to before it replaces the prices table.
+	stagingTable = "prices_staging"
+	// previousTable - the prices table replaced by the last snapshot, kept for rollback.
+	previousTable = "prices_previous"
+	// importsTable - prices of atomic imports staged until the import is committed.
+	importsTable = "prices_imports"
+	// idsTable - expiration date of every id of the prices table. The primary key of the partitioned prices table
+	// includes the expiration date, so the ids table keeps ids unique and tells the partition of an id.
+	idsTable = "prices_ids"
+	// idsStagingTable and idsPreviousTable - ids of the staging and the previous tables of snapshots.
+	idsStagingTable  = "prices_ids_staging"
+	idsPreviousTable = "prices_ids_previous"
+
+	// dropIDsBatchSize - how many ids of dropped partitions are deleted by a single statement.
+	dropIDsBatchSize = 10000
+)
+
+// getPriceQuery - the expiration date of the id is looked up first, so the price is read from a single partition.
+const getPriceQuery = `
+	SELECT p.id, p.raw_id, p.price, p.expiration_date FROM prices_ids i
+	JOIN prices p ON p.id = i.id AND p.expiration_date = i.expiration_date
+	WHERE i.id = ?
+`
+
+// mergeIDsQuery - adds ids of the staged prices to the ids table, an id that is already there keeps its expiration date,
+// and of the prices staged with the same id the one that expires first is taken.
+const mergeIDsQuery = `
+	INSERT INTO prices_ids (id, expiration_date)
+	SELECT id, MIN(expiration_date) FROM prices_imports
+	WHERE import_id = ?
+	GROUP BY id
+	ON DUPLICATE KEY UPDATE
+		prices_ids.id = prices_ids.id
+`
+
+// mergePricesQuery - adds the staged prices with the expiration dates of their ids in the ids table,
+// prices that are already in the table are skipped.
+const mergePricesQuery = `
+	INSERT INTO prices (id, raw_id, price, expiration_date)
+	SELECT s.id, s.raw_id, s.price, s.expiration_date FROM prices_imports s
+	JOIN prices_ids i ON i.id = s.id AND i.expiration_date = s.expiration_date
+	WHERE s.import_id = ?
+	ON DUPLICATE KEY UPDATE
+		prices.id = prices.id
+`
+
+const dropStagedQuery = `
+	DELETE FROM prices_imports
+	WHERE import_id = ?
+`
+
+// getStaleImportsQuery - imports whose last prices were staged before the age in seconds.
+const getStaleImportsQuery = `
+	SELECT import_id FROM prices_imports
+	GROUP BY import_id
+	HAVING MAX(created_at) < NOW() - INTERVAL ? SECOND
+`
+
+// importHandlerSeq - makes names of the reader handlers registered for imported files unique.
+var importHandlerSeq atomic.Uint64
+
+// transientMySQLErrors - numbers of server errors after which the same statement can succeed if it is retried.
+var transientMySQLErrors = map[uint16]bool{
+	1040: true, // ER_CON_COUNT_ERROR, too many connections
+	1053: true, // ER_SERVER_SHUTDOWN
+	1205: true, // ER_LOCK_WAIT_TIMEOUT
+	1213: true, // ER_LOCK_DEADLOCK
+}
+
 type (
 	MySQLPrices struct {
-		db     *sql.DB
-		config config.Storage
+		db       *sql.DB
+		replicas *replicaSet
+		config   config.Storage
+		stmtsMu  sync.Mutex
+		stmts    map[preparedKey]*sql.Stmt
+	}
+
+	preparedKey struct {
+		db    *sql.DB
+		query string
+	}
+
+	// keyedReader - converts ids of .CSV lines read from r to hex encoded keys followed by raw ids,
+	// so they can be loaded to the id and raw_id columns, and expiration dates to UTC,
+	// as DATETIME values keep no zone and would be stored in the zone of the session otherwise.
+	keyedReader struct {
+		r   *bufio.Reader
+		buf bytes.Buffer
+		err error
 	}
 )
 
@@ -36,84 +141,971 @@ func NewMySQLPrices(config config.Storage) (*MySQLPrices, error) {
 		return nil, fmt.Errorf("can't ping data storage: %w", err)
 	}
 
+	replicas, err := newReplicaSet(db, config)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
 	return &MySQLPrices{
-		db:     db,
-		config: config,
+		db:       db,
+		replicas: replicas,
+		config:   config,
 	}, nil
 }
 
+// CreateMany - adds ids of the prices to the ids table and the prices to the prices table right from the batch
+// in a single transaction, prices with ids that are already stored are skipped, whatever their expiration dates.
 func (r *MySQLPrices) CreateMany(ctx context.Context, prices []*models.Price) error {
+	idsQuery, idsArgs, err := createIDsQuery(prices)
+	if err != nil {
+		return err
+	}
+	pricesQuery, pricesArgs, err := createPricesQuery(prices)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.writer().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin create prices transaction: %w", classifyMySQLError(ctx, err))
+	}
+	if _, err := tx.ExecContext(ctx, idsQuery, idsArgs...); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("can't execute create ids query: %w", classifyMySQLError(ctx, err))
+	}
+	if _, err := tx.ExecContext(ctx, pricesQuery, pricesArgs...); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("can't execute create prices query: %w", classifyMySQLError(ctx, err))
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit create prices transaction: %w", classifyMySQLError(ctx, err))
+	}
+
+	return nil
+}
+
+// createIDsQuery - builds the query adding ids of the prices to the ids table the same way as mergeIDsQuery does:
+// an id that is already there keeps its expiration date, and of the prices with the same id the one that expires first is taken.
+func createIDsQuery(prices []*models.Price) (string, []any, error) {
+	keys := make([]string, 0, len(prices))
+	expirations := make(map[string]time.Time, len(prices))
+	for _, price := range prices {
+		expiration, ok := expirations[price.ID]
+		if !ok {
+			keys = append(keys, price.ID)
+		}
+		if !ok || price.ExpirationDate.Before(expiration) {
+			expirations[price.ID] = price.ExpirationDate
+		}
+	}
+
+	values := bqb.Q()
+	for _, id := range keys {
+		key, _ := priceKey(id)
+		values.Comma("(?,?)", key, expirations[id].UTC())
+	}
+	q := bqb.New(
+		`
+			INSERT INTO prices_ids (id, expiration_date) VALUES
+			?
+			ON DUPLICATE KEY UPDATE
+				prices_ids.id = prices_ids.id
+		`,
+		values,
+	)
+	query, args, err := q.ToMysql()
+	if err != nil {
+		return "", nil, fmt.Errorf("can't build create ids query: %w", err)
+	}
+
+	return query, args, nil
+}
+
+// createPricesQuery - builds the query adding the prices with the expiration dates of their ids in the ids table
+// the same way as mergePricesQuery does, but the prices are selected from a list of rows instead of the staged prices.
+func createPricesQuery(prices []*models.Price) (string, []any, error) {
+	rows := bqb.Q()
+	for _, price := range prices {
+		key, raw := priceKey(price.ID)
+		rows.Comma("ROW(?,?,?,?)", key, raw, price.Price, price.ExpirationDate.UTC())
+	}
+	q := bqb.New(
+		`
+			INSERT INTO prices (id, raw_id, price, expiration_date)
+			SELECT v.id, v.raw_id, v.price, v.expiration_date FROM (VALUES
+			?
+			) v (id, raw_id, price, expiration_date)
+			JOIN prices_ids i ON i.id = v.id AND i.expiration_date = v.expiration_date
+			ON DUPLICATE KEY UPDATE
+				prices.id = prices.id
+		`,
+		rows,
+	)
+	query, args, err := q.ToMysql()
+	if err != nil {
+		return "", nil, fmt.Errorf("can't build create prices query: %w", err)
+	}
+
+	return query, args, nil
+}
+
+// ImportFile - loads .CSV file to the staged prices with LOAD DATA ... IGNORE and merges them to the prices table,
+// rows with ids that are already stored are skipped. The file is passed to the driver as a registered reader,
+// so its path never gets into the query, ids are converted to keys by the reader, and compressed files are decompressed on the fly.
+// Warnings of the import, e.g. truncated values, are read on the same connection right after it.
+func (r *MySQLPrices) ImportFile(ctx context.Context, filePath string) (*models.ImportResult, error) {
+	f, err := files.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("can't open file=%s to import prices: %w", filePath, err)
+	}
+	defer f.Close()
+
+	return r.importMerged(ctx, filePath, f)
+}
+
+// ImportRange - same as ImportFile, but loads length bytes of the file from offset, the range must be aligned to lines.
+func (r *MySQLPrices) ImportRange(ctx context.Context, filePath string, offset int64, length int64) (*models.ImportResult, error) {
+	f, err := files.OpenRange(filePath, offset, length)
+	if err != nil {
+		return nil, fmt.Errorf("can't open file=%s to import prices: %w", filePath, err)
+	}
+	defer f.Close()
+
+	return r.importMerged(ctx, filePath, f)
+}
+
+// importMerged - stages .CSV data read from f by a new import id and commits the import right away,
+// staged prices are dropped if they can't be merged.
+func (r *MySQLPrices) importMerged(ctx context.Context, filePath string, f io.Reader) (*models.ImportResult, error) {
+	importID := newImportID()
+	result, err := r.importReader(ctx, importsTable, importID, filePath, f)
+	if err == nil {
+		result.RowsAffected, err = r.CommitImport(ctx, importID)
+	}
+	if err != nil {
+		if rollbackErr := r.RollbackImport(ctx, importID); rollbackErr != nil {
+			err = fmt.Errorf("%w, %w", err, rollbackErr)
+		}
+		return nil, err
+	}
+	result.RowsSkipped = max(result.Records-result.RowsAffected, 0)
+
+	return result, nil
+}
+
+// StageMany - stages prices of the import, prices with the same id and expiration date are staged once.
+func (r *MySQLPrices) StageMany(ctx context.Context, importID string, prices []*models.Price) error {
 	values := bqb.Q()
 	for _, price := range prices {
-		values.Comma("(?,?,?)", price.ID, price.Price, price.ExpirationDate)
+		key, raw := priceKey(price.ID)
+		values.Comma("(?,?,?,?,?)", importID, key, raw, price.Price, price.ExpirationDate.UTC())
 	}
 	q := bqb.New(
 		`
-			INSERT INTO prices (id, price, expiration_date) VALUES 
+			INSERT INTO prices_imports (import_id, id, raw_id, price, expiration_date) VALUES
 			?
-			ON DUPLICATE KEY UPDATE 
+			ON DUPLICATE KEY UPDATE
 				id = id
 		`,
 		values,
 	)
 	query, args, err := q.ToMysql()
 	if err != nil {
-		return fmt.Errorf("can't build create prices query: %w", err)
+		return fmt.Errorf("can't build stage prices query: %w", err)
 	}
 
-	_, err = r.db.ExecContext(ctx, query, args...)
+	_, err = r.writer().ExecContext(ctx, query, args...)
 	if err != nil {
-		return fmt.Errorf("can't execute create prices query: %w", err)
+		return fmt.Errorf("can't execute stage prices query: %w", classifyMySQLError(ctx, err))
 	}
 
 	return nil
 }
 
-func (r *MySQLPrices) ImportFile(ctx context.Context, filePath string) error {
+// StageFile - same as ImportFile or ImportRange, but loads the file to the staged prices of the import.
+func (r *MySQLPrices) StageFile(ctx context.Context, importID string, filePath string, offset int64, length int64) (*models.ImportResult, error) {
+	if err := checkImportTarget(importsTable, importID); err != nil {
+		return nil, err
+	}
+	f, err := openRange(filePath, offset, length)
+	if err != nil {
+		return nil, fmt.Errorf("can't open file=%s to import prices: %w", filePath, err)
+	}
+	defer f.Close()
+
+	return r.importReader(ctx, importsTable, importID, filePath, f)
+}
+
+// CommitImport - merges staged prices of the import to the prices table and drops them in a single transaction,
+// prices with ids that are already stored are skipped.
+func (r *MySQLPrices) CommitImport(ctx context.Context, importID string) (int64, error) {
+	tx, err := r.writer().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("can't begin commit import transaction: %w", classifyMySQLError(ctx, err))
+	}
+	affected, err := mergeImport(ctx, tx, importID)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("can't commit import transaction: %w", classifyMySQLError(ctx, err))
+	}
+
+	return affected, nil
+}
+
+// mergeImport - moves staged prices of the import to the prices table through the ids table, so every id is stored once:
+// a price is added only if its expiration date is the one of its id. Returns how many prices were added.
+func mergeImport(ctx context.Context, tx *sql.Tx, importID string) (int64, error) {
+	if _, err := tx.ExecContext(ctx, mergeIDsQuery, importID); err != nil {
+		return 0, fmt.Errorf("can't execute merge ids query: %w", classifyMySQLError(ctx, err))
+	}
+	res, err := tx.ExecContext(ctx, mergePricesQuery, importID)
+	if err != nil {
+		return 0, fmt.Errorf("can't execute commit import query: %w", classifyMySQLError(ctx, err))
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("can't get rows affected by commit import query: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, dropStagedQuery, importID); err != nil {
+		return 0, fmt.Errorf("can't execute drop staged prices query: %w", classifyMySQLError(ctx, err))
+	}
+
+	return affected, nil
+}
+
+// RollbackImport - drops staged prices of the import.
+func (r *MySQLPrices) RollbackImport(ctx context.Context, importID string) error {
+	_, err := r.writer().ExecContext(ctx, dropStagedQuery, importID)
+	if err != nil {
+		return fmt.Errorf("can't execute drop staged prices query: %w", classifyMySQLError(ctx, err))
+	}
+
+	return nil
+}
+
+// StaleImports - returns ids of the imports that haven't staged prices for the age, e.g. imports of files
+// that were released, or of imports that stopped before they were committed or rolled back.
+func (r *MySQLPrices) StaleImports(ctx context.Context, age time.Duration) ([]string, error) {
+	rows, err := r.writer().QueryContext(ctx, getStaleImportsQuery, int64(age.Seconds()))
+	if err != nil {
+		return nil, fmt.Errorf("can't execute get stale imports query: %w", classifyMySQLError(ctx, err))
+	}
+	defer rows.Close()
+
+	return scanImportIDs(rows)
+}
+
+// checkImportTarget - LOAD DATA can't be prepared, so the table and the import id are a part of the query:
+// only the staged prices with a hex encoded import id or the staging table without one can be loaded.
+func checkImportTarget(table string, importID string) error {
+	switch table {
+	case importsTable:
+		if _, err := hex.DecodeString(importID); err != nil || importID == "" {
+			return fmt.Errorf("can't stage prices of import=%s: import id is not hex encoded", importID)
+		}
+	case stagingTable:
+		if importID != "" {
+			return fmt.Errorf("can't load prices of import=%s to table=%s: table has no imports", importID, table)
+		}
+	default:
+		return fmt.Errorf("can't load prices to table=%s: table is not loaded from files", table)
+	}
+
+	return nil
+}
+
+func (r *MySQLPrices) importFile(ctx context.Context, table string, filePath string) (*models.ImportResult, error) {
+	f, err := files.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("can't open file=%s to import prices: %w", filePath, err)
+	}
+	defer f.Close()
+
+	return r.importReader(ctx, table, "", filePath, f)
+}
+
+// importReader - loads .CSV data read from f to the table, filePath is the file the data is read from.
+// Staged prices are loaded with the import id, which must be hex encoded.
+func (r *MySQLPrices) importReader(ctx context.Context, table string, importID string, filePath string, f io.Reader) (*models.ImportResult, error) {
+	if err := checkImportTarget(table, importID); err != nil {
+		return nil, err
+	}
+	lines := &lineCounter{r: f}
+	keyed := newKeyedReader(lines)
+	handler := fmt.Sprintf("prices_import_%d", importHandlerSeq.Add(1))
+	mysql.RegisterReaderHandler(handler, func() io.Reader {
+		return keyed
+	})
+	defer mysql.DeregisterReaderHandler(handler)
+
+	set := "id = UNHEX(@id), raw_id = NULLIF(@raw_id, '')"
+	if importID != "" {
+		set += fmt.Sprintf(", import_id = '%s'", importID)
+	}
 	q := bqb.New(fmt.Sprintf(`
-		LOAD DATA CONCURRENT LOCAL INFILE '%s'
+		LOAD DATA CONCURRENT LOCAL INFILE 'Reader::%s'
 		IGNORE
-		INTO TABLE prices
+		INTO TABLE %s
 		FIELDS TERMINATED BY ','
 		LINES TERMINATED BY '\n'
-		(id,price,expiration_date)
-	`, filePath))
+		(@id,@raw_id,price,expiration_date)
+		SET %s
+	`, handler, table, set))
+	query, args, err := q.ToMysql()
+	if err != nil {
+		return nil, fmt.Errorf("can't build import prices from file=%s query: %w", filePath, err)
+	}
+
+	conn, err := r.writer().Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't get connection to import prices from file=%s: %w", filePath, classifyMySQLError(ctx, err))
+	}
+	defer conn.Close()
+
+	res, err := conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("can't execute import prices from file=%s query: %w", filePath, classifyMySQLError(ctx, err))
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("can't get rows affected by import prices from file=%s: %w", filePath, err)
+	}
+
+	result := &models.ImportResult{
+		Records:      lines.count(),
+		RowsAffected: affected,
+		RowsSkipped:  max(lines.count()-affected, 0),
+	}
+	result.Warnings, err = showWarnings(ctx, conn)
+	if err != nil {
+		return result, fmt.Errorf("can't get warnings of import prices from file=%s: %w", filePath, err)
+	}
+
+	return result, nil
+}
+
+// Get - gets price by id with a statement prepared once per DB, because it is the hottest query.
+func (r *MySQLPrices) Get(ctx context.Context, id string) (*models.Price, error) {
+	key, _ := priceKey(id)
+
+	var (
+		price models.Price
+		raw   sql.NullString
+		idKey []byte
+	)
+
+	err := r.read(ctx, func(db *sql.DB) error {
+		stmt, err := r.prepared(ctx, db, getPriceQuery)
+		if err != nil {
+			return err
+		}
+		row := stmt.QueryRowContext(ctx, key)
+		return row.Scan(&idKey, &raw, &price.Price, &price.ExpirationDate)
+	})
+	if err != nil {
+		if errors.ErrorIs(err, sql.ErrNoRows) {
+			return nil, errors.ErrPriceNotFound
+		}
+		return nil, fmt.Errorf("can't execute get price query: %w", err)
+	}
+	price.ID, err = priceID(idKey, raw)
+	if err != nil {
+		return nil, fmt.Errorf("can't convert price key: %w", err)
+	}
+
+	return &price, nil
+}
+
+// GetMany - gets prices by ids, ids that are not found are skipped.
+func (r *MySQLPrices) GetMany(ctx context.Context, ids []string) ([]*models.Price, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	q := bqb.New(
+		`
+			SELECT p.id, p.raw_id, p.price, p.expiration_date FROM prices_ids i
+			JOIN prices p ON p.id = i.id AND p.expiration_date = i.expiration_date
+			WHERE i.id IN (?)
+		`,
+		priceKeys(ids),
+	)
+	query, args, err := q.ToMysql()
+	if err != nil {
+		return nil, fmt.Errorf("can't build get prices query: %w", err)
+	}
+
+	var prices []*models.Price
+
+	err = r.read(ctx, func(db *sql.DB) (err error) {
+		prices, err = queryMySQLPrices(ctx, db, query, args)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't execute get prices query: %w", err)
+	}
+
+	return prices, nil
+}
+
+// List - lists up to limit prices ordered by key, starting after the given id.
+func (r *MySQLPrices) List(ctx context.Context, after string, limit int) ([]*models.Price, error) {
+	// Empty key instead of nil, because nothing is greater than NULL.
+	afterKey := []byte{}
+	if after != "" {
+		afterKey, _ = priceKey(after)
+	}
+	q := bqb.New(
+		`
+			SELECT p.id, p.raw_id, p.price, p.expiration_date FROM prices_ids i
+			JOIN prices p ON p.id = i.id AND p.expiration_date = i.expiration_date
+			WHERE i.id > ?
+			ORDER BY i.id
+			LIMIT ?
+		`,
+		afterKey, limit,
+	)
 	query, args, err := q.ToMysql()
 	if err != nil {
-		return fmt.Errorf("can't build import prices from file=%s query: %w", filePath, err)
+		return nil, fmt.Errorf("can't build list prices query: %w", err)
+	}
+
+	var prices []*models.Price
+
+	err = r.read(ctx, func(db *sql.DB) (err error) {
+		prices, err = queryMySQLPrices(ctx, db, query, args)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't execute list prices query: %w", err)
+	}
+
+	return prices, nil
+}
+
+// ListExpired - lists up to limit prices that expired before the given time, the longest expired first.
+// Reads from the primary, because expired prices are listed to be deleted.
+func (r *MySQLPrices) ListExpired(ctx context.Context, before time.Time, limit int) ([]*models.Price, error) {
+	q := bqb.New(
+		`
+			SELECT id, raw_id, price, expiration_date FROM prices
+			WHERE expiration_date < ?
+			ORDER BY expiration_date
+			LIMIT ?
+		`,
+		before, limit,
+	)
+	query, args, err := q.ToMysql()
+	if err != nil {
+		return nil, fmt.Errorf("can't build list expired prices query: %w", err)
+	}
+
+	prices, err := queryMySQLPrices(ctx, r.db, query, args)
+	if err != nil {
+		return nil, fmt.Errorf("can't execute list expired prices query: %w", err)
+	}
+
+	return prices, nil
+}
+
+// DeleteMany - deletes prices and their ids in a single transaction, so the ids can be imported again.
+func (r *MySQLPrices) DeleteMany(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := priceKeys(ids)
+	tx, err := r.writer().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin delete prices transaction: %w", classifyMySQLError(ctx, err))
+	}
+	for _, table := range []string{pricesTable, idsTable} {
+		query, args, err := bqb.New(fmt.Sprintf(`
+			DELETE FROM %s
+			WHERE id IN (?)
+		`, table), keys).ToMysql()
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("can't build delete prices query: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("can't execute delete prices query: %w", classifyMySQLError(ctx, err))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit delete prices transaction: %w", classifyMySQLError(ctx, err))
+	}
+
+	return nil
+}
+
+// ListPartitions - lists partitions of the prices table ordered by their bounds.
+func (r *MySQLPrices) ListPartitions(ctx context.Context) ([]models.Partition, error) {
+	q := bqb.New(
+		`
+			SELECT PARTITION_NAME, PARTITION_DESCRIPTION FROM information_schema.PARTITIONS
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'prices' AND PARTITION_NAME IS NOT NULL
+			ORDER BY PARTITION_ORDINAL_POSITION
+		`,
+	)
+	query, args, err := q.ToMysql()
+	if err != nil {
+		return nil, fmt.Errorf("can't build list partitions query: %w", err)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("can't execute list partitions query: %w", err)
+	}
+	defer rows.Close()
+
+	var partitions []models.Partition
+	for rows.Next() {
+		var (
+			partition   models.Partition
+			description string
+		)
+		if err := rows.Scan(&partition.Name, &description); err != nil {
+			return nil, fmt.Errorf("can't scan partition: %w", err)
+		}
+		if description != partitionMaxValue {
+			partition.LessThan, err = time.Parse(partitionBoundLayout, strings.Trim(description, "'"))
+			if err != nil {
+				return nil, fmt.Errorf("can't parse bound=%s of partition=%s: %w", description, partition.Name, err)
+			}
+		}
+		partitions = append(partitions, partition)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't execute list partitions query: %w", err)
+	}
+
+	return partitions, nil
+}
+
+// AddPartitions - adds partitions to the prices table by splitting the last partition without upper bound.
+// Partitions must be ordered by their bounds and have bounds greater than the bounds of existing partitions.
+func (r *MySQLPrices) AddPartitions(ctx context.Context, partitions []models.Partition) error {
+	if len(partitions) == 0 {
+		return nil
+	}
+	definitions := make([]string, 0, len(partitions)+1)
+	for _, partition := range partitions {
+		definitions = append(definitions, fmt.Sprintf(
+			"PARTITION %s VALUES LESS THAN ('%s')",
+			partition.Name, partition.LessThan.Format(partitionBoundLayout),
+		))
+	}
+	definitions = append(definitions, fmt.Sprintf("PARTITION %s VALUES LESS THAN (MAXVALUE)", partitionFuture))
+
+	q := bqb.New(fmt.Sprintf(`
+		ALTER TABLE prices
+		REORGANIZE PARTITION %s INTO (%s)
+	`, partitionFuture, strings.Join(definitions, ", ")))
+	query, args, err := q.ToMysql()
+	if err != nil {
+		return fmt.Errorf("can't build add partitions query: %w", err)
 	}
 
 	_, err = r.db.ExecContext(ctx, query, args...)
 	if err != nil {
-		return fmt.Errorf("can't execute import prices from file=%s query: %w", filePath, err)
+		return fmt.Errorf("can't execute add partitions query: %w", err)
 	}
 
 	return nil
 }
 
-func (r *MySQLPrices) Get(ctx context.Context, id string) (*models.Price, error) {
+// DropPartitions - drops partitions of the prices table with all prices in them.
+// Ids of the prices are deleted first, so they can be imported again once their partitions are dropped.
+func (r *MySQLPrices) DropPartitions(ctx context.Context, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	if err := r.dropPartitionIDs(ctx, names); err != nil {
+		return err
+	}
+	q := bqb.New(fmt.Sprintf(`
+		ALTER TABLE prices
+		DROP PARTITION %s
+	`, strings.Join(names, ", ")))
+	query, args, err := q.ToMysql()
+	if err != nil {
+		return fmt.Errorf("can't build drop partitions query: %w", err)
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("can't execute drop partitions query: %w", err)
+	}
+
+	return nil
+}
+
+// dropPartitionIDs - deletes ids with expiration dates in the bounds of the partitions in batches.
+func (r *MySQLPrices) dropPartitionIDs(ctx context.Context, names []string) error {
+	partitions, err := r.ListPartitions(ctx)
+	if err != nil {
+		return err
+	}
+	dropped := make(map[string]bool, len(names))
+	for _, name := range names {
+		dropped[name] = true
+	}
+	var from time.Time
+	for _, partition := range partitions {
+		if dropped[partition.Name] {
+			if partition.LessThan.IsZero() {
+				return fmt.Errorf("can't drop partition=%s without upper bound", partition.Name)
+			}
+			if err := r.deleteIDs(ctx, from, partition.LessThan); err != nil {
+				return err
+			}
+		}
+		from = partition.LessThan
+	}
+
+	return nil
+}
+
+// deleteIDs - deletes ids with expiration dates from the start, zero for no lower bound, up to the end.
+func (r *MySQLPrices) deleteIDs(ctx context.Context, from time.Time, to time.Time) error {
+	for {
+		res, err := r.db.ExecContext(ctx, `
+			DELETE FROM prices_ids
+			WHERE expiration_date >= ? AND expiration_date < ?
+			LIMIT ?
+		`, from, to, dropIDsBatchSize)
+		if err != nil {
+			return fmt.Errorf("can't execute delete ids of partition query: %w", err)
+		}
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("can't get rows affected by delete ids of partition query: %w", err)
+		}
+		if deleted < dropIDsBatchSize {
+			return nil
+		}
+	}
+}
+
+// MergePartitions - merges adjacent partitions of the prices table into the last of them, it keeps its name and bound.
+func (r *MySQLPrices) MergePartitions(ctx context.Context, partitions []models.Partition) error {
+	if len(partitions) < 2 {
+		return nil
+	}
+	names := make([]string, 0, len(partitions))
+	for _, partition := range partitions {
+		names = append(names, partition.Name)
+	}
+	last := partitions[len(partitions)-1]
+	q := bqb.New(fmt.Sprintf(`
+		ALTER TABLE prices
+		REORGANIZE PARTITION %s INTO (PARTITION %s VALUES LESS THAN ('%s'))
+	`, strings.Join(names, ", "), last.Name, last.LessThan.Format(partitionBoundLayout)))
+	query, args, err := q.ToMysql()
+	if err != nil {
+		return fmt.Errorf("can't build merge partitions query: %w", err)
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("can't execute merge partitions query: %w", err)
+	}
+
+	return nil
+}
+
+// CreateStaging - creates an empty staging table with the same structure as the prices table,
+// the staging table left from a previous snapshot is dropped.
+func (r *MySQLPrices) CreateStaging(ctx context.Context) error {
+	for _, query := range []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s", stagingTable),
+		fmt.Sprintf("CREATE TABLE %s LIKE %s", stagingTable, pricesTable),
+	} {
+		if _, err := r.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("can't execute create staging table query: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ImportStagingFile - same as ImportFile, but loads the file to the staging table.
+func (r *MySQLPrices) ImportStagingFile(ctx context.Context, filePath string) (*models.ImportResult, error) {
+	return r.importFile(ctx, stagingTable, filePath)
+}
+
+// Count - returns number of prices in the prices table.
+func (r *MySQLPrices) Count(ctx context.Context) (int64, error) {
+	return r.count(ctx, pricesTable)
+}
+
+// CountStaging - returns number of prices in the staging table.
+func (r *MySQLPrices) CountStaging(ctx context.Context) (int64, error) {
+	return r.count(ctx, stagingTable)
+}
+
+// SwapStaging - atomically replaces the prices table with the staging table,
+// the replaced table is kept as the previous table until the next swap.
+// Ids of the staging table are collected to its own ids table first, and it is swapped together with the staging table,
+// of the prices of the snapshot with the same id the one that expires first is kept.
+func (r *MySQLPrices) SwapStaging(ctx context.Context) error {
+	for _, query := range []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s", idsStagingTable),
+		fmt.Sprintf("CREATE TABLE %s LIKE %s", idsStagingTable, idsTable),
+		fmt.Sprintf("INSERT INTO %s (id, expiration_date) SELECT id, MIN(expiration_date) FROM %s GROUP BY id", idsStagingTable, stagingTable),
+		fmt.Sprintf("DELETE s FROM %s s JOIN %s i ON i.id = s.id WHERE s.expiration_date <> i.expiration_date", stagingTable, idsStagingTable),
+		fmt.Sprintf("DROP TABLE IF EXISTS %s, %s", previousTable, idsPreviousTable),
+		fmt.Sprintf(
+			"RENAME TABLE %s TO %s, %s TO %s, %s TO %s, %s TO %s",
+			pricesTable, previousTable, stagingTable, pricesTable, idsTable, idsPreviousTable, idsStagingTable, idsTable,
+		),
+	} {
+		if _, err := r.writer().ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("can't execute swap staging table query: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// RollbackSwap - atomically puts the previous table back in place of the prices table,
+// the replaced table becomes the staging table.
+func (r *MySQLPrices) RollbackSwap(ctx context.Context) error {
 	q := bqb.New(
 		`
-			SELECT id, price, expiration_date FROM prices
-			WHERE id = ?
+			SELECT COUNT(*) FROM information_schema.TABLES
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
 		`,
-		id,
+		previousTable,
 	)
 	query, args, err := q.ToMysql()
 	if err != nil {
-		return nil, fmt.Errorf("can't build get price query: %w", err)
+		return fmt.Errorf("can't build find previous table query: %w", err)
+	}
+	var found int
+	if err := r.db.QueryRowContext(ctx, query, args...).Scan(&found); err != nil {
+		return fmt.Errorf("can't execute find previous table query: %w", err)
+	}
+	if found == 0 {
+		return fmt.Errorf("no previous table=%s to roll back to", previousTable)
+	}
+
+	for _, query := range []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s, %s", stagingTable, idsStagingTable),
+		fmt.Sprintf(
+			"RENAME TABLE %s TO %s, %s TO %s, %s TO %s, %s TO %s",
+			pricesTable, stagingTable, previousTable, pricesTable, idsTable, idsStagingTable, idsPreviousTable, idsTable,
+		),
+	} {
+		if _, err := r.writer().ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("can't execute rollback swap query: %w", err)
+		}
 	}
 
-	var price models.Price
+	return nil
+}
 
-	row := r.db.QueryRowContext(ctx, query, args...)
-	err = row.Scan(&price.ID, &price.Price, &price.ExpirationDate)
+func (r *MySQLPrices) count(ctx context.Context, table string) (int64, error) {
+	var count int64
+	err := r.db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&count)
 	if err != nil {
-		if errors.ErrorIs(err, sql.ErrNoRows) {
-			return nil, errors.ErrPriceNotFound
+		return 0, fmt.Errorf("can't execute count prices in table=%s query: %w", table, err)
+	}
+	return count, nil
+}
+
+// queryMySQLPrices - runs the query and scans prices with keys converted back to ids.
+func queryMySQLPrices(ctx context.Context, db *sql.DB, query string, args []any) ([]*models.Price, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prices []*models.Price
+	for rows.Next() {
+		var (
+			price models.Price
+			key   []byte
+			raw   sql.NullString
+		)
+		if err := rows.Scan(&key, &raw, &price.Price, &price.ExpirationDate); err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("can't execute get price query: %w", err)
+		price.ID, err = priceID(key, raw)
+		if err != nil {
+			return nil, fmt.Errorf("can't convert price key: %w", err)
+		}
+		prices = append(prices, &price)
 	}
 
-	return &price, nil
+	return prices, rows.Err()
+}
+
+// newImportID - returns a random import id, for prices that are staged only to be merged right away.
+func newImportID() string {
+	id := make([]byte, 32)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func newKeyedReader(r io.Reader) *keyedReader {
+	return &keyedReader{r: bufio.NewReader(r)}
+}
+
+func (k *keyedReader) Read(p []byte) (int, error) {
+	for k.buf.Len() == 0 && k.err == nil {
+		line, err := k.r.ReadString('\n')
+		if len(line) > 0 {
+			k.writeLine(line)
+		}
+		k.err = err
+	}
+	if k.buf.Len() > 0 {
+		return k.buf.Read(p)
+	}
+	return 0, k.err
+}
+
+// writeLine - writes the line with its id replaced by the key and the raw id, and its expiration date in UTC,
+// lines without id are dropped. Dates that can't be parsed are written as they are, so the row gets a warning.
+func (k *keyedReader) writeLine(line string) {
+	end := strings.IndexByte(line, ',')
+	if end < 0 {
+		end = len(strings.TrimRight(line, "\r\n"))
+	}
+	id := line[:end]
+	if id == "" {
+		return
+	}
+	key, raw := priceKey(id)
+	k.buf.WriteString(hex.EncodeToString(key))
+	k.buf.WriteByte(',')
+	k.buf.WriteString(raw.String)
+
+	rest := line[end:]
+	content := strings.TrimRight(rest, "\r\n")
+	dateStart := strings.LastIndexByte(content, ',') + 1
+	if dateStart == 0 {
+		k.buf.WriteString(rest)
+		return
+	}
+	date, err := files.ParseDate(content[dateStart:], nil, nil)
+	if err != nil {
+		k.buf.WriteString(rest)
+		return
+	}
+	k.buf.WriteString(content[:dateStart])
+	k.buf.WriteString(date.Format(time.DateTime))
+	k.buf.WriteString(rest[len(content):])
+}
+
+// showWarnings - returns warnings of the last statement executed on the connection.
+func showWarnings(ctx context.Context, conn *sql.Conn) ([]models.ImportWarning, error) {
+	rows, err := conn.QueryContext(ctx, "SHOW WARNINGS")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var warnings []models.ImportWarning
+	for rows.Next() {
+		var warning models.ImportWarning
+		if err := rows.Scan(&warning.Level, &warning.Code, &warning.Message); err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, warning)
+	}
+
+	return warnings, rows.Err()
+}
+
+// Close - closes prepared statements and connections to the storage and its replicas.
+func (r *MySQLPrices) Close() error {
+	r.stmtsMu.Lock()
+	for _, stmt := range r.stmts {
+		_ = stmt.Close()
+	}
+	r.stmts = nil
+	r.stmtsMu.Unlock()
+	if r.replicas != nil {
+		r.replicas.close()
+	}
+	return r.db.Close()
+}
+
+// prepared - returns the query prepared on the DB, the query is prepared on first use.
+func (r *MySQLPrices) prepared(ctx context.Context, db *sql.DB, query string) (*sql.Stmt, error) {
+	r.stmtsMu.Lock()
+	defer r.stmtsMu.Unlock()
+	key := preparedKey{db: db, query: query}
+	if stmt, ok := r.stmts[key]; ok {
+		return stmt, nil
+	}
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if r.stmts == nil {
+		r.stmts = make(map[preparedKey]*sql.Stmt)
+	}
+	r.stmts[key] = stmt
+	return stmt, nil
+}
+
+// lessID - prices are listed in the order of their keys.
+func (r *MySQLPrices) lessID(a, b string) bool {
+	return lessKey(a, b)
+}
+
+// read - runs fn against the DB for reads, if a replica fails fn is retried against the primary.
+func (r *MySQLPrices) read(ctx context.Context, fn func(db *sql.DB) error) error {
+	db := r.reader(ctx)
+	err := fn(db)
+	if err != nil && db != r.db && ctx.Err() == nil && !errors.ErrorIs(err, sql.ErrNoRows) {
+		r.replicas.failed(db)
+		err = fn(r.db)
+	}
+	return err
+}
+
+// reader - returns DB for reads, one of the healthy replicas if there are any.
+func (r *MySQLPrices) reader(ctx context.Context) *sql.DB {
+	if r.replicas == nil {
+		return r.db
+	}
+	return r.replicas.reader(ctx)
+}
+
+// writer - returns DB for writes, it is always the primary.
+func (r *MySQLPrices) writer() *sql.DB {
+	return r.db
+}
+
+// classifyMySQLError - marks the error with errors.ErrTransient if it is a deadlock, a lock wait timeout or a lost connection.
+// Errors of a canceled or expired ctx of the caller are never transient, retrying them can't succeed.
+func classifyMySQLError(ctx context.Context, err error) error {
+	if ctx.Err() != nil || errors.ErrorIs(err, context.Canceled) || errors.ErrorIs(err, context.DeadlineExceeded) {
+		return err
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.ErrorAs(err, &mysqlErr) && transientMySQLErrors[mysqlErr.Number] || isConnectionError(err) {
+		return fmt.Errorf("%w: %w", errors.ErrTransient, err)
+	}
+	return err
+}
+
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.ErrorIs(err, driver.ErrBadConn) ||
+		errors.ErrorIs(err, mysql.ErrInvalidConn) ||
+		errors.ErrorIs(err, io.ErrUnexpectedEOF) ||
+		errors.ErrorIs(err, syscall.ECONNRESET) ||
+		errors.ErrorIs(err, syscall.ECONNREFUSED) ||
+		errors.ErrorIs(err, syscall.EPIPE) ||
+		errors.ErrorAs(err, &netErr)
 }
@@ -2,12 +2,27 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"prices/pkg/config"
+	"prices/pkg/errors"
+	"prices/pkg/migrations"
 	"prices/pkg/models"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/nullism/bqb"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 )
@@ -21,6 +36,82 @@ func newTestMysqlPrices(t *testing.T) (*MySQLPrices, sqlmock.Sqlmock) {
 	return repo, mock
 }
 
+func testPriceRows(prices ...*models.Price) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "raw_id", "price", "expiration_date"})
+	for _, price := range prices {
+		key, raw := priceKey(price.ID)
+		var rawValue any
+		if raw.Valid {
+			rawValue = raw.String
+		}
+		rows.AddRow(key, rawValue, price.Price, price.ExpirationDate)
+	}
+	return rows
+}
+
+// expectCreateMany - expects ids of the prices and the prices to be created right from the batch in a transaction,
+// the prices must have distinct ids.
+func expectCreateMany(mock sqlmock.Sqlmock, prices ...*models.Price) {
+	mock.ExpectBegin()
+	expectCreateIDs(mock, prices...).WillReturnResult(sqlmock.NewResult(0, int64(len(prices))))
+	expectCreatePrices(mock, prices...).WillReturnResult(sqlmock.NewResult(0, int64(len(prices))))
+	mock.ExpectCommit()
+}
+
+// expectCreateIDs - expects ids of the prices to be added to the ids table, the prices must have distinct ids.
+func expectCreateIDs(mock sqlmock.Sqlmock, prices ...*models.Price) *sqlmock.ExpectedExec {
+	values := make([]string, 0, len(prices))
+	args := make([]driver.Value, 0, 2*len(prices))
+	for _, price := range prices {
+		values = append(values, "(?,?)")
+		args = append(args, testKey(price.ID), price.ExpirationDate.UTC())
+	}
+	return mock.ExpectExec(`
+			INSERT INTO prices_ids (id, expiration_date) VALUES
+			` + strings.Join(values, ",") + `
+			ON DUPLICATE KEY UPDATE
+				prices_ids.id = prices_ids.id
+		`).
+		WithArgs(args...)
+}
+
+// expectCreatePrices - expects the prices to be added to the prices table through the ids table.
+func expectCreatePrices(mock sqlmock.Sqlmock, prices ...*models.Price) *sqlmock.ExpectedExec {
+	rows := make([]string, 0, len(prices))
+	args := make([]driver.Value, 0, 4*len(prices))
+	for _, price := range prices {
+		rows = append(rows, "ROW(?,?,?,?)")
+		args = append(args, testKey(price.ID), testRaw(price.ID), price.Price, price.ExpirationDate.UTC())
+	}
+	return mock.ExpectExec(`
+			INSERT INTO prices (id, raw_id, price, expiration_date)
+			SELECT v.id, v.raw_id, v.price, v.expiration_date FROM (VALUES
+			` + strings.Join(rows, ",") + `
+			) v (id, raw_id, price, expiration_date)
+			JOIN prices_ids i ON i.id = v.id AND i.expiration_date = v.expiration_date
+			ON DUPLICATE KEY UPDATE
+				prices.id = prices.id
+		`).
+		WithArgs(args...)
+}
+
+// expectMerge - expects staged prices of the import to be merged to the prices table through the ids table.
+func expectMerge(mock sqlmock.Sqlmock, importID driver.Value, affected int64) {
+	mock.ExpectExec(mergeIDsQuery).WithArgs(importID).WillReturnResult(sqlmock.NewResult(0, affected))
+	mock.ExpectExec(mergePricesQuery).WithArgs(importID).WillReturnResult(sqlmock.NewResult(0, affected))
+	mock.ExpectExec(dropStagedQuery).WithArgs(importID).WillReturnResult(sqlmock.NewResult(0, affected))
+}
+
+func testKey(id string) []byte {
+	key, _ := priceKey(id)
+	return key
+}
+
+func testRaw(id string) sql.NullString {
+	_, raw := priceKey(id)
+	return raw
+}
+
 func TestMysqlPrices_CreateMany(t *testing.T) {
 	repo, mock := newTestMysqlPrices(t)
 	now := time.Now()
@@ -36,38 +127,230 @@ func TestMysqlPrices_CreateMany(t *testing.T) {
 			ExpirationDate: now.AddDate(0, 0, 2),
 		},
 	}
-	expectedQuery := `
-			INSERT INTO prices (id, price, expiration_date) VALUES
-			(?,?,?),(?,?,?)
+	expectCreateMany(mock, testData...)
+
+	err := repo.CreateMany(context.Background(), testData)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMysqlPrices_CreateMany_SameID(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+	now := time.Now()
+	later := &models.Price{ID: "test_id", Price: decimal.NewFromFloat(3.14), ExpirationDate: now.AddDate(0, 0, 2)}
+	earlier := &models.Price{ID: "test_id", Price: decimal.NewFromFloat(2.71), ExpirationDate: now.AddDate(0, 0, 1)}
+	mock.ExpectBegin()
+	expectCreateIDs(mock, earlier).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectCreatePrices(mock, later, earlier).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := repo.CreateMany(context.Background(), []*models.Price{later, earlier})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMysqlPrices_CreateMany_Errors(t *testing.T) {
+	testCases := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{name: "deadlock", err: &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}, transient: true},
+		{name: "lock wait timeout", err: &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, transient: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, transient: true},
+		{name: "invalid connection", err: mysql.ErrInvalidConn, transient: true},
+		{name: "duplicate column", err: &mysql.MySQLError{Number: 1060, Message: "Duplicate column name"}, transient: false},
+		{name: "unknown error", err: fmt.Errorf("unknown error"), transient: false},
+		{name: "context deadline", err: context.DeadlineExceeded, transient: false},
+		{name: "context deadline of connection", err: &net.OpError{Op: "read", Net: "tcp", Err: context.DeadlineExceeded}, transient: false},
+		{name: "context canceled", err: context.Canceled, transient: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo, mock := newTestMysqlPrices(t)
+			price := newTestPrice()
+			mock.ExpectBegin()
+			expectCreateIDs(mock, price).WillReturnError(tc.err)
+			mock.ExpectRollback()
+
+			err := repo.CreateMany(context.Background(), []*models.Price{price})
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.transient, errors.ErrorIs(err, errors.ErrTransient))
+		})
+	}
+}
+
+func TestMysqlPrices_ImportFile(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	repo := &MySQLPrices{
+		db: db,
+	}
+	testPath := filepath.Join(t.TempDir(), "test.csv")
+	testData := "" +
+		"test_id_1,3.14,2023-08-24 10:01:40 +0000 UTC\n" +
+		"test_id_2,2.71,2023-08-24 10:01:40 +0000 UTC\n" +
+		"test_id_1,1.41,2023-08-24 10:01:40 +0000 UTC\n"
+	err = os.WriteFile(testPath, []byte(testData), 0644)
+	assert.NoError(t, err)
+
+	mock.ExpectExec(`LOAD DATA CONCURRENT LOCAL INFILE 'Reader::prices_import_\d+'\s+IGNORE\s+INTO TABLE prices_imports`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectQuery(`SHOW WARNINGS`).
+		WillReturnRows(sqlmock.NewRows([]string{"Level", "Code", "Message"}))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO prices_ids`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO prices \(id, raw_id, price, expiration_date\)`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM prices_imports`).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	res, err := repo.ImportFile(context.Background(), testPath)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), res.RowsAffected)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMysqlPrices_ImportFile_MergeFailed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	repo := &MySQLPrices{
+		db: db,
+	}
+	testPath := filepath.Join(t.TempDir(), "test.csv")
+	err = os.WriteFile(testPath, []byte("test_id_1,3.14,2023-08-24 10:01:40 +0000 UTC\n"), 0644)
+	assert.NoError(t, err)
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+
+	mock.ExpectExec(`LOAD DATA CONCURRENT LOCAL INFILE`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SHOW WARNINGS`).WillReturnRows(sqlmock.NewRows([]string{"Level", "Code", "Message"}))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO prices_ids`).WillReturnError(deadlock)
+	mock.ExpectRollback()
+	mock.ExpectExec(`DELETE FROM prices_imports`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err = repo.ImportFile(context.Background(), testPath)
+	assert.ErrorIs(t, err, deadlock)
+	assert.True(t, errors.ErrorIs(err, errors.ErrTransient))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMysqlPrices_StageMany(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+	price := newTestPrice()
+	mock.ExpectExec(`
+			INSERT INTO prices_imports (import_id, id, raw_id, price, expiration_date) VALUES
+			(?,?,?,?,?)
 			ON DUPLICATE KEY UPDATE
 				id = id
-		`
+		`).
+		WithArgs("abcd", testKey(price.ID), testRaw(price.ID), price.Price, price.ExpirationDate.UTC()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectExec(expectedQuery).WithArgs(
-		testData[0].ID, testData[0].Price, testData[0].ExpirationDate,
-		testData[1].ID, testData[1].Price, testData[1].ExpirationDate,
-	).WillReturnResult(sqlmock.NewResult(0, 2))
+	err := repo.StageMany(context.Background(), "abcd", []*models.Price{price})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
 
-	err := repo.CreateMany(context.Background(), testData)
+func TestMysqlPrices_StageFile_BadImportID(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+
+	_, err := repo.StageFile(context.Background(), "'; DROP TABLE prices; --", "test.csv", 0, 0)
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMysqlPrices_importReader_BadTarget(t *testing.T) {
+	testCases := []struct {
+		name     string
+		table    string
+		importID string
+	}{
+		{name: "import id is not hex", table: importsTable, importID: "'; DROP TABLE prices; --"},
+		{name: "no import id", table: importsTable, importID: ""},
+		{name: "import id of staging table", table: stagingTable, importID: "abcd"},
+		{name: "unknown table", table: "prices; DROP TABLE prices", importID: ""},
+		{name: "prices table", table: pricesTable, importID: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo, mock := newTestMysqlPrices(t)
+
+			_, err := repo.importReader(context.Background(), tc.table, tc.importID, "test.csv", strings.NewReader("id,1,2024-01-01\n"))
+			assert.Error(t, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMysqlPrices_CommitImport(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+	mock.ExpectBegin()
+	expectMerge(mock, "abcd", 2)
+	mock.ExpectCommit()
+
+	affected, err := repo.CommitImport(context.Background(), "abcd")
 	assert.NoError(t, err)
+	assert.Equal(t, int64(2), affected)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestMysqlPrices_ImportFile(t *testing.T) {
+func TestMysqlPrices_CommitImport_Error(t *testing.T) {
 	repo, mock := newTestMysqlPrices(t)
-	testPath := "test/test.csv"
-	expectedQuery := fmt.Sprintf(`
-		LOAD DATA CONCURRENT LOCAL INFILE '%s'
-		IGNORE
-		INTO TABLE prices
-		FIELDS TERMINATED BY ','
-		LINES TERMINATED BY '\n'
-		(id,price,expiration_date)
-	`, testPath)
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+	mock.ExpectBegin()
+	mock.ExpectExec(mergeIDsQuery).WithArgs("abcd").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(mergePricesQuery).WithArgs("abcd").WillReturnError(deadlock)
+	mock.ExpectRollback()
 
-	mock.ExpectExec(expectedQuery).WithArgs().WillReturnResult(sqlmock.NewResult(0, 2))
+	_, err := repo.CommitImport(context.Background(), "abcd")
+	assert.ErrorIs(t, err, deadlock)
+	assert.True(t, errors.ErrorIs(err, errors.ErrTransient))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMysqlPrices_StaleImports(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+	mock.ExpectQuery(getStaleImportsQuery).WithArgs(int64(3600)).
+		WillReturnRows(sqlmock.NewRows([]string{"import_id"}).AddRow("abcd").AddRow("ef01"))
+
+	stale, err := repo.StaleImports(context.Background(), time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"abcd", "ef01"}, stale)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestKeyedReader(t *testing.T) {
+	data := "" +
+		"d65d3cba-40c7-11ee-afc6-a45e60d0762b,3.14,2023-08-24 10:01:40 +0000 UTC\n" +
+		"\n" +
+		"test_id_1,2.71,2023-08-24 10:01:40 +0200 CEST\n" +
+		"test_id_2,1.41,bad_date"
+	expected := "" +
+		"d65d3cba40c711eeafc6a45e60d0762b,,3.14,2023-08-24 10:01:40\n" +
+		hex.EncodeToString(testKey("test_id_1")) + ",test_id_1,2.71,2023-08-24 08:01:40\n" +
+		hex.EncodeToString(testKey("test_id_2")) + ",test_id_2,1.41,bad_date"
 
-	err := repo.ImportFile(context.Background(), testPath)
+	res, err := io.ReadAll(newKeyedReader(strings.NewReader(data)))
 	assert.NoError(t, err)
+	assert.Equal(t, expected, string(res))
+}
+
+func TestLineCounter(t *testing.T) {
+	testCases := []struct {
+		data     string
+		expected int64
+	}{
+		{data: "", expected: 0},
+		{data: "a\n", expected: 1},
+		{data: "a\nb", expected: 2},
+		{data: "a\nb\n", expected: 2},
+	}
+	for _, tc := range testCases {
+		c := &lineCounter{r: strings.NewReader(tc.data)}
+		_, err := io.Copy(io.Discard, c)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, c.count(), tc.data)
+	}
 }
 
 func TestMysqlPrices_Get(t *testing.T) {
@@ -78,19 +361,431 @@ func TestMysqlPrices_Get(t *testing.T) {
 		ExpirationDate: time.Now(),
 	}
 
+	mock.ExpectPrepare(testGetQuery).
+		ExpectQuery().
+		WithArgs(testKey(expectedPrice.ID)).
+		WillReturnRows(testPriceRows(expectedPrice))
+	mock.ExpectQuery(testGetQuery).
+		WithArgs(testKey(expectedPrice.ID)).
+		WillReturnRows(testPriceRows())
+
+	res, err := repo.Get(context.Background(), expectedPrice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedPrice, res)
+
+	_, err = repo.Get(context.Background(), expectedPrice.ID)
+	assert.ErrorIs(t, err, errors.ErrPriceNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMysqlPrices_Get_UUID(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+	expectedPrice := &models.Price{
+		ID:             "d65d3cba-40c7-11ee-afc6-a45e60d0762b",
+		Price:          decimal.NewFromFloat(3.14),
+		ExpirationDate: time.Now(),
+	}
+	key := []byte{0xd6, 0x5d, 0x3c, 0xba, 0x40, 0xc7, 0x11, 0xee, 0xaf, 0xc6, 0xa4, 0x5e, 0x60, 0xd0, 0x76, 0x2b}
+
+	mock.ExpectPrepare(testGetQuery).
+		ExpectQuery().
+		WithArgs(key).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "raw_id", "price", "expiration_date"}).
+				AddRow(key, nil, expectedPrice.Price, expectedPrice.ExpirationDate),
+		)
+
+	res, err := repo.Get(context.Background(), expectedPrice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedPrice, res)
+}
+
+func TestMysqlPrices_GetMany(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+	expectedPrices := []*models.Price{
+		{
+			ID:             "test_id_1",
+			Price:          decimal.NewFromFloat(3.14),
+			ExpirationDate: time.Now(),
+		},
+		{
+			ID:             "test_id_2",
+			Price:          decimal.NewFromFloat(2.71828),
+			ExpirationDate: time.Now(),
+		},
+	}
+
 	expectedQuery := `
-			SELECT id, price, expiration_date FROM prices
-			WHERE id = ?
+			SELECT p.id, p.raw_id, p.price, p.expiration_date FROM prices_ids i
+			JOIN prices p ON p.id = i.id AND p.expiration_date = i.expiration_date
+			WHERE i.id IN (?,?)
+		`
+
+	mock.ExpectQuery(expectedQuery).
+		WithArgs(testKey(expectedPrices[0].ID), testKey(expectedPrices[1].ID)).
+		WillReturnRows(testPriceRows(expectedPrices...))
+
+	res, err := repo.GetMany(context.Background(), []string{expectedPrices[0].ID, expectedPrices[1].ID})
+	assert.NoError(t, err)
+	assert.Equal(t, expectedPrices, res)
+}
+
+func TestMysqlPrices_List(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+	expectedPrice := &models.Price{
+		ID:             "test_id_2",
+		Price:          decimal.NewFromFloat(3.14),
+		ExpirationDate: time.Now(),
+	}
+
+	expectedQuery := `
+			SELECT p.id, p.raw_id, p.price, p.expiration_date FROM prices_ids i
+			JOIN prices p ON p.id = i.id AND p.expiration_date = i.expiration_date
+			WHERE i.id > ?
+			ORDER BY i.id
+			LIMIT ?
+		`
+
+	mock.ExpectQuery(expectedQuery).
+		WithArgs(testKey("test_id_1"), 1).
+		WillReturnRows(testPriceRows(expectedPrice))
+
+	res, err := repo.List(context.Background(), "test_id_1", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []*models.Price{expectedPrice}, res)
+}
+
+func TestMysqlPrices_DeleteMany(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+	mock.ExpectBegin()
+	for _, table := range []string{"prices", "prices_ids"} {
+		mock.ExpectExec(`
+			DELETE FROM `+table+`
+			WHERE id IN (?,?)
+		`).WithArgs(testKey("test_id_1"), testKey("test_id_2")).WillReturnResult(sqlmock.NewResult(0, 2))
+	}
+	mock.ExpectCommit()
+
+	err := repo.DeleteMany(context.Background(), []string{"test_id_1", "test_id_2"})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMysqlPrices_ListExpired(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+	before := time.Now()
+	expectedPrice := &models.Price{
+		ID:             "test_id_1",
+		Price:          decimal.NewFromFloat(3.14),
+		ExpirationDate: before.AddDate(0, 0, -1),
+	}
+
+	expectedQuery := `
+			SELECT id, raw_id, price, expiration_date FROM prices
+			WHERE expiration_date < ?
+			ORDER BY expiration_date
+			LIMIT ?
+		`
+
+	mock.ExpectQuery(expectedQuery).
+		WithArgs(before, 10).
+		WillReturnRows(testPriceRows(expectedPrice))
+
+	res, err := repo.ListExpired(context.Background(), before, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []*models.Price{expectedPrice}, res)
+}
+
+func TestMysqlPrices_ListPartitions(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+	expectedQuery := `
+			SELECT PARTITION_NAME, PARTITION_DESCRIPTION FROM information_schema.PARTITIONS
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'prices' AND PARTITION_NAME IS NOT NULL
+			ORDER BY PARTITION_ORDINAL_POSITION
 		`
 
 	mock.ExpectQuery(expectedQuery).
-		WithArgs(expectedPrice.ID).
 		WillReturnRows(
-			sqlmock.NewRows([]string{"id", "price", "expiration_date"}).
-				AddRow(expectedPrice.ID, expectedPrice.Price, expectedPrice.ExpirationDate),
+			sqlmock.NewRows([]string{"PARTITION_NAME", "PARTITION_DESCRIPTION"}).
+				AddRow("p202308", "'2023-09-01 00:00:00'").
+				AddRow("p_future", "MAXVALUE"),
 		)
 
-	res, err := repo.Get(context.Background(), expectedPrice.ID)
+	res, err := repo.ListPartitions(context.Background())
 	assert.NoError(t, err)
-	assert.Equal(t, expectedPrice, res)
+	assert.Equal(t, []models.Partition{
+		{Name: "p202308", LessThan: time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "p_future"},
+	}, res)
+}
+
+func TestMysqlPrices_AddPartitions(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+	expectedQuery := `
+		ALTER TABLE prices
+		REORGANIZE PARTITION p_future INTO (PARTITION p202308 VALUES LESS THAN ('2023-09-01 00:00:00'), PARTITION p202309 VALUES LESS THAN ('2023-10-01 00:00:00'), PARTITION p_future VALUES LESS THAN (MAXVALUE))
+	`
+
+	mock.ExpectExec(expectedQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := repo.AddPartitions(context.Background(), []models.Partition{
+		{Name: "p202308", LessThan: time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "p202309", LessThan: time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	assert.NoError(t, err)
+}
+
+func TestMysqlPrices_DropPartitions(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+	deleteIDsQuery := `
+			DELETE FROM prices_ids
+			WHERE expiration_date >= ? AND expiration_date < ?
+			LIMIT ?
+		`
+	expectedQuery := `
+		ALTER TABLE prices
+		DROP PARTITION p202307, p202308
+	`
+	july := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+	august := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`
+			SELECT PARTITION_NAME, PARTITION_DESCRIPTION FROM information_schema.PARTITIONS
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'prices' AND PARTITION_NAME IS NOT NULL
+			ORDER BY PARTITION_ORDINAL_POSITION
+		`).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"PARTITION_NAME", "PARTITION_DESCRIPTION"}).
+				AddRow("p202307", "'2023-08-01 00:00:00'").
+				AddRow("p202308", "'2023-09-01 00:00:00'").
+				AddRow("p_future", "MAXVALUE"),
+		)
+	mock.ExpectExec(deleteIDsQuery).WithArgs(time.Time{}, july, dropIDsBatchSize).
+		WillReturnResult(sqlmock.NewResult(0, dropIDsBatchSize))
+	mock.ExpectExec(deleteIDsQuery).WithArgs(time.Time{}, july, dropIDsBatchSize).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(deleteIDsQuery).WithArgs(july, august, dropIDsBatchSize).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(expectedQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := repo.DropPartitions(context.Background(), []string{"p202307", "p202308"})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMysqlPrices_MergePartitions(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+	expectedQuery := `
+		ALTER TABLE prices
+		REORGANIZE PARTITION p202307, p202308 INTO (PARTITION p202308 VALUES LESS THAN ('2023-09-01 00:00:00'))
+	`
+
+	mock.ExpectExec(expectedQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := repo.MergePartitions(context.Background(), []models.Partition{
+		{Name: "p202307", LessThan: time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "p202308", LessThan: time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMysqlPrices_SwapStaging(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+
+	mock.ExpectExec("DROP TABLE IF EXISTS prices_staging").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE prices_staging LIKE prices").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COUNT(*) FROM prices_staging").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(42))
+	mock.ExpectExec("DROP TABLE IF EXISTS prices_ids_staging").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE prices_ids_staging LIKE prices_ids").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO prices_ids_staging (id, expiration_date) SELECT id, MIN(expiration_date) FROM prices_staging GROUP BY id").
+		WillReturnResult(sqlmock.NewResult(0, 40))
+	mock.ExpectExec("DELETE s FROM prices_staging s JOIN prices_ids_staging i ON i.id = s.id WHERE s.expiration_date <> i.expiration_date").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DROP TABLE IF EXISTS prices_previous, prices_ids_previous").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("RENAME TABLE prices TO prices_previous, prices_staging TO prices, prices_ids TO prices_ids_previous, prices_ids_staging TO prices_ids").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := repo.CreateStaging(context.Background())
+	assert.NoError(t, err)
+	count, err := repo.CountStaging(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), count)
+	err = repo.SwapStaging(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMysqlPrices_RollbackSwap(t *testing.T) {
+	repo, mock := newTestMysqlPrices(t)
+	expectedQuery := `
+			SELECT COUNT(*) FROM information_schema.TABLES
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+		`
+
+	mock.ExpectQuery(expectedQuery).
+		WithArgs("prices_previous").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+	mock.ExpectExec("DROP TABLE IF EXISTS prices_staging, prices_ids_staging").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("RENAME TABLE prices TO prices_staging, prices_previous TO prices, prices_ids TO prices_ids_staging, prices_ids_previous TO prices_ids").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := repo.RollbackSwap(context.Background())
+	assert.NoError(t, err)
+
+	mock.ExpectQuery(expectedQuery).
+		WithArgs("prices_previous").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(0))
+
+	err = repo.RollbackSwap(context.Background())
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// newTestMySQLSchema - creates a dedicated schema in MySQL at the DSN from the env, migrates it
+// and drops it once the test is done, so tests never touch the prices of the DSN schema.
+func newTestMySQLSchema(tb testing.TB, env string) *MySQLPrices {
+	dsn := os.Getenv(env)
+	if dsn == "" {
+		tb.Skipf("%s is not set", env)
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	db, err := sql.Open(config.StorageMySQL, dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer db.Close()
+	schema := fmt.Sprintf("prices_test_%d", time.Now().UnixNano())
+	if _, err := db.Exec("CREATE DATABASE " + schema); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		cleanupDB, err := sql.Open(config.StorageMySQL, dsn)
+		if err != nil {
+			tb.Error(err)
+			return
+		}
+		defer cleanupDB.Close()
+		if _, err := cleanupDB.Exec("DROP DATABASE " + schema); err != nil {
+			tb.Error(err)
+		}
+	})
+
+	cfg.DBName = schema
+	storage := config.Storage{Type: config.StorageMySQL, DSN: cfg.FormatDSN(), MaxConnections: 10}
+	if err := migrations.MigrateDB(storage); err != nil {
+		tb.Fatal(err)
+	}
+	repo, err := NewMySQLPrices(storage)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		_ = repo.Close()
+	})
+	return repo
+}
+
+// TestMysqlPrices_Reimport - needs MySQL at TEST_MYSQL_DSN, e.g.:
+// TEST_MYSQL_DSN='root:root@tcp(localhost:3306)/?parseTime=true' go test -run MysqlPrices_Reimport ./pkg/repository
+func TestMysqlPrices_Reimport(t *testing.T) {
+	repo := newTestMySQLSchema(t, "TEST_MYSQL_DSN")
+	ctx := context.Background()
+	first := &models.Price{
+		ID:             "test_id_1",
+		Price:          decimal.NewFromFloat(3.14),
+		ExpirationDate: time.Date(2030, 1, 15, 10, 0, 0, 0, time.UTC),
+	}
+	again := &models.Price{
+		ID:             first.ID,
+		Price:          decimal.NewFromFloat(2.71),
+		ExpirationDate: time.Date(2031, 6, 15, 10, 0, 0, 0, time.UTC),
+	}
+	assert.NoError(t, repo.AddPartitions(ctx, []models.Partition{
+		{Name: "p203001", LessThan: time.Date(2030, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "p203106", LessThan: time.Date(2031, 7, 1, 0, 0, 0, 0, time.UTC)},
+	}))
+	assert.NoError(t, repo.CreateMany(ctx, []*models.Price{first}))
+
+	testPath := filepath.Join(t.TempDir(), "test.csv")
+	err := os.WriteFile(testPath, []byte(fmt.Sprintf("%s,%s,%s\n", again.ID, again.Price, again.ExpirationDate)), 0644)
+	assert.NoError(t, err)
+	res, err := repo.ImportFile(ctx, testPath)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), res.RowsAffected)
+	assert.NoError(t, repo.CreateMany(ctx, []*models.Price{again}))
+
+	var count int
+	assert.NoError(t, repo.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM prices WHERE id = ?", testKey(first.ID)).Scan(&count))
+	assert.Equal(t, 1, count)
+	price, err := repo.Get(ctx, first.ID)
+	assert.NoError(t, err)
+	assert.True(t, first.Price.Equal(price.Price))
+	assert.True(t, first.ExpirationDate.Equal(price.ExpirationDate))
+}
+
+// BenchmarkMysqlPrices_Get - compares lookups by BINARY(16) keys with a prepared statement to lookups
+// without it and to lookups by VARCHAR(255) ids, needs MySQL at BENCH_MYSQL_DSN, the prices are written
+// to a dedicated schema, which is dropped once the benchmark is done, e.g.:
+// BENCH_MYSQL_DSN='root:root@tcp(localhost:3306)/?parseTime=true' go test -run '^$' -bench MysqlPrices_Get ./pkg/repository
+func BenchmarkMysqlPrices_Get(b *testing.B) {
+	const (
+		pricesCount     = 100000
+		varcharTable    = "bench_prices_varchar"
+		varcharGetQuery = "SELECT id, price, expiration_date FROM " + varcharTable + " WHERE id = ?"
+	)
+	ctx := context.Background()
+	repo := newTestMySQLSchema(b, "BENCH_MYSQL_DSN")
+
+	_, err := repo.db.ExecContext(ctx, "CREATE TABLE "+varcharTable+" (id VARCHAR(255) PRIMARY KEY, price DECIMAL(20, 10), expiration_date DATETIME)")
+	assert.NoError(b, err)
+
+	ids := make([]string, 0, pricesCount)
+	for len(ids) < pricesCount {
+		batch := make([]*models.Price, 0, 1000)
+		values := bqb.Q()
+		for i := 0; i < cap(batch); i++ {
+			price := &models.Price{ID: uuid.NewString(), Price: decimal.NewFromInt(int64(i)), ExpirationDate: time.Now().AddDate(0, 0, 1)}
+			batch = append(batch, price)
+			values.Comma("(?,?,?)", price.ID, price.Price, price.ExpirationDate)
+			ids = append(ids, price.ID)
+		}
+		assert.NoError(b, repo.CreateMany(ctx, batch))
+		query, args, err := bqb.New("INSERT INTO "+varcharTable+" (id, price, expiration_date) VALUES ?", values).ToMysql()
+		assert.NoError(b, err)
+		_, err = repo.db.ExecContext(ctx, query, args...)
+		assert.NoError(b, err)
+	}
+
+	b.Run("binary_prepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := repo.Get(ctx, ids[i%len(ids)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("binary_unprepared", func(b *testing.B) {
+		var price models.Price
+		var key []byte
+		var raw sql.NullString
+		for i := 0; i < b.N; i++ {
+			row := repo.db.QueryRowContext(ctx, getPriceQuery, testKey(ids[i%len(ids)]))
+			if err := row.Scan(&key, &raw, &price.Price, &price.ExpirationDate); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("varchar_unprepared", func(b *testing.B) {
+		var price models.Price
+		for i := 0; i < b.N; i++ {
+			row := repo.db.QueryRowContext(ctx, varcharGetQuery, ids[i%len(ids)])
+			if err := row.Scan(&price.ID, &price.Price, &price.ExpirationDate); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
 }
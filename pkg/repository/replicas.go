@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"prices/pkg/config"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultReplicaCheckEvery = 5 * time.Second
+	replicaPingTimeout       = time.Second
+)
+
+type (
+	replica struct {
+		db      *sql.DB
+		healthy atomic.Bool
+	}
+
+	// replicaSet - routes reads between healthy read replicas and falls back to the primary
+	// when there are no healthy replicas. Replicas may lag behind the primary.
+	replicaSet struct {
+		primary    *sql.DB
+		replicas   []*replica
+		next       atomic.Uint64
+		checkEvery time.Duration
+		stop       chan struct{}
+		stopOnce   sync.Once
+	}
+)
+
+func newReplicaSet(primary *sql.DB, config config.Storage) (*replicaSet, error) {
+	s := &replicaSet{
+		primary:    primary,
+		checkEvery: config.ReplicaCheckEveryDuration,
+		stop:       make(chan struct{}),
+	}
+	if s.checkEvery <= 0 {
+		s.checkEvery = defaultReplicaCheckEvery
+	}
+	for _, dsn := range config.Replicas {
+		db, err := sql.Open(config.Type, dsn)
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("can't establish connection to the data storage replica: %w", err)
+		}
+		db.SetMaxOpenConns(config.MaxConnections)
+		db.SetMaxIdleConns(config.MaxConnections)
+		s.replicas = append(s.replicas, &replica{db: db})
+	}
+	s.checkHealth()
+	if len(s.replicas) > 0 {
+		go s.run()
+	}
+	return s, nil
+}
+
+// reader - returns DB to read from.
+func (s *replicaSet) reader(ctx context.Context) *sql.DB {
+	if len(s.replicas) == 0 {
+		return s.primary
+	}
+	n := uint64(len(s.replicas))
+	start := s.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		r := s.replicas[(start+i)%n]
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+	return s.primary
+}
+
+// failed - marks replica as unhealthy until the next successful health check.
+func (s *replicaSet) failed(db *sql.DB) {
+	for _, r := range s.replicas {
+		if r.db == db {
+			r.healthy.Store(false)
+		}
+	}
+}
+
+func (s *replicaSet) run() {
+	ticker := time.NewTicker(s.checkEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.checkHealth()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *replicaSet) checkHealth() {
+	wg := &sync.WaitGroup{}
+	for _, r := range s.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), replicaPingTimeout)
+			defer cancel()
+			r.healthy.Store(r.db.PingContext(ctx) == nil)
+		}(r)
+	}
+	wg.Wait()
+}
+
+func (s *replicaSet) close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	for _, r := range s.replicas {
+		_ = r.db.Close()
+	}
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"prices/pkg/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+const testGetQuery = `
+			SELECT p.id, p.raw_id, p.price, p.expiration_date FROM prices_ids i
+			JOIN prices p ON p.id = i.id AND p.expiration_date = i.expiration_date
+			WHERE i.id = ?
+		`
+
+func newTestReplicatedMysqlPrices(t *testing.T) (*MySQLPrices, sqlmock.Sqlmock, sqlmock.Sqlmock) {
+	db, primary, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	replicaDB, replicaMock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	r := &replica{db: replicaDB}
+	r.healthy.Store(true)
+	repo := &MySQLPrices{
+		db: db,
+		replicas: &replicaSet{
+			primary:  db,
+			replicas: []*replica{r},
+			stop:     make(chan struct{}),
+		},
+	}
+	return repo, primary, replicaMock
+}
+
+func expectGet(mock sqlmock.Sqlmock, price *models.Price) {
+	mock.ExpectPrepare(testGetQuery).
+		ExpectQuery().
+		WithArgs(testKey(price.ID)).
+		WillReturnRows(testPriceRows(price))
+}
+
+func newTestPrice() *models.Price {
+	return &models.Price{
+		ID:             "test_id_1",
+		Price:          decimal.NewFromFloat(3.14),
+		ExpirationDate: time.Now(),
+	}
+}
+
+func TestMysqlPrices_Get_Replica(t *testing.T) {
+	repo, primary, replica := newTestReplicatedMysqlPrices(t)
+	expectedPrice := newTestPrice()
+	expectGet(replica, expectedPrice)
+
+	res, err := repo.Get(context.Background(), expectedPrice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedPrice, res)
+	assert.NoError(t, replica.ExpectationsWereMet())
+	assert.NoError(t, primary.ExpectationsWereMet())
+}
+
+func TestMysqlPrices_Get_UnhealthyReplica(t *testing.T) {
+	repo, primary, replica := newTestReplicatedMysqlPrices(t)
+	repo.replicas.replicas[0].healthy.Store(false)
+	expectedPrice := newTestPrice()
+	expectGet(primary, expectedPrice)
+
+	res, err := repo.Get(context.Background(), expectedPrice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedPrice, res)
+	assert.NoError(t, replica.ExpectationsWereMet())
+	assert.NoError(t, primary.ExpectationsWereMet())
+}
+
+func TestMysqlPrices_Get_ReplicaFailover(t *testing.T) {
+	repo, primary, replica := newTestReplicatedMysqlPrices(t)
+	expectedPrice := newTestPrice()
+	replica.ExpectPrepare(testGetQuery).
+		ExpectQuery().
+		WithArgs(testKey(expectedPrice.ID)).
+		WillReturnError(fmt.Errorf("connection reset"))
+	expectGet(primary, expectedPrice)
+
+	res, err := repo.Get(context.Background(), expectedPrice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedPrice, res)
+	assert.False(t, repo.replicas.replicas[0].healthy.Load())
+	assert.NoError(t, replica.ExpectationsWereMet())
+	assert.NoError(t, primary.ExpectationsWereMet())
+}
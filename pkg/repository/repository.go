@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"prices/pkg/config"
+	"prices/pkg/files"
+	"prices/pkg/models"
+	"time"
+)
+
+type (
+	// Prices - storage of prices, implemented by every supported storage type.
+	Prices interface {
+		CreateMany(ctx context.Context, prices []*models.Price) error
+		Get(ctx context.Context, id string) (*models.Price, error)
+		GetMany(ctx context.Context, ids []string) ([]*models.Price, error)
+		List(ctx context.Context, after string, limit int) ([]*models.Price, error)
+		ListExpired(ctx context.Context, before time.Time, limit int) ([]*models.Price, error)
+		DeleteMany(ctx context.Context, ids []string) error
+		ImportFile(ctx context.Context, filePath string) (*models.ImportResult, error)
+		// ImportRange - imports length bytes of the file from offset, the range must be aligned to lines.
+		ImportRange(ctx context.Context, filePath string, offset int64, length int64) (*models.ImportResult, error)
+		Close() error
+	}
+
+	// Imports - prices of files imported atomically, they are staged by the import id of the file,
+	// and moved to the prices table at once when the import is committed, so they are never read before.
+	Imports interface {
+		// StageMany - stages prices of the import.
+		StageMany(ctx context.Context, importID string, prices []*models.Price) error
+		// StageFile - stages prices of the file, or of length bytes of the file from offset if length isn't 0.
+		StageFile(ctx context.Context, importID string, filePath string, offset int64, length int64) (*models.ImportResult, error)
+		// CommitImport - moves staged prices of the import to the prices table, returns how many of them were not duplicates.
+		CommitImport(ctx context.Context, importID string) (int64, error)
+		// RollbackImport - drops staged prices of the import.
+		RollbackImport(ctx context.Context, importID string) error
+		// StaleImports - returns ids of the imports that haven't staged prices for the age.
+		StaleImports(ctx context.Context, age time.Duration) ([]string, error)
+	}
+)
+
+// openRange - opens the file, or length bytes of the file from offset if length isn't 0.
+func openRange(filePath string, offset int64, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return files.Open(filePath)
+	}
+	return files.OpenRange(filePath, offset, length)
+}
+
+// NewPrices - creates prices repository for the storage type set in config.
+func NewPrices(storage config.Storage) (Prices, error) {
+	if len(storage.Shards) > 0 {
+		return NewShardedPrices(storage)
+	}
+	switch storage.Type {
+	case config.StorageMySQL:
+		return NewMySQLPrices(storage)
+	case config.StorageSQLite:
+		return NewSQLitePrices(storage)
+	case config.StorageIndex:
+		return NewIndexPrices(storage)
+	default:
+		return nil, fmt.Errorf("unsupported storage type=%s", storage.Type)
+	}
+}
+
+func queryPrices(ctx context.Context, db *sql.DB, query string, args []any) ([]*models.Price, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prices []*models.Price
+	for rows.Next() {
+		var price models.Price
+		if err := rows.Scan(&price.ID, &price.Price, &price.ExpirationDate); err != nil {
+			return nil, err
+		}
+		prices = append(prices, &price)
+	}
+
+	return prices, rows.Err()
+}
+
+// scanImportIDs - reads ids of imports from the rows.
+func scanImportIDs(rows *sql.Rows) ([]string, error) {
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("can't scan import id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't read import ids: %w", err)
+	}
+	return ids, nil
+}
+
+// lineCounter - counts lines of the data read through it.
+type lineCounter struct {
+	r     io.Reader
+	lines int64
+	last  byte
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.lines += int64(bytes.Count(p[:n], []byte{'\n'}))
+		c.last = p[n-1]
+	}
+	return n, err
+}
+
+// count - returns number of lines read, including the last line without a line break.
+func (c *lineCounter) count() int64 {
+	if c.last != 0 && c.last != '\n' {
+		return c.lines + 1
+	}
+	return c.lines
+}
@@ -0,0 +1,565 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"prices/pkg/config"
+	"prices/pkg/files"
+	"prices/pkg/models"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// shardVirtualNodes - how many points every shard has on the hash ring.
+	shardVirtualNodes = 256
+)
+
+type (
+	// ShardedPrices - prices spread between shards by consistent hashing of the price id.
+	// Every shard is a regular storage, and can have its own read replicas.
+	ShardedPrices struct {
+		names  []string
+		shards []Prices
+		ring   *hashRing
+	}
+
+	// hashRing - consistent hashing ring, adding or removing a shard only moves the ids owned by that shard.
+	hashRing struct {
+		points []uint64
+		owners []int
+	}
+)
+
+func NewShardedPrices(storage config.Storage) (*ShardedPrices, error) {
+	var (
+		names  []string
+		shards []Prices
+	)
+	for _, shardStorage := range storage.ShardStorages() {
+		shard, err := NewPrices(shardStorage)
+		if err != nil {
+			for _, s := range shards {
+				_ = s.Close()
+			}
+			return nil, fmt.Errorf("can't init shard=%s: %w", shardStorage.Name, err)
+		}
+		names = append(names, shardStorage.Name)
+		shards = append(shards, shard)
+	}
+	return newShardedPrices(names, shards)
+}
+
+func newShardedPrices(names []string, shards []Prices) (*ShardedPrices, error) {
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("shard name=%s is not unique", name)
+		}
+		seen[name] = true
+	}
+	return &ShardedPrices{
+		names:  names,
+		shards: shards,
+		ring:   newHashRing(names),
+	}, nil
+}
+
+func (r *ShardedPrices) CreateMany(ctx context.Context, prices []*models.Price) error {
+	byShard := make(map[int][]*models.Price)
+	for _, price := range prices {
+		shard := r.ring.owner(price.ID)
+		byShard[shard] = append(byShard[shard], price)
+	}
+	return r.each(byShardKeys(byShard), func(shard int) error {
+		if err := r.shards[shard].CreateMany(ctx, byShard[shard]); err != nil {
+			return fmt.Errorf("shard=%s: %w", r.names[shard], err)
+		}
+		return nil
+	})
+}
+
+// ImportFile - splits the file into a file per shard by the id column and imports them to the shards in parallel.
+// Results of the shards are added up.
+func (r *ShardedPrices) ImportFile(ctx context.Context, filePath string) (*models.ImportResult, error) {
+	f, err := files.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("can't open file=%s to import prices: %w", filePath, err)
+	}
+	defer f.Close()
+
+	return r.importReader(ctx, "", filePath, f)
+}
+
+// ImportRange - same as ImportFile, but splits length bytes of the file from offset, the range must be aligned to lines.
+func (r *ShardedPrices) ImportRange(ctx context.Context, filePath string, offset int64, length int64) (*models.ImportResult, error) {
+	f, err := files.OpenRange(filePath, offset, length)
+	if err != nil {
+		return nil, fmt.Errorf("can't open file=%s to import prices: %w", filePath, err)
+	}
+	defer f.Close()
+
+	return r.importReader(ctx, "", filePath, f)
+}
+
+// StageMany - stages prices of the import on the shards owning them.
+func (r *ShardedPrices) StageMany(ctx context.Context, importID string, prices []*models.Price) error {
+	byShard := make(map[int][]*models.Price)
+	for _, price := range prices {
+		shard := r.ring.owner(price.ID)
+		byShard[shard] = append(byShard[shard], price)
+	}
+	return r.each(byShardKeys(byShard), func(shard int) error {
+		imports, err := r.imports(shard)
+		if err != nil {
+			return err
+		}
+		if err := imports.StageMany(ctx, importID, byShard[shard]); err != nil {
+			return fmt.Errorf("shard=%s: %w", r.names[shard], err)
+		}
+		return nil
+	})
+}
+
+// StageFile - same as ImportFile or ImportRange, but stages the file per shard to the staged prices of the import.
+func (r *ShardedPrices) StageFile(ctx context.Context, importID string, filePath string, offset int64, length int64) (*models.ImportResult, error) {
+	f, err := openRange(filePath, offset, length)
+	if err != nil {
+		return nil, fmt.Errorf("can't open file=%s to import prices: %w", filePath, err)
+	}
+	defer f.Close()
+
+	return r.importReader(ctx, importID, filePath, f)
+}
+
+// CommitImport - commits the import on every shard in parallel, the import is atomic on every shard, but not across the shards,
+// so prices of a shard that fails to commit are missing until the import is committed again.
+func (r *ShardedPrices) CommitImport(ctx context.Context, importID string) (int64, error) {
+	mu := &sync.Mutex{}
+	var affected int64
+	err := r.each(r.all(), func(shard int) error {
+		imports, err := r.imports(shard)
+		if err != nil {
+			return err
+		}
+		n, err := imports.CommitImport(ctx, importID)
+		if err != nil {
+			return fmt.Errorf("shard=%s: %w", r.names[shard], err)
+		}
+		mu.Lock()
+		affected += n
+		mu.Unlock()
+		return nil
+	})
+	return affected, err
+}
+
+// RollbackImport - drops staged prices of the import on every shard.
+func (r *ShardedPrices) RollbackImport(ctx context.Context, importID string) error {
+	return r.each(r.all(), func(shard int) error {
+		imports, err := r.imports(shard)
+		if err != nil {
+			return err
+		}
+		if err := imports.RollbackImport(ctx, importID); err != nil {
+			return fmt.Errorf("shard=%s: %w", r.names[shard], err)
+		}
+		return nil
+	})
+}
+
+// StaleImports - returns ids of the imports that are stale on any of the shards.
+func (r *ShardedPrices) StaleImports(ctx context.Context, age time.Duration) ([]string, error) {
+	mu := &sync.Mutex{}
+	stale := make(map[string]bool)
+	err := r.each(r.all(), func(shard int) error {
+		imports, err := r.imports(shard)
+		if err != nil {
+			return err
+		}
+		ids, err := imports.StaleImports(ctx, age)
+		if err != nil {
+			return fmt.Errorf("shard=%s: %w", r.names[shard], err)
+		}
+		mu.Lock()
+		for _, id := range ids {
+			stale[id] = true
+		}
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(stale))
+	for id := range stale {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// importShard - imports the file of the shard, or stages it if the import id isn't empty.
+func (r *ShardedPrices) importShard(ctx context.Context, shard int, importID string, filePath string) (*models.ImportResult, error) {
+	if importID == "" {
+		return r.shards[shard].ImportFile(ctx, filePath)
+	}
+	imports, err := r.imports(shard)
+	if err != nil {
+		return nil, err
+	}
+	return imports.StageFile(ctx, importID, filePath, 0, 0)
+}
+
+// imports - returns the shard as Imports, all storages that can be sharded support atomic imports.
+func (r *ShardedPrices) imports(shard int) (Imports, error) {
+	imports, ok := r.shards[shard].(Imports)
+	if !ok {
+		return nil, fmt.Errorf("shard=%s doesn't support atomic imports", r.names[shard])
+	}
+	return imports, nil
+}
+
+// importReader - splits .CSV data read from f into a file per shard and imports them, or stages them if the import id isn't empty,
+// filePath is the file the data is read from.
+func (r *ShardedPrices) importReader(ctx context.Context, importID string, filePath string, f io.Reader) (*models.ImportResult, error) {
+	shardFiles, err := r.splitFile(filePath, f)
+	defer func() {
+		for _, shardFile := range shardFiles {
+			_ = os.Remove(shardFile)
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	mu := &sync.Mutex{}
+	result := &models.ImportResult{}
+	err = r.each(byShardKeys(shardFiles), func(shard int) error {
+		shardResult, err := r.importShard(ctx, shard, importID, shardFiles[shard])
+		mu.Lock()
+		result.Add(shardResult)
+		mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("shard=%s: %w", r.names[shard], err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (r *ShardedPrices) Get(ctx context.Context, id string) (*models.Price, error) {
+	return r.shards[r.ring.owner(id)].Get(ctx, id)
+}
+
+// GetMany - gets prices by ids from the shards that own them in parallel.
+func (r *ShardedPrices) GetMany(ctx context.Context, ids []string) ([]*models.Price, error) {
+	byShard := make(map[int][]string)
+	for _, id := range ids {
+		shard := r.ring.owner(id)
+		byShard[shard] = append(byShard[shard], id)
+	}
+
+	mu := &sync.Mutex{}
+	var prices []*models.Price
+	err := r.each(byShardKeys(byShard), func(shard int) error {
+		shardPrices, err := r.shards[shard].GetMany(ctx, byShard[shard])
+		if err != nil {
+			return fmt.Errorf("shard=%s: %w", r.names[shard], err)
+		}
+		mu.Lock()
+		prices = append(prices, shardPrices...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return prices, nil
+}
+
+// List - lists prices of every shard in parallel and merges them in the order of ids of the shards.
+func (r *ShardedPrices) List(ctx context.Context, after string, limit int) ([]*models.Price, error) {
+	mu := &sync.Mutex{}
+	var prices []*models.Price
+	err := r.each(r.all(), func(shard int) error {
+		shardPrices, err := r.shards[shard].List(ctx, after, limit)
+		if err != nil {
+			return fmt.Errorf("shard=%s: %w", r.names[shard], err)
+		}
+		mu.Lock()
+		prices = append(prices, shardPrices...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	less := lessID(r.shards[0])
+	sort.Slice(prices, func(i, j int) bool {
+		return less(prices[i].ID, prices[j].ID)
+	})
+	if len(prices) > limit {
+		prices = prices[:limit]
+	}
+
+	return prices, nil
+}
+
+// ListExpired - lists expired prices of every shard in parallel and merges them by expiration date.
+func (r *ShardedPrices) ListExpired(ctx context.Context, before time.Time, limit int) ([]*models.Price, error) {
+	mu := &sync.Mutex{}
+	var prices []*models.Price
+	err := r.each(r.all(), func(shard int) error {
+		shardPrices, err := r.shards[shard].ListExpired(ctx, before, limit)
+		if err != nil {
+			return fmt.Errorf("shard=%s: %w", r.names[shard], err)
+		}
+		mu.Lock()
+		prices = append(prices, shardPrices...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(prices, func(i, j int) bool {
+		return prices[i].ExpirationDate.Before(prices[j].ExpirationDate)
+	})
+	if len(prices) > limit {
+		prices = prices[:limit]
+	}
+
+	return prices, nil
+}
+
+func (r *ShardedPrices) DeleteMany(ctx context.Context, ids []string) error {
+	byShard := make(map[int][]string)
+	for _, id := range ids {
+		shard := r.ring.owner(id)
+		byShard[shard] = append(byShard[shard], id)
+	}
+	return r.each(byShardKeys(byShard), func(shard int) error {
+		if err := r.shards[shard].DeleteMany(ctx, byShard[shard]); err != nil {
+			return fmt.Errorf("shard=%s: %w", r.names[shard], err)
+		}
+		return nil
+	})
+}
+
+// Reshard - moves prices that are stored on a shard that doesn't own them to the owner shard.
+// Run it after adding shards to the config, shards that are removed from the config are passed as drained,
+// all of their prices are moved to the configured shards.
+// Returns how many prices were moved from every shard.
+func (r *ShardedPrices) Reshard(ctx context.Context, drained map[string]Prices, batchSize int) (map[string]int, error) {
+	moved := make(map[string]int)
+	for i, shard := range r.shards {
+		n, err := r.moveForeign(ctx, shard, i, batchSize)
+		moved[r.names[i]] = n
+		if err != nil {
+			return moved, fmt.Errorf("can't reshard shard=%s: %w", r.names[i], err)
+		}
+	}
+	for name, shard := range drained {
+		n, err := r.moveForeign(ctx, shard, -1, batchSize)
+		moved[name] = n
+		if err != nil {
+			return moved, fmt.Errorf("can't drain shard=%s: %w", name, err)
+		}
+	}
+	return moved, nil
+}
+
+// Shards - returns shards by their names.
+func (r *ShardedPrices) Shards() map[string]Prices {
+	shards := make(map[string]Prices, len(r.shards))
+	for i, shard := range r.shards {
+		shards[r.names[i]] = shard
+	}
+	return shards
+}
+
+func (r *ShardedPrices) Close() error {
+	var errs []error
+	for _, shard := range r.shards {
+		errs = append(errs, shard.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// moveForeign - moves prices of the shard with the given index that are owned by other shards.
+func (r *ShardedPrices) moveForeign(ctx context.Context, shard Prices, index int, batchSize int) (int, error) {
+	moved := 0
+	after := ""
+	for {
+		prices, err := shard.List(ctx, after, batchSize)
+		if err != nil {
+			return moved, err
+		}
+		if len(prices) == 0 {
+			return moved, nil
+		}
+		after = prices[len(prices)-1].ID
+
+		var (
+			foreign []*models.Price
+			ids     []string
+		)
+		for _, price := range prices {
+			if r.ring.owner(price.ID) != index {
+				foreign = append(foreign, price)
+				ids = append(ids, price.ID)
+			}
+		}
+		if len(foreign) == 0 {
+			continue
+		}
+		if err := r.CreateMany(ctx, foreign); err != nil {
+			return moved, err
+		}
+		if err := shard.DeleteMany(ctx, ids); err != nil {
+			return moved, err
+		}
+		moved += len(foreign)
+	}
+}
+
+// splitFile - writes rows of the file read from f to a temporary file per shard, returns paths of the files by shard.
+func (r *ShardedPrices) splitFile(filePath string, f io.Reader) (map[int]string, error) {
+	paths := make(map[int]string)
+	outs := make(map[int]*os.File)
+	writers := make(map[int]*csv.Writer)
+	closeAll := func() error {
+		var errs []error
+		for shard, w := range writers {
+			w.Flush()
+			errs = append(errs, w.Error(), outs[shard].Close())
+		}
+		return errors.Join(errs...)
+	}
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	for {
+		line, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			_ = closeAll()
+			return paths, fmt.Errorf("can't read file=%s to import prices: %w", filePath, err)
+		}
+		shard := r.ring.owner(line[0])
+		w, ok := writers[shard]
+		if !ok {
+			out, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+"."+r.names[shard]+".*.tmp")
+			if err != nil {
+				_ = closeAll()
+				return paths, fmt.Errorf("can't create file for shard=%s: %w", r.names[shard], err)
+			}
+			paths[shard] = out.Name()
+			outs[shard] = out
+			w = csv.NewWriter(out)
+			writers[shard] = w
+		}
+		if err := w.Write(line); err != nil {
+			_ = closeAll()
+			return paths, fmt.Errorf("can't write file for shard=%s: %w", r.names[shard], err)
+		}
+	}
+
+	if err := closeAll(); err != nil {
+		return paths, fmt.Errorf("can't write files for shards: %w", err)
+	}
+	return paths, nil
+}
+
+// each - runs fn for every shard in parallel.
+func (r *ShardedPrices) each(shards []int, fn func(shard int) error) error {
+	wg := &sync.WaitGroup{}
+	errs := make([]error, len(shards))
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i, shard int) {
+			defer wg.Done()
+			errs[i] = fn(shard)
+		}(i, shard)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (r *ShardedPrices) all() []int {
+	shards := make([]int, len(r.shards))
+	for i := range shards {
+		shards[i] = i
+	}
+	return shards
+}
+
+func byShardKeys[T any](byShard map[int]T) []int {
+	shards := make([]int, 0, len(byShard))
+	for shard := range byShard {
+		shards = append(shards, shard)
+	}
+	return shards
+}
+
+func newHashRing(names []string) *hashRing {
+	type point struct {
+		hash  uint64
+		owner int
+	}
+	points := make([]point, 0, len(names)*shardVirtualNodes)
+	for owner, name := range names {
+		for i := 0; i < shardVirtualNodes; i++ {
+			points = append(points, point{hash: hashKey(name + "#" + strconv.Itoa(i)), owner: owner})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+
+	ring := &hashRing{
+		points: make([]uint64, len(points)),
+		owners: make([]int, len(points)),
+	}
+	for i, p := range points {
+		ring.points[i] = p.hash
+		ring.owners[i] = p.owner
+	}
+	return ring
+}
+
+// owner - returns index of the shard that owns the key.
+func (h *hashRing) owner(key string) int {
+	hash := hashKey(key)
+	i := sort.Search(len(h.points), func(i int) bool {
+		return h.points[i] >= hash
+	})
+	if i == len(h.points) {
+		i = 0
+	}
+	return h.owners[i]
+}
+
+func hashKey(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
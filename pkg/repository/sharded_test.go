@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"prices/pkg/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestShardedPrices(t *testing.T, names ...string) (*ShardedPrices, map[string]*SQLitePrices) {
+	byName := make(map[string]*SQLitePrices)
+	shards := make([]Prices, 0, len(names))
+	for _, name := range names {
+		shard := newTestSQLitePrices(t)
+		byName[name] = shard
+		shards = append(shards, shard)
+	}
+	repo, err := newShardedPrices(names, shards)
+	assert.NoError(t, err)
+	return repo, byName
+}
+
+func newTestPrices(n int) []*models.Price {
+	now := time.Now().UTC().Truncate(time.Second)
+	prices := make([]*models.Price, 0, n)
+	for i := 0; i < n; i++ {
+		prices = append(prices, &models.Price{
+			ID:             uuid.NewString(),
+			Price:          decimal.NewFromInt(int64(i)),
+			ExpirationDate: now,
+		})
+	}
+	return prices
+}
+
+func countShard(t *testing.T, shard Prices) int {
+	prices, err := shard.List(context.Background(), "", 1000000)
+	assert.NoError(t, err)
+	return len(prices)
+}
+
+func TestHashRing_Owner(t *testing.T) {
+	ring := newHashRing([]string{"shard_0", "shard_1", "shard_2"})
+	counts := make(map[int]int)
+	for i := 0; i < 30000; i++ {
+		counts[ring.owner(uuid.NewString())]++
+	}
+	for shard := 0; shard < 3; shard++ {
+		assert.InDelta(t, 10000, counts[shard], 1500)
+	}
+
+	grown := newHashRing([]string{"shard_0", "shard_1", "shard_2", "shard_3"})
+	for i := 0; i < 1000; i++ {
+		id := uuid.NewString()
+		if owner := grown.owner(id); owner != 3 {
+			assert.Equal(t, ring.owner(id), owner)
+		}
+	}
+}
+
+func TestNewShardedPrices_DuplicatedName(t *testing.T) {
+	_, err := newShardedPrices([]string{"shard_0", "shard_0"}, []Prices{nil, nil})
+	assert.Error(t, err)
+}
+
+func TestShardedPrices_CreateMany(t *testing.T) {
+	repo, shards := newTestShardedPrices(t, "shard_0", "shard_1", "shard_2")
+	testData := newTestPrices(300)
+
+	err := repo.CreateMany(context.Background(), testData)
+	assert.NoError(t, err)
+
+	total := 0
+	for _, shard := range shards {
+		n := countShard(t, shard)
+		assert.NotZero(t, n)
+		total += n
+	}
+	assert.Equal(t, len(testData), total)
+
+	for _, expected := range testData {
+		res, err := repo.Get(context.Background(), expected.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, expected.ID, res.ID)
+	}
+}
+
+func TestShardedPrices_GetMany(t *testing.T) {
+	repo, _ := newTestShardedPrices(t, "shard_0", "shard_1", "shard_2")
+	testData := newTestPrices(30)
+	err := repo.CreateMany(context.Background(), testData)
+	assert.NoError(t, err)
+
+	ids := []string{testData[0].ID, testData[10].ID, testData[20].ID, "unknown_id"}
+	res, err := repo.GetMany(context.Background(), ids)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, ids[:3], []string{res[0].ID, res[1].ID, res[2].ID})
+	assert.Len(t, res, 3)
+}
+
+func TestShardedPrices_List(t *testing.T) {
+	repo, _ := newTestShardedPrices(t, "shard_0", "shard_1", "shard_2")
+	testData := newTestPrices(50)
+	err := repo.CreateMany(context.Background(), testData)
+	assert.NoError(t, err)
+
+	var ids []string
+	after := ""
+	for {
+		res, err := repo.List(context.Background(), after, 7)
+		assert.NoError(t, err)
+		if len(res) == 0 {
+			break
+		}
+		assert.LessOrEqual(t, len(res), 7)
+		for _, price := range res {
+			assert.Greater(t, price.ID, after)
+			after = price.ID
+			ids = append(ids, price.ID)
+		}
+	}
+	assert.Len(t, ids, len(testData))
+}
+
+func TestShardedPrices_DeleteMany(t *testing.T) {
+	repo, _ := newTestShardedPrices(t, "shard_0", "shard_1")
+	testData := newTestPrices(10)
+	err := repo.CreateMany(context.Background(), testData)
+	assert.NoError(t, err)
+
+	err = repo.DeleteMany(context.Background(), []string{testData[0].ID, testData[1].ID})
+	assert.NoError(t, err)
+	res, err := repo.List(context.Background(), "", 100)
+	assert.NoError(t, err)
+	assert.Len(t, res, 8)
+}
+
+func TestShardedPrices_ImportFile(t *testing.T) {
+	repo, shards := newTestShardedPrices(t, "shard_0", "shard_1", "shard_2")
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "test.csv")
+	testData := newTestPrices(100)
+	var data []byte
+	for _, price := range testData {
+		data = append(data, fmt.Sprintf("%s,%s,2023-08-24 10:01:40 +0000 UTC\n", price.ID, price.Price)...)
+	}
+	err := os.WriteFile(testFile, data, 0644)
+	assert.NoError(t, err)
+
+	result, err := repo.ImportFile(context.Background(), testFile)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(testData)), result.Records)
+	assert.Equal(t, int64(len(testData)), result.RowsAffected)
+
+	total := 0
+	for _, shard := range shards {
+		total += countShard(t, shard)
+	}
+	assert.Equal(t, len(testData), total)
+	for _, price := range testData {
+		_, err := repo.Get(context.Background(), price.ID)
+		assert.NoError(t, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestShardedPrices_StaleImports(t *testing.T) {
+	repo, shards := newTestShardedPrices(t, "a", "b")
+	ctx := context.Background()
+	prices := newTestPrices(1)
+
+	// Imports are stale if they are stale on any of the shards.
+	for name, importID := range map[string]string{"a": "1111", "b": "2222"} {
+		assert.NoError(t, shards[name].StageMany(ctx, "1111", prices))
+		assert.NoError(t, shards[name].StageMany(ctx, "2222", prices))
+		_, err := shards[name].db.ExecContext(ctx, "UPDATE prices_imports SET created_at = datetime('now', '-2 hours') WHERE import_id = ?", importID)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, shards["b"].StageMany(ctx, "3333", prices))
+
+	stale, err := repo.StaleImports(ctx, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1111", "2222"}, stale)
+}
+
+func TestShardedPrices_Reshard(t *testing.T) {
+	old, shards := newTestShardedPrices(t, "shard_0", "shard_1")
+	testData := newTestPrices(200)
+	err := old.CreateMany(context.Background(), testData)
+	assert.NoError(t, err)
+
+	shard2 := newTestSQLitePrices(t)
+	shard3 := newTestSQLitePrices(t)
+	repo, err := newShardedPrices(
+		[]string{"shard_0", "shard_2", "shard_3"},
+		[]Prices{shards["shard_0"], shard2, shard3},
+	)
+	assert.NoError(t, err)
+
+	moved, err := repo.Reshard(context.Background(), map[string]Prices{"shard_1": shards["shard_1"]}, 16)
+	assert.NoError(t, err)
+	assert.NotZero(t, moved["shard_0"])
+	assert.NotZero(t, moved["shard_1"])
+	assert.Zero(t, countShard(t, shards["shard_1"]))
+	assert.Equal(t, len(testData), countShard(t, shards["shard_0"])+countShard(t, shard2)+countShard(t, shard3))
+
+	for _, price := range testData {
+		_, err := repo.Get(context.Background(), price.ID)
+		assert.NoError(t, err)
+	}
+}
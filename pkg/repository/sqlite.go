@@ -0,0 +1,439 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"prices/pkg/config"
+	"prices/pkg/errors"
+	"prices/pkg/files"
+	"prices/pkg/models"
+	"time"
+
+	"github.com/nullism/bqb"
+	"github.com/shopspring/decimal"
+	"modernc.org/sqlite"
+)
+
+const (
+	// sqliteImportBatchSize - how many rows ImportFile writes in a single transaction.
+	sqliteImportBatchSize = 10000
+
+	// SQLITE_BUSY and SQLITE_LOCKED result codes.
+	sqliteBusy   = 5
+	sqliteLocked = 6
+)
+
+type (
+	// SQLitePrices - embedded storage of prices, intended for local development and tests.
+	// SQLite has a single writer, so concurrent writes wait for each other up to the busy_timeout set in the DSN.
+	// Dates are stored as text in UTC, so they can be compared.
+	SQLitePrices struct {
+		db     *sql.DB
+		config config.Storage
+	}
+)
+
+func NewSQLitePrices(config config.Storage) (*SQLitePrices, error) {
+	db, err := sql.Open(config.Type, config.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("can't establish connection to the data storage: %w", err)
+	}
+	db.SetMaxOpenConns(config.MaxConnections)
+	db.SetMaxIdleConns(config.MaxConnections)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't ping data storage: %w", err)
+	}
+
+	return &SQLitePrices{
+		db:     db,
+		config: config,
+	}, nil
+}
+
+func (r *SQLitePrices) CreateMany(ctx context.Context, prices []*models.Price) error {
+	_, err := r.createMany(ctx, "", prices)
+	return err
+}
+
+// ImportFile - reads .CSV file, compressed files are decompressed while they are read, and writes it to the storage in batches.
+// Same as LOAD DATA ... IGNORE in MySQL, rows that can't be parsed and duplicated ids are skipped,
+// rows that can't be parsed are reported as warnings.
+func (r *SQLitePrices) ImportFile(ctx context.Context, filePath string) (*models.ImportResult, error) {
+	f, err := files.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("can't open file=%s to import prices: %w", filePath, err)
+	}
+	defer f.Close()
+
+	return r.importReader(ctx, "", filePath, f)
+}
+
+// ImportRange - same as ImportFile, but reads length bytes of the file from offset, the range must be aligned to lines.
+func (r *SQLitePrices) ImportRange(ctx context.Context, filePath string, offset int64, length int64) (*models.ImportResult, error) {
+	f, err := files.OpenRange(filePath, offset, length)
+	if err != nil {
+		return nil, fmt.Errorf("can't open file=%s to import prices: %w", filePath, err)
+	}
+	defer f.Close()
+
+	return r.importReader(ctx, "", filePath, f)
+}
+
+// StageMany - stages prices of the import, same as CreateMany prices with the same id are staged once.
+func (r *SQLitePrices) StageMany(ctx context.Context, importID string, prices []*models.Price) error {
+	_, err := r.createMany(ctx, importID, prices)
+	return err
+}
+
+// StageFile - same as ImportFile or ImportRange, but writes the file to the staged prices of the import.
+func (r *SQLitePrices) StageFile(ctx context.Context, importID string, filePath string, offset int64, length int64) (*models.ImportResult, error) {
+	f, err := openRange(filePath, offset, length)
+	if err != nil {
+		return nil, fmt.Errorf("can't open file=%s to import prices: %w", filePath, err)
+	}
+	defer f.Close()
+
+	return r.importReader(ctx, importID, filePath, f)
+}
+
+// CommitImport - moves staged prices of the import to the prices table and drops them in a single transaction,
+// prices with the same id as prices in the table are skipped.
+func (r *SQLitePrices) CommitImport(ctx context.Context, importID string) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("can't begin commit import transaction: %w", classifySQLiteError(err))
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO prices (id, price, expiration_date)
+		SELECT id, price, expiration_date FROM prices_imports
+		WHERE import_id = ?
+		ON CONFLICT (id) DO NOTHING
+	`, importID)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("can't execute commit import query: %w", classifySQLiteError(err))
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("can't get rows affected by commit import query: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM prices_imports
+		WHERE import_id = ?
+	`, importID); err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("can't execute drop staged prices query: %w", classifySQLiteError(err))
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("can't commit import transaction: %w", classifySQLiteError(err))
+	}
+
+	return affected, nil
+}
+
+// RollbackImport - drops staged prices of the import.
+func (r *SQLitePrices) RollbackImport(ctx context.Context, importID string) error {
+	_, err := r.db.ExecContext(ctx, `
+		DELETE FROM prices_imports
+		WHERE import_id = ?
+	`, importID)
+	if err != nil {
+		return fmt.Errorf("can't execute drop staged prices query: %w", classifySQLiteError(err))
+	}
+
+	return nil
+}
+
+// StaleImports - returns ids of the imports that haven't staged prices for the age.
+func (r *SQLitePrices) StaleImports(ctx context.Context, age time.Duration) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT import_id FROM prices_imports
+		GROUP BY import_id
+		HAVING MAX(created_at) < datetime('now', ?)
+	`, fmt.Sprintf("-%d seconds", int64(age.Seconds())))
+	if err != nil {
+		return nil, fmt.Errorf("can't execute get stale imports query: %w", classifySQLiteError(err))
+	}
+	defer rows.Close()
+
+	return scanImportIDs(rows)
+}
+
+// importReader - writes .CSV data read from f to the storage, or to the staged prices of the import if the import id isn't empty,
+// filePath is the file the data is read from.
+func (r *SQLitePrices) importReader(ctx context.Context, importID string, filePath string, f io.Reader) (*models.ImportResult, error) {
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+
+	result := &models.ImportResult{}
+	var prices []*models.Price
+	save := func() error {
+		affected, err := r.createMany(ctx, importID, prices)
+		if err != nil {
+			return fmt.Errorf("can't import prices from file=%s: %w", filePath, err)
+		}
+		result.RowsAffected += affected
+		prices = nil
+		return nil
+	}
+	for {
+		line, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("can't read file=%s to import prices: %w", filePath, err)
+		}
+		result.Records++
+		price, err := r.toPrice(line)
+		if err != nil {
+			result.Warnings = append(result.Warnings, models.ImportWarning{
+				Level:   "Warning",
+				Message: fmt.Sprintf("%s at row %d", err.Error(), result.Records),
+			})
+			continue
+		}
+		prices = append(prices, price)
+		if len(prices) == sqliteImportBatchSize {
+			if err := save(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(prices) > 0 {
+		if err := save(); err != nil {
+			return nil, err
+		}
+	}
+	result.RowsSkipped = result.Records - result.RowsAffected
+
+	return result, nil
+}
+
+func (r *SQLitePrices) Get(ctx context.Context, id string) (*models.Price, error) {
+	q := bqb.New(
+		`
+			SELECT id, price, expiration_date FROM prices
+			WHERE id = ?
+		`,
+		id,
+	)
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("can't build get price query: %w", err)
+	}
+
+	var price models.Price
+
+	row := r.db.QueryRowContext(ctx, query, args...)
+	err = row.Scan(&price.ID, &price.Price, &price.ExpirationDate)
+	if err != nil {
+		if errors.ErrorIs(err, sql.ErrNoRows) {
+			return nil, errors.ErrPriceNotFound
+		}
+		return nil, fmt.Errorf("can't execute get price query: %w", err)
+	}
+
+	return &price, nil
+}
+
+// GetMany - gets prices by ids, ids that are not found are skipped.
+func (r *SQLitePrices) GetMany(ctx context.Context, ids []string) ([]*models.Price, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	q := bqb.New(
+		`
+			SELECT id, price, expiration_date FROM prices
+			WHERE id IN (?)
+		`,
+		ids,
+	)
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("can't build get prices query: %w", err)
+	}
+
+	prices, err := queryPrices(ctx, r.db, query, args)
+	if err != nil {
+		return nil, fmt.Errorf("can't execute get prices query: %w", err)
+	}
+
+	return prices, nil
+}
+
+// List - lists up to limit prices ordered by id, starting after the given id.
+func (r *SQLitePrices) List(ctx context.Context, after string, limit int) ([]*models.Price, error) {
+	q := bqb.New(
+		`
+			SELECT id, price, expiration_date FROM prices
+			WHERE id > ?
+			ORDER BY id
+			LIMIT ?
+		`,
+		after, limit,
+	)
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("can't build list prices query: %w", err)
+	}
+
+	prices, err := queryPrices(ctx, r.db, query, args)
+	if err != nil {
+		return nil, fmt.Errorf("can't execute list prices query: %w", err)
+	}
+
+	return prices, nil
+}
+
+// ListExpired - lists up to limit prices that expired before the given time, the longest expired first.
+func (r *SQLitePrices) ListExpired(ctx context.Context, before time.Time, limit int) ([]*models.Price, error) {
+	q := bqb.New(
+		`
+			SELECT id, price, expiration_date FROM prices
+			WHERE expiration_date < ?
+			ORDER BY expiration_date
+			LIMIT ?
+		`,
+		before.UTC(), limit,
+	)
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("can't build list expired prices query: %w", err)
+	}
+
+	prices, err := queryPrices(ctx, r.db, query, args)
+	if err != nil {
+		return nil, fmt.Errorf("can't execute list expired prices query: %w", err)
+	}
+
+	return prices, nil
+}
+
+func (r *SQLitePrices) DeleteMany(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	q := bqb.New(
+		`
+			DELETE FROM prices
+			WHERE id IN (?)
+		`,
+		ids,
+	)
+	query, args, err := q.ToSql()
+	if err != nil {
+		return fmt.Errorf("can't build delete prices query: %w", err)
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("can't execute delete prices query: %w", classifySQLiteError(err))
+	}
+
+	return nil
+}
+
+// Close - closes connection to the storage.
+func (r *SQLitePrices) Close() error {
+	return r.db.Close()
+}
+
+// createMany - writes prices in a transaction, to the staged prices of the import if the import id isn't empty,
+// returns how many of them were not duplicates.
+func (r *SQLitePrices) createMany(ctx context.Context, importID string, prices []*models.Price) (int64, error) {
+	query := `
+		INSERT INTO prices (id, price, expiration_date) VALUES (?,?,?)
+		ON CONFLICT (id) DO NOTHING
+	`
+	var args []any
+	if importID != "" {
+		query = `
+			INSERT INTO prices_imports (import_id, id, price, expiration_date) VALUES (?,?,?,?)
+			ON CONFLICT (import_id, id) DO NOTHING
+		`
+		args = append(args, importID)
+	}
+	var affected int64
+	err := r.inTx(ctx, query, func(stmt *sql.Stmt) error {
+		for _, price := range prices {
+			res, err := stmt.ExecContext(ctx, append(args, price.ID, price.Price, price.ExpirationDate.UTC())...)
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			affected += n
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("can't execute create prices query: %w", classifySQLiteError(err))
+	}
+
+	return affected, nil
+}
+
+// inTx - runs fn with the prepared insert statement inside a single transaction.
+func (r *SQLitePrices) inTx(ctx context.Context, query string, fn func(stmt *sql.Stmt) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	if err := fn(stmt); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *SQLitePrices) toPrice(line []string) (*models.Price, error) {
+	if len(line) != 3 {
+		return nil, fmt.Errorf("row has %d columns instead of 3", len(line))
+	}
+	price, err := decimal.NewFromString(line[1])
+	if err != nil {
+		return nil, fmt.Errorf("incorrect price value '%s'", line[1])
+	}
+	expirationDate, err := files.ParseDate(line[2], nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("incorrect expiration_date value '%s'", line[2])
+	}
+	return &models.Price{
+		ID:             line[0],
+		Price:          price,
+		ExpirationDate: expirationDate,
+	}, nil
+}
+
+// classifySQLiteError - marks the error with errors.ErrTransient if the database is busy or locked by another connection.
+func classifySQLiteError(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.ErrorAs(err, &sqliteErr) {
+		// Lower byte of an extended result code is its primary code.
+		switch sqliteErr.Code() & 0xff {
+		case sqliteBusy, sqliteLocked:
+			return fmt.Errorf("%w: %w", errors.ErrTransient, err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"prices/pkg/config"
+	"prices/pkg/errors"
+	"prices/pkg/migrations"
+	"prices/pkg/models"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSQLitePrices(t *testing.T) *SQLitePrices {
+	storage := config.Storage{
+		Type:           config.StorageSQLite,
+		DSN:            filepath.Join(t.TempDir(), "prices.db") + "?_pragma=busy_timeout(5000)",
+		MaxConnections: 1,
+	}
+	err := migrations.MigrateDB(storage)
+	assert.NoError(t, err)
+	repo, err := NewSQLitePrices(storage)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = repo.db.Close()
+	})
+	return repo
+}
+
+func TestSQLitePrices_CreateMany(t *testing.T) {
+	repo := newTestSQLitePrices(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	testData := []*models.Price{
+		{
+			ID:             "test_id_1",
+			Price:          decimal.RequireFromString("3.1415926535"),
+			ExpirationDate: now.AddDate(0, 0, 1),
+		},
+		{
+			ID:             "test_id_2",
+			Price:          decimal.RequireFromString("2.71828"),
+			ExpirationDate: now.AddDate(0, 0, 2),
+		},
+	}
+
+	err := repo.CreateMany(context.Background(), testData)
+	assert.NoError(t, err)
+
+	duplicate := []*models.Price{{ID: "test_id_1", Price: decimal.NewFromInt(1), ExpirationDate: now}}
+	err = repo.CreateMany(context.Background(), duplicate)
+	assert.NoError(t, err)
+
+	for _, expected := range testData {
+		res, err := repo.Get(context.Background(), expected.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, expected.ID, res.ID)
+		assert.True(t, expected.Price.Equal(res.Price))
+		assert.True(t, expected.ExpirationDate.Equal(res.ExpirationDate))
+	}
+}
+
+func TestSQLitePrices_ImportFile(t *testing.T) {
+	repo := newTestSQLitePrices(t)
+	testFile := filepath.Join(t.TempDir(), "test.csv")
+	testData := "" +
+		"test_id_1,3.14,2023-08-24 10:01:40 +0000 UTC\n" +
+		"test_id_2,bad_price,2023-08-24 10:01:40 +0000 UTC\n" +
+		"test_id_3,2.71,2023-08-25 12:00:00 +0200 CEST\n" +
+		"test_id_1,1.41,2023-08-24 10:01:40 +0000 UTC\n"
+	err := os.WriteFile(testFile, []byte(testData), 0644)
+	assert.NoError(t, err)
+
+	result, err := repo.ImportFile(context.Background(), testFile)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), result.Records)
+	assert.Equal(t, int64(2), result.RowsAffected)
+	assert.Equal(t, int64(2), result.RowsSkipped)
+	assert.Equal(t, []models.ImportWarning{
+		{Level: "Warning", Message: "incorrect price value 'bad_price' at row 2"},
+	}, result.Warnings)
+
+	res, err := repo.Get(context.Background(), "test_id_3")
+	assert.NoError(t, err)
+	assert.True(t, decimal.RequireFromString("2.71").Equal(res.Price))
+	assert.True(t, time.Date(2023, 8, 25, 10, 0, 0, 0, time.UTC).Equal(res.ExpirationDate))
+
+	_, err = repo.Get(context.Background(), "test_id_2")
+	assert.ErrorIs(t, err, errors.ErrPriceNotFound)
+}
+
+func TestSQLitePrices_ImportFile_Gzip(t *testing.T) {
+	repo := newTestSQLitePrices(t)
+	testFile := filepath.Join(t.TempDir(), "test.csv.gz")
+	f, err := os.Create(testFile)
+	assert.NoError(t, err)
+	gz := gzip.NewWriter(f)
+	_, err = gz.Write([]byte("test_id_1,3.14,2023-08-24 10:01:40 +0000 UTC\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+	assert.NoError(t, f.Close())
+
+	result, err := repo.ImportFile(context.Background(), testFile)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.RowsAffected)
+
+	res, err := repo.Get(context.Background(), "test_id_1")
+	assert.NoError(t, err)
+	assert.True(t, decimal.RequireFromString("3.14").Equal(res.Price))
+}
+
+func TestSQLitePrices_ImportRange(t *testing.T) {
+	repo := newTestSQLitePrices(t)
+	testFile := filepath.Join(t.TempDir(), "test.csv")
+	testData := "" +
+		"test_id_1,3.14,2023-08-24 10:01:40 +0000 UTC\n" +
+		"test_id_2,2.71,2023-08-24 10:01:40 +0000 UTC\n" +
+		"test_id_3,1.41,2023-08-24 10:01:40 +0000 UTC\n"
+	err := os.WriteFile(testFile, []byte(testData), 0644)
+	assert.NoError(t, err)
+
+	result, err := repo.ImportRange(context.Background(), testFile, 45, 45)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.Records)
+	assert.Equal(t, int64(1), result.RowsAffected)
+
+	res, err := repo.Get(context.Background(), "test_id_2")
+	assert.NoError(t, err)
+	assert.True(t, decimal.RequireFromString("2.71").Equal(res.Price))
+	for _, id := range []string{"test_id_1", "test_id_3"} {
+		_, err = repo.Get(context.Background(), id)
+		assert.ErrorIs(t, err, errors.ErrPriceNotFound)
+	}
+}
+
+func TestSQLitePrices_StaleImports(t *testing.T) {
+	repo := newTestSQLitePrices(t)
+	ctx := context.Background()
+	price := []*models.Price{
+		{ID: "test_id_1", Price: decimal.RequireFromString("3.14"), ExpirationDate: time.Date(2023, 8, 24, 10, 1, 40, 0, time.UTC)},
+	}
+
+	assert.NoError(t, repo.StageMany(ctx, "1111", price))
+	assert.NoError(t, repo.StageMany(ctx, "2222", price))
+	_, err := repo.db.ExecContext(ctx, "UPDATE prices_imports SET created_at = datetime('now', '-2 hours') WHERE import_id = '1111'")
+	assert.NoError(t, err)
+
+	stale, err := repo.StaleImports(ctx, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1111"}, stale)
+}
+
+func TestSQLitePrices_Imports(t *testing.T) {
+	repo := newTestSQLitePrices(t)
+	ctx := context.Background()
+	committed := "1111"
+	rolledBack := "2222"
+	testFile := filepath.Join(t.TempDir(), "test.csv")
+	err := os.WriteFile(testFile, []byte("test_id_2,2.71,2023-08-24 10:01:40 +0000 UTC\n"), 0644)
+	assert.NoError(t, err)
+
+	err = repo.StageMany(ctx, committed, []*models.Price{
+		{ID: "test_id_1", Price: decimal.RequireFromString("3.14"), ExpirationDate: time.Date(2023, 8, 24, 10, 1, 40, 0, time.UTC)},
+	})
+	assert.NoError(t, err)
+	result, err := repo.StageFile(ctx, committed, testFile, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.Records)
+	err = repo.StageMany(ctx, rolledBack, []*models.Price{
+		{ID: "test_id_3", Price: decimal.RequireFromString("1.41"), ExpirationDate: time.Date(2023, 8, 24, 10, 1, 40, 0, time.UTC)},
+	})
+	assert.NoError(t, err)
+
+	// Staged prices are not visible until the import is committed.
+	for _, id := range []string{"test_id_1", "test_id_2", "test_id_3"} {
+		_, err = repo.Get(ctx, id)
+		assert.ErrorIs(t, err, errors.ErrPriceNotFound)
+	}
+
+	affected, err := repo.CommitImport(ctx, committed)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), affected)
+	err = repo.RollbackImport(ctx, rolledBack)
+	assert.NoError(t, err)
+
+	for _, id := range []string{"test_id_1", "test_id_2"} {
+		_, err = repo.Get(ctx, id)
+		assert.NoError(t, err)
+	}
+	_, err = repo.Get(ctx, "test_id_3")
+	assert.ErrorIs(t, err, errors.ErrPriceNotFound)
+
+	var staged int
+	err = repo.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM prices_imports").Scan(&staged)
+	assert.NoError(t, err)
+	assert.Zero(t, staged)
+
+	// Committing again is a no-op, e.g. if the commit is retried.
+	affected, err = repo.CommitImport(ctx, committed)
+	assert.NoError(t, err)
+	assert.Zero(t, affected)
+}
+
+func TestSQLitePrices_Get_NotFound(t *testing.T) {
+	repo := newTestSQLitePrices(t)
+	_, err := repo.Get(context.Background(), "test_id_1")
+	assert.ErrorIs(t, err, errors.ErrPriceNotFound)
+}
+
+func TestSQLitePrices_ListExpired(t *testing.T) {
+	repo := newTestSQLitePrices(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	cest := time.FixedZone("CEST", 2*60*60)
+	testData := []*models.Price{
+		{ID: "test_id_1", Price: decimal.NewFromInt(1), ExpirationDate: now.AddDate(0, 0, -2)},
+		{ID: "test_id_2", Price: decimal.NewFromInt(2), ExpirationDate: now.AddDate(0, 0, 1).In(cest)},
+		{ID: "test_id_3", Price: decimal.NewFromInt(3), ExpirationDate: now.Add(-time.Hour).In(cest)},
+	}
+	err := repo.CreateMany(context.Background(), testData)
+	assert.NoError(t, err)
+
+	res, err := repo.ListExpired(context.Background(), now, 10)
+	assert.NoError(t, err)
+	assert.Len(t, res, 2)
+	assert.Equal(t, "test_id_1", res[0].ID)
+	assert.Equal(t, "test_id_3", res[1].ID)
+
+	res, err = repo.ListExpired(context.Background(), now, 1)
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+}
@@ -0,0 +1,206 @@
+//go:generate mockgen -source purger.go -destination repository_mock.go -package retention PricesRepo
+
+package retention
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"prices/pkg/config"
+	"prices/pkg/metrics"
+	"prices/pkg/models"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// archiveDateLayout - layout of expiration dates in archives, same as in the input files, so archives can be imported back.
+	archiveDateLayout = "2006-01-02 15:04:05 -0700 MST"
+)
+
+type (
+	PricesRepo interface {
+		ListExpired(ctx context.Context, before time.Time, limit int) ([]*models.Price, error)
+		DeleteMany(ctx context.Context, ids []string) error
+	}
+
+	// Purger - deletes prices that expired longer than the retention period ago.
+	// Prices are deleted in small batches with a delay between them, so deletion doesn't hold long locks on the storage.
+	Purger struct {
+		ctx    context.Context
+		wg     *sync.WaitGroup
+		config *config.Retention
+		repo   PricesRepo
+		logger *zap.Logger
+		stop   <-chan bool
+	}
+
+	// archive - gzip compressed .CSV file with purged prices.
+	archive struct {
+		file   *os.File
+		gz     *gzip.Writer
+		writer *csv.Writer
+	}
+)
+
+func NewPurger(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	config *config.Retention,
+	repo PricesRepo,
+	logger *zap.Logger,
+	stop <-chan bool,
+) *Purger {
+	log := logger.Named("Purger")
+	p := &Purger{
+		ctx:    ctx,
+		wg:     wg,
+		config: config,
+		repo:   repo,
+		logger: log,
+		stop:   stop,
+	}
+	return p
+}
+
+// Run - purges expired prices every RunEveryDuration until stopped.
+func (p *Purger) Run() {
+	p.logger.Sugar().Infof("start purging prices expired for longer than=%s", p.config.Period)
+	p.wg.Add(1)
+	ticker := time.NewTicker(p.config.RunEveryDuration)
+	defer ticker.Stop()
+	p.purge()
+	for {
+		select {
+		case <-ticker.C:
+			p.purge()
+		case <-p.stop:
+			p.logger.Sugar().Infof("stop purging prices")
+			p.wg.Done()
+			return
+		}
+	}
+}
+
+// Purge - deletes all prices expired for longer than the retention period, returns the number of deleted prices.
+func (p *Purger) Purge() (int, error) {
+	before := time.Now().Add(-p.config.Period)
+	purged := 0
+
+	var arch *archive
+	defer func() {
+		if arch != nil {
+			if err := arch.close(); err != nil {
+				p.logger.Sugar().Errorf("can't close archive=%s: (%s)", arch.file.Name(), err.Error())
+			}
+		}
+	}()
+
+	for {
+		if err := p.ctx.Err(); err != nil {
+			return purged, err
+		}
+		started := time.Now()
+
+		prices, err := p.repo.ListExpired(p.ctx, before, p.config.BatchSize)
+		if err != nil {
+			return purged, fmt.Errorf("can't list prices expired before=%s: %w", before, err)
+		}
+		if len(prices) == 0 {
+			return purged, nil
+		}
+
+		if p.config.ArchiveDir != "" {
+			if arch == nil {
+				arch, err = newArchive(p.config.ArchiveDir, started)
+				if err != nil {
+					return purged, err
+				}
+				p.logger.Sugar().Infof("archive expired prices to file=%s", arch.file.Name())
+			}
+			if err := arch.write(prices); err != nil {
+				return purged, fmt.Errorf("can't archive expired prices to file=%s: %w", arch.file.Name(), err)
+			}
+			metrics.ArchivedPrices.Add(float64(len(prices)))
+		}
+
+		ids := make([]string, 0, len(prices))
+		for _, price := range prices {
+			ids = append(ids, price.ID)
+		}
+		if err := p.repo.DeleteMany(p.ctx, ids); err != nil {
+			return purged, fmt.Errorf("can't delete expired prices: %w", err)
+		}
+		purged += len(ids)
+		metrics.PurgedPrices.Add(float64(len(ids)))
+		metrics.PurgeBatchDuration.Observe(time.Since(started).Seconds())
+
+		if len(prices) < p.config.BatchSize {
+			return purged, nil
+		}
+
+		select {
+		case <-time.After(p.config.BatchDelay):
+		case <-p.ctx.Done():
+			return purged, p.ctx.Err()
+		}
+	}
+}
+
+func (p *Purger) purge() {
+	p.logger.Sugar().Infof("purge prices expired for longer than=%s", p.config.Period)
+	purged, err := p.Purge()
+	if err != nil {
+		p.logger.Sugar().Errorf("can't purge expired prices, purged=%d: (%s)", purged, err.Error())
+		return
+	}
+	p.logger.Sugar().Infof("purged expired prices=%d", purged)
+}
+
+func newArchive(dir string, now time.Time) (*archive, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("can't create archive directory=%s: %w", dir, err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%d_expired_prices.csv.gz", now.UnixNano()))
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't create archive=%s: %w", path, err)
+	}
+	gz := gzip.NewWriter(f)
+	return &archive{
+		file:   f,
+		gz:     gz,
+		writer: csv.NewWriter(gz),
+	}, nil
+}
+
+// write - writes prices to the archive and flushes them, so they are on disk before they are deleted from the storage.
+func (a *archive) write(prices []*models.Price) error {
+	for _, price := range prices {
+		line := []string{price.ID, price.Price.String(), price.ExpirationDate.Format(archiveDateLayout)}
+		if err := a.writer.Write(line); err != nil {
+			return err
+		}
+	}
+	a.writer.Flush()
+	if err := a.writer.Error(); err != nil {
+		return err
+	}
+	if err := a.gz.Flush(); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+func (a *archive) close() error {
+	if err := a.gz.Close(); err != nil {
+		_ = a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
@@ -0,0 +1,117 @@
+package retention
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"prices/pkg/config"
+	"prices/pkg/models"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newTestPurger(t *testing.T, archiveDir string) (*Purger, *MockPricesRepo) {
+	cfg := &config.Retention{
+		Period:     time.Hour,
+		BatchSize:  2,
+		BatchDelay: time.Millisecond,
+		ArchiveDir: archiveDir,
+	}
+
+	ctrl := gomock.NewController(t)
+
+	repo := NewMockPricesRepo(ctrl)
+
+	purger := NewPurger(context.Background(), &sync.WaitGroup{}, cfg, repo, zap.NewNop(), make(chan bool))
+
+	return purger, repo
+}
+
+func newTestExpiredPrices(ids ...string) []*models.Price {
+	expirationDate := time.Date(2023, 8, 24, 10, 1, 40, 0, time.UTC)
+	prices := make([]*models.Price, 0, len(ids))
+	for _, id := range ids {
+		prices = append(prices, &models.Price{
+			ID:             id,
+			Price:          decimal.RequireFromString("3.14"),
+			ExpirationDate: expirationDate,
+		})
+	}
+	return prices
+}
+
+func TestPurger_Purge(t *testing.T) {
+	purger, repo := newTestPurger(t, "")
+
+	gomock.InOrder(
+		repo.EXPECT().ListExpired(gomock.Any(), gomock.Any(), 2).Return(newTestExpiredPrices("test_id_1", "test_id_2"), nil),
+		repo.EXPECT().DeleteMany(gomock.Any(), []string{"test_id_1", "test_id_2"}).Return(nil),
+		repo.EXPECT().ListExpired(gomock.Any(), gomock.Any(), 2).Return(newTestExpiredPrices("test_id_3"), nil),
+		repo.EXPECT().DeleteMany(gomock.Any(), []string{"test_id_3"}).Return(nil),
+	)
+
+	purged, err := purger.Purge()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, purged)
+}
+
+func TestPurger_Purge_Before(t *testing.T) {
+	purger, repo := newTestPurger(t, "")
+
+	repo.EXPECT().ListExpired(gomock.Any(), gomock.Any(), 2).DoAndReturn(
+		func(ctx context.Context, before time.Time, limit int) ([]*models.Price, error) {
+			assert.WithinDuration(t, time.Now().Add(-time.Hour), before, time.Minute)
+			return nil, nil
+		},
+	)
+
+	purged, err := purger.Purge()
+	assert.NoError(t, err)
+	assert.Zero(t, purged)
+}
+
+func TestPurger_Purge_Archive(t *testing.T) {
+	dir := t.TempDir()
+	purger, repo := newTestPurger(t, dir)
+
+	prices := newTestExpiredPrices("test_id_1")
+	repo.EXPECT().ListExpired(gomock.Any(), gomock.Any(), 2).Return(prices, nil)
+	repo.EXPECT().DeleteMany(gomock.Any(), []string{"test_id_1"}).Return(nil)
+
+	purged, err := purger.Purge()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	archives, err := filepath.Glob(filepath.Join(dir, "*_expired_prices.csv.gz"))
+	assert.NoError(t, err)
+	assert.Len(t, archives, 1)
+
+	f, err := os.Open(archives[0])
+	assert.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	lines, err := csv.NewReader(gz).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"test_id_1", "3.14", "2023-08-24 10:01:40 +0000 UTC"}}, lines)
+}
+
+func TestPurger_Purge_DeleteError(t *testing.T) {
+	purger, repo := newTestPurger(t, "")
+
+	repo.EXPECT().ListExpired(gomock.Any(), gomock.Any(), 2).Return(newTestExpiredPrices("test_id_1", "test_id_2"), nil)
+	repo.EXPECT().DeleteMany(gomock.Any(), []string{"test_id_1", "test_id_2"}).Return(fmt.Errorf("test error"))
+
+	purged, err := purger.Purge()
+	assert.Error(t, err)
+	assert.Zero(t, purged)
+}
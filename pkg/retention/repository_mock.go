@@ -0,0 +1,66 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: purger.go
+
+// Package retention is a generated GoMock package.
+package retention
+
+import (
+	context "context"
+	models "prices/pkg/models"
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockPricesRepo is a mock of PricesRepo interface.
+type MockPricesRepo struct {
+	ctrl     *gomock.Controller
+	recorder *MockPricesRepoMockRecorder
+}
+
+// MockPricesRepoMockRecorder is the mock recorder for MockPricesRepo.
+type MockPricesRepoMockRecorder struct {
+	mock *MockPricesRepo
+}
+
+// NewMockPricesRepo creates a new mock instance.
+func NewMockPricesRepo(ctrl *gomock.Controller) *MockPricesRepo {
+	mock := &MockPricesRepo{ctrl: ctrl}
+	mock.recorder = &MockPricesRepoMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPricesRepo) EXPECT() *MockPricesRepoMockRecorder {
+	return m.recorder
+}
+
+// DeleteMany mocks base method.
+func (m *MockPricesRepo) DeleteMany(ctx context.Context, ids []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMany", ctx, ids)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteMany indicates an expected call of DeleteMany.
+func (mr *MockPricesRepoMockRecorder) DeleteMany(ctx, ids interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMany", reflect.TypeOf((*MockPricesRepo)(nil).DeleteMany), ctx, ids)
+}
+
+// ListExpired mocks base method.
+func (m *MockPricesRepo) ListExpired(ctx context.Context, before time.Time, limit int) ([]*models.Price, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListExpired", ctx, before, limit)
+	ret0, _ := ret[0].([]*models.Price)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListExpired indicates an expected call of ListExpired.
+func (mr *MockPricesRepoMockRecorder) ListExpired(ctx, before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListExpired", reflect.TypeOf((*MockPricesRepo)(nil).ListExpired), ctx, before, limit)
+}
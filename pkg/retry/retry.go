@@ -0,0 +1,79 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"prices/pkg/config"
+	"prices/pkg/errors"
+	"time"
+)
+
+type (
+	// Backoff - retries operations that fail with errors.ErrTransient,
+	// waiting a random time up to an exponentially growing interval between attempts.
+	Backoff struct {
+		config config.Retry
+		sleep  func(ctx context.Context, d time.Duration) error
+	}
+)
+
+func NewBackoff(config config.Retry) *Backoff {
+	return &Backoff{
+		config: config,
+		sleep:  sleep,
+	}
+}
+
+// Do - runs fn until it succeeds, fails with an error that is not transient, or the retry budget is spent.
+// onRetry is called before every wait with the number of the failed attempt, can be nil.
+func (b *Backoff) Do(ctx context.Context, fn func() error, onRetry func(attempt int, wait time.Duration, err error)) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !errors.ErrorIs(err, errors.ErrTransient) {
+			return err
+		}
+		if attempt >= b.config.MaxAttempts {
+			return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		}
+		wait := b.wait(attempt)
+		if b.config.MaxElapsedTime > 0 && time.Since(start)+wait > b.config.MaxElapsedTime {
+			return fmt.Errorf("gave up after %d attempts in %s: %w", attempt, time.Since(start).Round(time.Millisecond), err)
+		}
+		if onRetry != nil {
+			onRetry(attempt, wait, err)
+		}
+		if sleepErr := b.sleep(ctx, wait); sleepErr != nil {
+			return fmt.Errorf("stopped retrying after %d attempts (%s): %w", attempt, sleepErr.Error(), err)
+		}
+	}
+}
+
+// wait - returns a random duration up to InitialInterval*2^(attempt-1), capped by MaxInterval ("full jitter"),
+// MaxInterval 0 means the interval grows without a cap.
+func (b *Backoff) wait(attempt int) time.Duration {
+	interval := b.config.InitialInterval
+	for i := 1; i < attempt && interval < math.MaxInt64/2 && (b.config.MaxInterval <= 0 || interval < b.config.MaxInterval); i++ {
+		interval *= 2
+	}
+	if b.config.MaxInterval > 0 && interval > b.config.MaxInterval {
+		interval = b.config.MaxInterval
+	}
+	if interval <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(interval) + 1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,131 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"prices/pkg/config"
+	"prices/pkg/errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBackoff(cfg config.Retry) (*Backoff, *[]time.Duration) {
+	var waits []time.Duration
+	b := NewBackoff(cfg)
+	b.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return ctx.Err()
+	}
+	return b, &waits
+}
+
+func TestBackoff_Do_RetriesTransient(t *testing.T) {
+	b, waits := newTestBackoff(config.Retry{
+		MaxAttempts:     5,
+		InitialInterval: 10 * time.Millisecond,
+		MaxInterval:     20 * time.Millisecond,
+	})
+	attempts := 0
+	retried := 0
+	err := b.Do(context.Background(), func() error {
+		attempts++
+		if attempts < 4 {
+			return fmt.Errorf("deadlock: %w", errors.ErrTransient)
+		}
+		return nil
+	}, func(attempt int, wait time.Duration, err error) {
+		retried++
+		assert.Equal(t, retried, attempt)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, attempts)
+	assert.Equal(t, 3, retried)
+	assert.Len(t, *waits, 3)
+	for i, wait := range *waits {
+		assert.LessOrEqual(t, wait, 20*time.Millisecond, "wait %d", i)
+	}
+	assert.LessOrEqual(t, (*waits)[0], 10*time.Millisecond)
+}
+
+func TestBackoff_Wait_NoMaxInterval(t *testing.T) {
+	b := NewBackoff(config.Retry{InitialInterval: time.Millisecond})
+	grown := false
+	for i := 0; i < 100 && !grown; i++ {
+		grown = b.wait(5) > time.Millisecond
+	}
+	assert.True(t, grown)
+	for attempt := 1; attempt < 100; attempt++ {
+		assert.GreaterOrEqual(t, b.wait(attempt), time.Duration(0))
+	}
+}
+
+func TestBackoff_Do_PermanentError(t *testing.T) {
+	b, waits := newTestBackoff(config.Retry{MaxAttempts: 5, InitialInterval: time.Millisecond})
+	permanent := fmt.Errorf("syntax error")
+	attempts := 0
+	err := b.Do(context.Background(), func() error {
+		attempts++
+		return permanent
+	}, nil)
+	assert.ErrorIs(t, err, permanent)
+	assert.Equal(t, 1, attempts)
+	assert.Empty(t, *waits)
+}
+
+func TestBackoff_Do_MaxAttempts(t *testing.T) {
+	b, _ := newTestBackoff(config.Retry{MaxAttempts: 3, InitialInterval: time.Millisecond})
+	attempts := 0
+	err := b.Do(context.Background(), func() error {
+		attempts++
+		return errors.ErrTransient
+	}, nil)
+	assert.ErrorIs(t, err, errors.ErrTransient)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestBackoff_Do_NoRetries(t *testing.T) {
+	b, _ := newTestBackoff(config.Retry{})
+	attempts := 0
+	err := b.Do(context.Background(), func() error {
+		attempts++
+		return errors.ErrTransient
+	}, nil)
+	assert.ErrorIs(t, err, errors.ErrTransient)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestBackoff_Do_MaxElapsedTime(t *testing.T) {
+	b, waits := newTestBackoff(config.Retry{
+		MaxAttempts:     10,
+		InitialInterval: time.Hour,
+		MaxInterval:     time.Hour,
+		MaxElapsedTime:  time.Millisecond,
+	})
+	b.sleep = func(ctx context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		time.Sleep(2 * time.Millisecond)
+		return nil
+	}
+	attempts := 0
+	err := b.Do(context.Background(), func() error {
+		attempts++
+		return errors.ErrTransient
+	}, nil)
+	assert.ErrorIs(t, err, errors.ErrTransient)
+	assert.LessOrEqual(t, attempts, 2)
+}
+
+func TestBackoff_Do_Canceled(t *testing.T) {
+	b, _ := newTestBackoff(config.Retry{MaxAttempts: 5, InitialInterval: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts := 0
+	err := b.Do(ctx, func() error {
+		attempts++
+		return errors.ErrTransient
+	}, nil)
+	assert.ErrorIs(t, err, errors.ErrTransient)
+	assert.Equal(t, 1, attempts)
+}
@@ -14,7 +14,7 @@ type (
 	Repository interface {
 		CreateMany(ctx context.Context, prices []*models.Price) error
 		Get(ctx context.Context, id string) (*models.Price, error)
-		ImportFile(ctx context.Context, filePath string) error
+		ImportFile(ctx context.Context, filePath string) (*models.ImportResult, error)
 	}
 
 	Prices struct {
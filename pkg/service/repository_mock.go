@@ -65,11 +65,12 @@ func (mr *MockRepositoryMockRecorder) Get(ctx, id interface{}) *gomock.Call {
 }
 
 // ImportFile mocks base method.
-func (m *MockRepository) ImportFile(ctx context.Context, filePath string) error {
+func (m *MockRepository) ImportFile(ctx context.Context, filePath string) (*models.ImportResult, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "ImportFile", ctx, filePath)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret0, _ := ret[0].(*models.ImportResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // ImportFile indicates an expected call of ImportFile.
@@ -0,0 +1,237 @@
+//go:generate mockgen -source importer.go -destination repository_mock.go -package snapshots PricesRepo
+
+package snapshots
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"prices/pkg/config"
+	"prices/pkg/metrics"
+	"prices/pkg/models"
+	"prices/pkg/retry"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// ReadyFile - marks a snapshot directory as complete, it may contain the expected number of rows in the snapshot files.
+	ReadyFile = "READY"
+	// FailedSuffix - added to the name of a snapshot directory that failed to import, so it isn't imported again.
+	FailedSuffix = ".failed"
+
+	csvExtension = ".csv"
+)
+
+type (
+	PricesRepo interface {
+		CreateStaging(ctx context.Context) error
+		ImportStagingFile(ctx context.Context, filePath string) (*models.ImportResult, error)
+		CountStaging(ctx context.Context) (int64, error)
+		Count(ctx context.Context) (int64, error)
+		SwapStaging(ctx context.Context) error
+		RollbackSwap(ctx context.Context) error
+	}
+
+	// Importer - imports full snapshots of prices.
+	// All files of a snapshot are loaded to a staging table, which replaces the prices table in one step
+	// if the number of rows is valid, so the API never serves a half loaded snapshot.
+	// The replaced table is kept until the next snapshot, so the swap can be rolled back.
+	Importer struct {
+		ctx     context.Context
+		wg      *sync.WaitGroup
+		config  *config.Snapshots
+		backoff *retry.Backoff
+		repo    PricesRepo
+		logger  *zap.Logger
+		stop    <-chan bool
+	}
+)
+
+func NewImporter(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	config *config.Snapshots,
+	retryConfig config.Retry,
+	repo PricesRepo,
+	logger *zap.Logger,
+	stop <-chan bool,
+) *Importer {
+	log := logger.Named("SnapshotImporter")
+	i := &Importer{
+		ctx:     ctx,
+		wg:      wg,
+		config:  config,
+		backoff: retry.NewBackoff(retryConfig),
+		repo:    repo,
+		logger:  log,
+		stop:    stop,
+	}
+	return i
+}
+
+// Run - imports ready snapshots every CheckEveryDuration until stopped.
+func (i *Importer) Run() {
+	i.logger.Sugar().Infof("start importing snapshots from directory=%s", i.config.Dir)
+	i.wg.Add(1)
+	ticker := time.NewTicker(i.config.CheckEveryDuration)
+	defer ticker.Stop()
+	i.importReady()
+	for {
+		select {
+		case <-ticker.C:
+			i.importReady()
+		case <-i.stop:
+			i.logger.Sugar().Infof("stop importing snapshots from directory=%s", i.config.Dir)
+			i.wg.Done()
+			return
+		}
+	}
+}
+
+// Import - imports the snapshot in the directory and swaps it with the prices table.
+func (i *Importer) Import(dir string) error {
+	expected, err := expectedRows(filepath.Join(dir, ReadyFile))
+	if err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+csvExtension))
+	if err != nil {
+		return fmt.Errorf("can't list files of snapshot=%s: %w", dir, err)
+	}
+	sort.Strings(paths)
+
+	if err := i.repo.CreateStaging(i.ctx); err != nil {
+		return err
+	}
+
+	total := &models.ImportResult{}
+	for _, path := range paths {
+		var result *models.ImportResult
+		err := i.backoff.Do(i.ctx, func() (err error) {
+			result, err = i.repo.ImportStagingFile(i.ctx, path)
+			return err
+		}, func(attempt int, wait time.Duration, err error) {
+			i.logger.Sugar().Warnf("retry importing file=%s in %s after attempt=%d: (%s)", path, wait, attempt, err.Error())
+		})
+		if err != nil {
+			return err
+		}
+		i.logger.Sugar().Infof(
+			"imported file=%s to staging, records=%d, affected=%d, skipped=%d, warnings=%d",
+			path, result.Records, result.RowsAffected, result.RowsSkipped, len(result.Warnings),
+		)
+		total.Add(result)
+	}
+
+	rows, err := i.repo.CountStaging(i.ctx)
+	if err != nil {
+		return err
+	}
+	current, err := i.repo.Count(i.ctx)
+	if err != nil {
+		return err
+	}
+	if err := i.validate(total.Records, expected, rows, current); err != nil {
+		return err
+	}
+
+	if err := i.repo.SwapStaging(i.ctx); err != nil {
+		return err
+	}
+	metrics.SnapshotSwaps.Inc()
+	metrics.SnapshotRows.Set(float64(rows))
+	i.logger.Sugar().Infof("swapped snapshot=%s with prices=%d, previous prices=%d", dir, rows, current)
+
+	return nil
+}
+
+// Rollback - puts the prices replaced by the last snapshot back.
+func (i *Importer) Rollback() error {
+	if err := i.repo.RollbackSwap(i.ctx); err != nil {
+		return err
+	}
+	i.logger.Sugar().Infof("rolled back the last snapshot")
+	return nil
+}
+
+func (i *Importer) importReady() {
+	dirs, err := i.readyDirs()
+	if err != nil {
+		i.logger.Sugar().Errorf("can't list snapshots in directory=%s: (%s)", i.config.Dir, err.Error())
+		return
+	}
+	for _, dir := range dirs {
+		if i.ctx.Err() != nil {
+			return
+		}
+		i.logger.Sugar().Infof("import snapshot=%s", dir)
+		if err := i.Import(dir); err != nil {
+			metrics.FailedSnapshots.Inc()
+			i.logger.Sugar().Errorf("can't import snapshot=%s: (%s)", dir, err.Error())
+			if err := os.Rename(dir, dir+FailedSuffix); err != nil {
+				i.logger.Sugar().Errorf("can't mark snapshot=%s as failed: (%s)", dir, err.Error())
+			}
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			i.logger.Sugar().Errorf("can't remove imported snapshot=%s: (%s)", dir, err.Error())
+		}
+	}
+}
+
+// readyDirs - returns directories of snapshots with the ready file, ordered by name.
+func (i *Importer) readyDirs() ([]string, error) {
+	entries, err := os.ReadDir(i.config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasSuffix(entry.Name(), FailedSuffix) {
+			continue
+		}
+		dir := filepath.Join(i.config.Dir, entry.Name())
+		if _, err := os.Stat(filepath.Join(dir, ReadyFile)); err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, nil
+}
+
+// validate - checks the number of rows of the snapshot before it replaces the current prices.
+func (i *Importer) validate(records, expected, rows, current int64) error {
+	if expected > 0 && records != expected {
+		return fmt.Errorf("snapshot has records=%d, expected=%d", records, expected)
+	}
+	if rows == 0 || rows < i.config.MinRows {
+		return fmt.Errorf("snapshot has prices=%d, min=%d", rows, i.config.MinRows)
+	}
+	if i.config.MaxShrinkRatio > 0 && float64(rows) < float64(current)*(1-i.config.MaxShrinkRatio) {
+		return fmt.Errorf("snapshot has prices=%d, current prices=%d, max shrink ratio=%v", rows, current, i.config.MaxShrinkRatio)
+	}
+	return nil
+}
+
+// expectedRows - reads the expected number of rows from the ready file, 0 if it is empty.
+func expectedRows(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("can't read ready file=%s: %w", path, err)
+	}
+	content := strings.TrimSpace(string(data))
+	if content == "" {
+		return 0, nil
+	}
+	expected, err := strconv.ParseInt(content, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse expected rows=%s of ready file=%s: %w", content, path, err)
+	}
+	return expected, nil
+}
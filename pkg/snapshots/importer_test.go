@@ -0,0 +1,95 @@
+package snapshots
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"prices/pkg/config"
+	"prices/pkg/models"
+	"sync"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newTestImporter(t *testing.T) (*Importer, *MockPricesRepo) {
+	cfg := &config.Snapshots{
+		Dir:            t.TempDir(),
+		MinRows:        1,
+		MaxShrinkRatio: 0.5,
+	}
+
+	ctrl := gomock.NewController(t)
+
+	repo := NewMockPricesRepo(ctrl)
+
+	importer := NewImporter(context.Background(), &sync.WaitGroup{}, cfg, config.Retry{}, repo, zap.NewNop(), make(chan bool))
+
+	return importer, repo
+}
+
+func newTestSnapshot(t *testing.T, importer *Importer, name string, ready string, files ...string) string {
+	dir := filepath.Join(importer.config.Dir, name)
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	for _, file := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, file), []byte("test_id_1,1.0,2023-08-24 10:01:40 +0000 UTC\n"), 0644))
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, ReadyFile), []byte(ready), 0644))
+	return dir
+}
+
+func TestImporter_ImportReady(t *testing.T) {
+	importer, repo := newTestImporter(t)
+	ctx := importer.ctx
+	dir := newTestSnapshot(t, importer, "20230824", "2\n", "a.csv", "b.csv")
+	notReady := filepath.Join(importer.config.Dir, "20230825")
+	assert.NoError(t, os.MkdirAll(notReady, 0755))
+
+	gomock.InOrder(
+		repo.EXPECT().CreateStaging(ctx).Return(nil),
+		repo.EXPECT().ImportStagingFile(ctx, filepath.Join(dir, "a.csv")).Return(&models.ImportResult{Records: 1, RowsAffected: 1}, nil),
+		repo.EXPECT().ImportStagingFile(ctx, filepath.Join(dir, "b.csv")).Return(&models.ImportResult{Records: 1, RowsAffected: 1}, nil),
+		repo.EXPECT().CountStaging(ctx).Return(int64(2), nil),
+		repo.EXPECT().Count(ctx).Return(int64(3), nil),
+		repo.EXPECT().SwapStaging(ctx).Return(nil),
+	)
+
+	importer.importReady()
+
+	_, err := os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(notReady)
+	assert.NoError(t, err)
+}
+
+func TestImporter_ImportReady_Rejected(t *testing.T) {
+	importer, repo := newTestImporter(t)
+	ctx := importer.ctx
+	dir := newTestSnapshot(t, importer, "20230824", "", "a.csv")
+
+	gomock.InOrder(
+		repo.EXPECT().CreateStaging(ctx).Return(nil),
+		repo.EXPECT().ImportStagingFile(ctx, filepath.Join(dir, "a.csv")).Return(&models.ImportResult{Records: 1, RowsAffected: 1}, nil),
+		repo.EXPECT().CountStaging(ctx).Return(int64(1), nil),
+		repo.EXPECT().Count(ctx).Return(int64(100), nil),
+	)
+
+	importer.importReady()
+
+	_, err := os.Stat(dir + FailedSuffix)
+	assert.NoError(t, err)
+
+	importer.importReady()
+}
+
+func TestImporter_Validate(t *testing.T) {
+	importer, _ := newTestImporter(t)
+
+	assert.NoError(t, importer.validate(10, 10, 10, 15))
+	assert.NoError(t, importer.validate(10, 0, 10, 0))
+	assert.Error(t, importer.validate(9, 10, 9, 9))
+	assert.Error(t, importer.validate(0, 0, 0, 0))
+	assert.Error(t, importer.validate(10, 10, 4, 10))
+}
@@ -0,0 +1,123 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: importer.go
+
+// Package snapshots is a generated GoMock package.
+package snapshots
+
+import (
+	context "context"
+	models "prices/pkg/models"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockPricesRepo is a mock of PricesRepo interface.
+type MockPricesRepo struct {
+	ctrl     *gomock.Controller
+	recorder *MockPricesRepoMockRecorder
+}
+
+// MockPricesRepoMockRecorder is the mock recorder for MockPricesRepo.
+type MockPricesRepoMockRecorder struct {
+	mock *MockPricesRepo
+}
+
+// NewMockPricesRepo creates a new mock instance.
+func NewMockPricesRepo(ctrl *gomock.Controller) *MockPricesRepo {
+	mock := &MockPricesRepo{ctrl: ctrl}
+	mock.recorder = &MockPricesRepoMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPricesRepo) EXPECT() *MockPricesRepoMockRecorder {
+	return m.recorder
+}
+
+// Count mocks base method.
+func (m *MockPricesRepo) Count(ctx context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Count", ctx)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Count indicates an expected call of Count.
+func (mr *MockPricesRepoMockRecorder) Count(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Count", reflect.TypeOf((*MockPricesRepo)(nil).Count), ctx)
+}
+
+// CountStaging mocks base method.
+func (m *MockPricesRepo) CountStaging(ctx context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountStaging", ctx)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountStaging indicates an expected call of CountStaging.
+func (mr *MockPricesRepoMockRecorder) CountStaging(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountStaging", reflect.TypeOf((*MockPricesRepo)(nil).CountStaging), ctx)
+}
+
+// CreateStaging mocks base method.
+func (m *MockPricesRepo) CreateStaging(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateStaging", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateStaging indicates an expected call of CreateStaging.
+func (mr *MockPricesRepoMockRecorder) CreateStaging(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateStaging", reflect.TypeOf((*MockPricesRepo)(nil).CreateStaging), ctx)
+}
+
+// ImportStagingFile mocks base method.
+func (m *MockPricesRepo) ImportStagingFile(ctx context.Context, filePath string) (*models.ImportResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImportStagingFile", ctx, filePath)
+	ret0, _ := ret[0].(*models.ImportResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImportStagingFile indicates an expected call of ImportStagingFile.
+func (mr *MockPricesRepoMockRecorder) ImportStagingFile(ctx, filePath interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImportStagingFile", reflect.TypeOf((*MockPricesRepo)(nil).ImportStagingFile), ctx, filePath)
+}
+
+// RollbackSwap mocks base method.
+func (m *MockPricesRepo) RollbackSwap(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RollbackSwap", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RollbackSwap indicates an expected call of RollbackSwap.
+func (mr *MockPricesRepoMockRecorder) RollbackSwap(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RollbackSwap", reflect.TypeOf((*MockPricesRepo)(nil).RollbackSwap), ctx)
+}
+
+// SwapStaging mocks base method.
+func (m *MockPricesRepo) SwapStaging(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SwapStaging", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SwapStaging indicates an expected call of SwapStaging.
+func (mr *MockPricesRepoMockRecorder) SwapStaging(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SwapStaging", reflect.TypeOf((*MockPricesRepo)(nil).SwapStaging), ctx)
+}